@@ -0,0 +1,197 @@
+// Package audit keeps a tamper-evident trail of the calls that change data.
+// Every call of a mutating RPC gets one entry. Handlers append it with
+// Record in the transaction of the change, with what the interceptor can not
+// see: the post they changed and how it looked before and after. The
+// interceptor appends it for calls that failed or did not call Record.
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"learn-grpc/blog/repository"
+	"learn-grpc/blog/tenant"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+type contextKey struct{}
+
+// record is the audited call in progress
+type record struct {
+	mu sync.Mutex
+	// call holds the fields known when the call starts
+	call repository.AuditEntry
+	// written is set once the handler appended the entry in its transaction
+	written bool
+}
+
+func newContext(ctx context.Context, call repository.AuditEntry) (context.Context, *record) {
+	rec := &record{call: call}
+	return context.WithValue(ctx, contextKey{}, rec), rec
+}
+
+// Record appends the entry of the call in ctx with tx, so it is committed or
+// rolled back with the change it describes. targetID is the changed post,
+// the hashes are the ones of the post before and after the change (see
+// HashBlog). It does nothing outside an audited call. The transaction must
+// be run with WithTransaction, which retries it when another writer took
+// the place of the entry in the chain.
+func Record(ctx context.Context, tx repository.Tx, targetID, beforeHash, afterHash string) error {
+	rec, ok := ctx.Value(contextKey{}).(*record)
+	if !ok {
+		return nil
+	}
+	rec.mu.Lock()
+	entry := rec.call
+	rec.mu.Unlock()
+
+	entry.Time = time.Now()
+	entry.Code = codes.OK.String()
+	entry.TargetID, entry.BeforeHash, entry.AfterHash = targetID, beforeHash, afterHash
+	if id, ok := tenant.FromContext(ctx); ok {
+		entry.TenantID = id
+	}
+	if err := tx.Audit().Append(ctx, &entry); err != nil {
+		return err
+	}
+
+	rec.mu.Lock()
+	rec.written = true
+	rec.mu.Unlock()
+	return nil
+}
+
+// Describe sets the post a call changed in several transactions, like a live
+// editing session, the interceptor appends the entry after the call
+func Describe(ctx context.Context, targetID, beforeHash, afterHash string) {
+	rec, ok := ctx.Value(contextKey{}).(*record)
+	if !ok {
+		return
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.call.TargetID, rec.call.BeforeHash, rec.call.AfterHash = targetID, beforeHash, afterHash
+}
+
+// WithTransaction runs fn in a transaction of store, like
+// Store.WithTransaction, and runs it again when the entry appended with
+// Record lost its place in the chain. The whole transaction is retried: in
+// mongodb the failed insert aborts the transaction it ran in.
+func WithTransaction(ctx context.Context, store repository.Store, fn func(ctx context.Context, tx repository.Tx) error) error {
+	var err error
+	for i := 0; i < appendAttempts; i++ {
+		if err = store.WithTransaction(ctx, fn); !errors.Is(err, repository.ErrAuditConflict) {
+			break
+		}
+	}
+	return err
+}
+
+// appendEntry appends again when another writer took the place in the
+// chain, it is only used outside of transactions
+func appendEntry(ctx context.Context, repo repository.AuditRepository, entry *repository.AuditEntry) error {
+	var err error
+	for i := 0; i < appendAttempts; i++ {
+		if err = repo.Append(ctx, entry); !errors.Is(err, repository.ErrAuditConflict) {
+			break
+		}
+	}
+	return err
+}
+
+// HashBlog returns a hash of the fields a writer can change, counters are
+// left out so views and reactions do not change it. A nil post hashes to an
+// empty string.
+func HashBlog(item *repository.BlogItem) string {
+	if item == nil {
+		return ""
+	}
+	contributors := make([]string, 0, len(item.Contributors))
+	for _, c := range item.Contributors {
+		contributors = append(contributors, c.UserID+":"+c.Role)
+	}
+	translations := make(map[string][2]string, len(item.Translations))
+	for tag, t := range item.Translations {
+		translations[tag] = [2]string{t.Title, t.Content}
+	}
+	raw, err := json.Marshal(struct {
+		ID           string               `json:"id"`
+		TenantID     string               `json:"tenant_id"`
+		AuthorID     string               `json:"author_id"`
+		Title        string               `json:"title"`
+		Content      string               `json:"content"`
+		Tags         []string             `json:"tags"`
+		Status       string               `json:"status"`
+		Note         string               `json:"moderation_note"`
+		Contributors []string             `json:"contributors"`
+		Language     string               `json:"language,omitempty"`
+		Translations map[string][2]string `json:"translations,omitempty"`
+	}{item.ID.Hex(), item.TenantID, item.AuthorID, item.Title, item.Content, item.Tags, item.Status, item.ModerationNote, contributors, item.Language, translations})
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// ErrBrokenChain is returned by Chain.Check and Chain.Finish for an entry
+// that was changed, removed or does not follow the entry before it
+var ErrBrokenChain = errors.New("audit: broken chain")
+
+// Chain checks the whole chain of one tenant read in chain order, from the
+// first entry on. A listing that filters entries must check every entry and
+// filter afterwards.
+type Chain struct {
+	// Head is the head of the chain read before the entries, nil for a chain
+	// without one. Entries appended after it was read may follow.
+	Head *repository.AuditHead
+
+	last *repository.AuditEntry
+}
+
+// Check checks the next entry of the chain
+func (c *Chain) Check(entry *repository.AuditEntry) error {
+	last := c.last
+	c.last = entry
+
+	if entry.ComputeHash() != entry.Hash {
+		return fmt.Errorf("%w: entry %d does not match its hash", ErrBrokenChain, entry.Seq)
+	}
+	if c.Head != nil && entry.Seq == c.Head.Seq && entry.Hash != c.Head.Hash {
+		return fmt.Errorf("%w: entry %d is not the head of the chain", ErrBrokenChain, entry.Seq)
+	}
+	if last == nil {
+		if entry.Seq != 1 || entry.PrevHash != "" {
+			return fmt.Errorf("%w: the chain starts at entry %d", ErrBrokenChain, entry.Seq)
+		}
+		return nil
+	}
+	if entry.Seq != last.Seq+1 {
+		return fmt.Errorf("%w: entry %d comes after entry %d", ErrBrokenChain, entry.Seq, last.Seq)
+	}
+	if entry.PrevHash != last.Hash {
+		return fmt.Errorf("%w: entry %d does not follow entry %d", ErrBrokenChain, entry.Seq, last.Seq)
+	}
+	return nil
+}
+
+// Finish reports entries removed from the end of the chain, it is called
+// after the last entry was checked
+func (c *Chain) Finish() error {
+	if c.Head == nil {
+		return nil
+	}
+	if c.last == nil {
+		return fmt.Errorf("%w: the chain is empty, its head is entry %d", ErrBrokenChain, c.Head.Seq)
+	}
+	if c.last.Seq < c.Head.Seq {
+		return fmt.Errorf("%w: the chain ends at entry %d, its head is entry %d", ErrBrokenChain, c.last.Seq, c.Head.Seq)
+	}
+	return nil
+}
@@ -0,0 +1,275 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"learn-grpc/blog/repository"
+	"learn-grpc/blog/tenant"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// trail appends n entries to the chain of tenant acme and returns them with
+// the head of the chain
+func trail(t *testing.T, n int) ([]*repository.AuditEntry, *repository.AuditHead) {
+	t.Helper()
+	store := repository.NewMemoryStore(repository.Options{})
+	ctx := context.Background()
+	for i := 0; i < n; i++ {
+		entry := &repository.AuditEntry{TenantID: "acme", Time: time.Now(), Actor: "fajar", Method: "/blog.BlogService/UpdateBlog", Code: "OK"}
+		if err := store.Audit().Append(ctx, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var entries []*repository.AuditEntry
+	err := store.Audit().List(ctx, repository.AuditFilter{TenantID: "acme"}, func(entry *repository.AuditEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := store.Audit().Head(ctx, "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries, head
+}
+
+func check(chain *Chain, entries []*repository.AuditEntry) error {
+	for _, entry := range entries {
+		if err := chain.Check(entry); err != nil {
+			return err
+		}
+	}
+	return chain.Finish()
+}
+
+func TestChain(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(entries []*repository.AuditEntry, head *repository.AuditHead) ([]*repository.AuditEntry, *repository.AuditHead)
+		broken bool
+	}{
+		{
+			name: "untouched",
+		},
+		{
+			name: "changed field",
+			change: func(entries []*repository.AuditEntry, head *repository.AuditHead) ([]*repository.AuditEntry, *repository.AuditHead) {
+				entries[2].Actor = "someone else"
+				return entries, head
+			},
+			broken: true,
+		},
+		{
+			name: "changed field with its hash recomputed",
+			change: func(entries []*repository.AuditEntry, head *repository.AuditHead) ([]*repository.AuditEntry, *repository.AuditHead) {
+				entries[2].Actor = "someone else"
+				entries[2].Hash = entries[2].ComputeHash()
+				return entries, head
+			},
+			broken: true,
+		},
+		{
+			name: "deleted entry",
+			change: func(entries []*repository.AuditEntry, head *repository.AuditHead) ([]*repository.AuditEntry, *repository.AuditHead) {
+				return append(entries[:2], entries[3:]...), head
+			},
+			broken: true,
+		},
+		{
+			name: "deleted first entry",
+			change: func(entries []*repository.AuditEntry, head *repository.AuditHead) ([]*repository.AuditEntry, *repository.AuditHead) {
+				return entries[1:], head
+			},
+			broken: true,
+		},
+		{
+			name: "truncated",
+			change: func(entries []*repository.AuditEntry, head *repository.AuditHead) ([]*repository.AuditEntry, *repository.AuditHead) {
+				return entries[:3], head
+			},
+			broken: true,
+		},
+		{
+			name: "every entry deleted",
+			change: func(entries []*repository.AuditEntry, head *repository.AuditHead) ([]*repository.AuditEntry, *repository.AuditHead) {
+				return nil, head
+			},
+			broken: true,
+		},
+		{
+			name: "last entry rewritten",
+			change: func(entries []*repository.AuditEntry, head *repository.AuditHead) ([]*repository.AuditEntry, *repository.AuditHead) {
+				last := entries[len(entries)-1]
+				last.Code = codes.PermissionDenied.String()
+				last.Hash = last.ComputeHash()
+				return entries, head
+			},
+			broken: true,
+		},
+		{
+			name: "appended after the head was read",
+			change: func(entries []*repository.AuditEntry, head *repository.AuditHead) ([]*repository.AuditEntry, *repository.AuditHead) {
+				return entries, &repository.AuditHead{TenantID: "acme", Seq: entries[3].Seq, Hash: entries[3].Hash}
+			},
+		},
+		{
+			name: "chain without a head",
+			change: func(entries []*repository.AuditEntry, head *repository.AuditHead) ([]*repository.AuditEntry, *repository.AuditHead) {
+				return entries, nil
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, head := trail(t, 5)
+			if tt.change != nil {
+				entries, head = tt.change(entries, head)
+			}
+			err := check(&Chain{Head: head}, entries)
+			if tt.broken && !errors.Is(err, ErrBrokenChain) {
+				t.Fatalf("err = %v, want ErrBrokenChain", err)
+			}
+			if !tt.broken && err != nil {
+				t.Fatalf("err = %v, want nil", err)
+			}
+		})
+	}
+}
+
+func TestRecordIsPartOfTheTransaction(t *testing.T) {
+	store := repository.NewMemoryStore(repository.Options{})
+	logger := &Logger{Store: store}
+	ctx := tenant.NewContext(context.Background(), "acme")
+	errRollback := status.Errorf(codes.Aborted, "rolled back")
+
+	tests := []struct {
+		name string
+		fail bool
+		code string
+	}{
+		{name: "committed", code: codes.OK.String()},
+		{name: "rolled back", fail: true, code: codes.Aborted.String()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			head, _ := store.Audit().Head(ctx, "acme")
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				err := store.WithTransaction(ctx, func(ctx context.Context, tx repository.Tx) error {
+					if err := Record(ctx, tx, "5f5b0a6e8f1b2c3d4e5f6a7b", "before", "after"); err != nil {
+						return err
+					}
+					if tt.fail {
+						return errRollback
+					}
+					return nil
+				})
+				return nil, err
+			}
+			info := &grpc.UnaryServerInfo{FullMethod: "/blog.BlogService/UpdateBlog"}
+			logger.UnaryServerInterceptor()(ctx, nil, info, handler)
+
+			var entries []*repository.AuditEntry
+			store.Audit().List(ctx, repository.AuditFilter{TenantID: "acme"}, func(entry *repository.AuditEntry) error {
+				if head == nil || entry.Seq > head.Seq {
+					entries = append(entries, entry)
+				}
+				return nil
+			})
+			if len(entries) != 1 {
+				t.Fatalf("the call has %d entries, want 1", len(entries))
+			}
+			if entries[0].Code != tt.code {
+				t.Errorf("code = %s, want %s", entries[0].Code, tt.code)
+			}
+			if written := entries[0].AfterHash != ""; written == tt.fail {
+				t.Errorf("entry written by the handler = %v, want %v", written, !tt.fail)
+			}
+		})
+	}
+}
+
+// conflictStore makes the first conflicts appends in its transactions lose
+// their place in the chain
+type conflictStore struct {
+	*repository.MemoryStore
+	conflicts int
+	runs      int
+}
+
+func (s *conflictStore) WithTransaction(ctx context.Context, fn func(ctx context.Context, tx repository.Tx) error) error {
+	return s.MemoryStore.WithTransaction(ctx, func(ctx context.Context, tx repository.Tx) error {
+		s.runs++
+		return fn(ctx, &conflictTx{Tx: tx, store: s})
+	})
+}
+
+type conflictTx struct {
+	repository.Tx
+	store *conflictStore
+}
+
+func (tx *conflictTx) Audit() repository.AuditRepository {
+	return &conflictAudit{AuditRepository: tx.Tx.Audit(), store: tx.store}
+}
+
+type conflictAudit struct {
+	repository.AuditRepository
+	store *conflictStore
+}
+
+func (a *conflictAudit) Append(ctx context.Context, entry *repository.AuditEntry) error {
+	if a.store.conflicts > 0 {
+		a.store.conflicts--
+		return repository.ErrAuditConflict
+	}
+	return a.AuditRepository.Append(ctx, entry)
+}
+
+func TestWithTransactionRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		conflicts int
+		runs      int
+		err       error
+	}{
+		{name: "no conflict", conflicts: 0, runs: 1},
+		{name: "conflicts", conflicts: 2, runs: 3},
+		{name: "too many conflicts", conflicts: appendAttempts, runs: appendAttempts, err: repository.ErrAuditConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &conflictStore{MemoryStore: repository.NewMemoryStore(repository.Options{}), conflicts: tt.conflicts}
+			ctx, _ := newContext(tenant.NewContext(context.Background(), "acme"), repository.AuditEntry{Method: "/blog.BlogService/UpdateBlog"})
+
+			err := WithTransaction(ctx, store, func(ctx context.Context, tx repository.Tx) error {
+				return Record(ctx, tx, "5f5b0a6e8f1b2c3d4e5f6a7b", "before", "after")
+			})
+			if !errors.Is(err, tt.err) || (tt.err == nil && err != nil) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if store.runs != tt.runs {
+				t.Errorf("ran %d times, want %d", store.runs, tt.runs)
+			}
+			entries := 0
+			store.Audit().List(ctx, repository.AuditFilter{TenantID: "acme"}, func(entry *repository.AuditEntry) error {
+				entries++
+				return nil
+			})
+			want := 1
+			if tt.err != nil {
+				want = 0
+			}
+			if entries != want {
+				t.Errorf("%d entries, want %d", entries, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package audit
+
+import (
+	"context"
+	"learn-grpc/blog/auth"
+	"learn-grpc/blog/domain"
+	"learn-grpc/blog/repository"
+	"learn-grpc/blog/tenant"
+	"log"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// appendAttempts bounds the retries when other servers append to the same chain
+	appendAttempts = 5
+	appendTimeout  = 10 * time.Second
+)
+
+// Logger appends an audit entry for every call of a mutating RPC
+type Logger struct {
+	Store repository.Store
+	// Secret verifies bearer tokens to find the actor
+	Secret []byte
+	// Skip reports whether a method is not audited, e.g. health checks
+	Skip func(fullMethod string) bool
+}
+
+// IsMutating treats every method as mutating unless its name starts with
+// Get, List, Read or Watch, so new RPCs are audited without changes here
+func IsMutating(fullMethod string) bool {
+	name := fullMethod[strings.LastIndex(fullMethod, "/")+1:]
+	for _, prefix := range []string{"Get", "List", "Read", "Watch"} {
+		if strings.HasPrefix(name, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+func (l *Logger) audited(fullMethod string) bool {
+	if l.Skip != nil && l.Skip(fullMethod) {
+		return false
+	}
+	return IsMutating(fullMethod)
+}
+
+// UnaryServerInterceptor audits unary calls, it must run after the tenant
+// resolver so the tenant is in the context
+func (l *Logger) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !l.audited(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		ctx, rec := newContext(ctx, l.call(ctx, info.FullMethod))
+		res, err := handler(ctx, req)
+		l.append(ctx, rec, err, req, res)
+		return res, err
+	}
+}
+
+// StreamServerInterceptor audits streaming calls when the stream ends
+func (l *Logger) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !l.audited(info.FullMethod) {
+			return handler(srv, ss)
+		}
+		ctx, rec := newContext(ss.Context(), l.call(ss.Context(), info.FullMethod))
+		err := handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+		l.append(ctx, rec, err)
+		return err
+	}
+}
+
+// call returns the fields of the entry known when the call starts
+func (l *Logger) call(ctx context.Context, method string) repository.AuditEntry {
+	call := repository.AuditEntry{Method: method}
+	call.Actor, call.ActorVerified = auth.Identity(ctx, l.Secret)
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		call.Peer = p.Addr.String()
+	}
+	call.TenantID, _ = tenant.FromContext(ctx)
+	return call
+}
+
+// append writes the entry of a finished call unless the handler wrote it in
+// its transaction. It is written on its own, after the call, so a failure is
+// only logged.
+func (l *Logger) append(ctx context.Context, rec *record, callErr error, msgs ...interface{}) {
+	rec.mu.Lock()
+	entry, written := rec.call, rec.written
+	rec.mu.Unlock()
+	// a failed call rolled its transaction back, the entry with it
+	if written && callErr == nil {
+		return
+	}
+
+	entry.Time = time.Now()
+	entry.Code = status.Code(callErr).String()
+	for _, msg := range msgs {
+		// admin requests name their tenant in the request
+		if m, ok := msg.(interface{ GetTenantId() string }); ok && entry.TenantID == "" {
+			entry.TenantID = m.GetTenantId()
+		}
+		if entry.TargetID == "" {
+			entry.TargetID = targetOf(msg)
+		}
+	}
+
+	// the deadline of the call must not stop the entry from being written
+	writeCtx, cancel := context.WithTimeout(context.Background(), appendTimeout)
+	defer cancel()
+
+	if err := appendEntry(writeCtx, l.Store.Audit(), &entry); err != nil {
+		log.Printf("audit: failed to record %s by %q: %v", entry.Method, entry.Actor, err)
+	}
+}
+
+// targetOf finds the id of the changed object in a request or response
+func targetOf(msg interface{}) string {
+	switch m := msg.(type) {
+	case interface{ GetBlogId() string }:
+		return m.GetBlogId()
+	case interface{ GetWebhookId() string }:
+		return m.GetWebhookId()
+	case interface{ GetBlog() *domain.Blog }:
+		return m.GetBlog().GetId()
+	case interface{ GetWebhook() *domain.Webhook }:
+		return m.GetWebhook().GetId()
+	}
+	return ""
+}
+
+// serverStream replaces the context of a grpc.ServerStream
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// RoleAdmin is the role claim of the tokens allowed to call the admin service
+const RoleAdmin = "admin"
+
+// Admin is the caller of an admin method
+type Admin struct {
+	// Subject is the sub claim of the token or the common name of the
+	// client certificate
+	Subject string
+	// TenantID limits the admin to one tenant, empty for an admin of every
+	// tenant
+	TenantID string
+}
+
+type adminKey struct{}
+
+// NewAdminContext returns a copy of ctx carrying the admin
+func NewAdminContext(ctx context.Context, admin *Admin) context.Context {
+	return context.WithValue(ctx, adminKey{}, admin)
+}
+
+// AdminFromContext returns the admin stored by NewAdminContext
+func AdminFromContext(ctx context.Context) (*Admin, bool) {
+	admin, ok := ctx.Value(adminKey{}).(*Admin)
+	return admin, ok && admin != nil
+}
+
+// AdminGuard only lets admins call the methods it matches. An admin either
+// sends a bearer token with the admin role, its tenant_id claim limits it to
+// that tenant, or connects with a client certificate the server verified,
+// which is an admin of every tenant.
+type AdminGuard struct {
+	// Secret verifies bearer tokens, without it only client certificates
+	// are accepted
+	Secret []byte
+	// Match reports whether a method is for admins only, nil matches every
+	// method
+	Match func(fullMethod string) bool
+}
+
+// Authenticate returns the admin making the request in ctx
+func (g *AdminGuard) Authenticate(ctx context.Context) (*Admin, error) {
+	if len(g.Secret) > 0 {
+		claims, err := FromIncomingContext(ctx, g.Secret)
+		switch {
+		case err != nil:
+			return nil, status.Errorf(codes.Unauthenticated, "invalid bearer token: %v", err)
+		case claims != nil && claims.Role != RoleAdmin:
+			return nil, status.Errorf(codes.PermissionDenied, "%s does not have the %s role", claims.Subject, RoleAdmin)
+		case claims != nil:
+			return &Admin{Subject: claims.Subject, TenantID: claims.TenantID}, nil
+		}
+	}
+	if name := verifiedClient(ctx); name != "" {
+		return &Admin{Subject: name}, nil
+	}
+	return nil, status.Errorf(codes.Unauthenticated, "send a bearer token with the %s role or a client certificate", RoleAdmin)
+}
+
+// verifiedClient returns the common name of the client certificate of a
+// verified TLS connection
+func verifiedClient(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	cert := info.State.VerifiedChains[0][0]
+	if cert.Subject.CommonName == "" {
+		return cert.SerialNumber.String()
+	}
+	return cert.Subject.CommonName
+}
+
+// CheckTenant returns a PermissionDenied error when the admin in ctx may not
+// act on tenantID, an empty tenantID stands for every tenant
+func CheckTenant(ctx context.Context, tenantID string) error {
+	admin, ok := AdminFromContext(ctx)
+	if !ok {
+		return status.Errorf(codes.Unauthenticated, "only an admin can do this")
+	}
+	if admin.TenantID == "" || admin.TenantID == tenantID {
+		return nil
+	}
+	if tenantID == "" {
+		return status.Errorf(codes.PermissionDenied, "%s is an admin of tenant %s only", admin.Subject, admin.TenantID)
+	}
+	return status.Errorf(codes.PermissionDenied, "%s is not an admin of tenant %s", admin.Subject, tenantID)
+}
+
+// UnaryServerInterceptor rejects unary calls of the matched methods made by
+// anyone but an admin
+func (g *AdminGuard) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if g.Match != nil && !g.Match(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		admin, err := g.Authenticate(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return handler(NewAdminContext(ctx, admin), req)
+	}
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streams
+func (g *AdminGuard) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if g.Match != nil && !g.Match(info.FullMethod) {
+			return handler(srv, ss)
+		}
+		admin, err := g.Authenticate(ss.Context())
+		if err != nil {
+			return err
+		}
+		return handler(srv, &adminStream{ServerStream: ss, ctx: NewAdminContext(ss.Context(), admin)})
+	}
+}
+
+// adminStream replaces the context of a grpc.ServerStream
+type adminStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *adminStream) Context() context.Context {
+	return s.ctx
+}
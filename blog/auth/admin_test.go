@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+var secret = []byte("test secret")
+
+func bearer(t *testing.T, claims *Claims, key []byte) context.Context {
+	t.Helper()
+	token, err := SignToken(claims, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+}
+
+func withClientCert(ctx context.Context, name string) context.Context {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: name}}
+	return peer.NewContext(ctx, &peer.Peer{AuthInfo: credentials.TLSInfo{
+		State: tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}},
+	}})
+}
+
+func TestAdminGuard(t *testing.T) {
+	guard := &AdminGuard{
+		Secret: secret,
+		Match: func(fullMethod string) bool {
+			return fullMethod == "/blog.BlogAdminService/RegisterWebhook"
+		},
+	}
+
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		method string
+		code   codes.Code
+		admin  *Admin
+	}{
+		{
+			name:   "no credentials",
+			ctx:    context.Background(),
+			method: "/blog.BlogAdminService/RegisterWebhook",
+			code:   codes.Unauthenticated,
+		},
+		{
+			name:   "user metadata is not enough",
+			ctx:    metadata.NewIncomingContext(context.Background(), metadata.Pairs(UserMetadataKey, "root")),
+			method: "/blog.BlogAdminService/RegisterWebhook",
+			code:   codes.Unauthenticated,
+		},
+		{
+			name:   "token without the admin role",
+			ctx:    bearer(t, &Claims{Subject: "fajar", TenantID: "acme"}, secret),
+			method: "/blog.BlogAdminService/RegisterWebhook",
+			code:   codes.PermissionDenied,
+		},
+		{
+			name:   "admin token signed with another secret",
+			ctx:    bearer(t, &Claims{Subject: "fajar", Role: RoleAdmin}, []byte("other")),
+			method: "/blog.BlogAdminService/RegisterWebhook",
+			code:   codes.Unauthenticated,
+		},
+		{
+			name:   "admin token",
+			ctx:    bearer(t, &Claims{Subject: "fajar", TenantID: "acme", Role: RoleAdmin}, secret),
+			method: "/blog.BlogAdminService/RegisterWebhook",
+			code:   codes.OK,
+			admin:  &Admin{Subject: "fajar", TenantID: "acme"},
+		},
+		{
+			name:   "verified client certificate",
+			ctx:    withClientCert(context.Background(), "ops"),
+			method: "/blog.BlogAdminService/RegisterWebhook",
+			code:   codes.OK,
+			admin:  &Admin{Subject: "ops"},
+		},
+		{
+			name:   "method not guarded",
+			ctx:    context.Background(),
+			method: "/blog.BlogService/ReadBlog",
+			code:   codes.OK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *Admin
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				got, _ = AdminFromContext(ctx)
+				return nil, nil
+			}
+			_, err := guard.UnaryServerInterceptor()(tt.ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+			if code := status.Code(err); code != tt.code {
+				t.Fatalf("code = %s, want %s (%v)", code, tt.code, err)
+			}
+			if tt.code != codes.OK {
+				return
+			}
+			if (got == nil) != (tt.admin == nil) || (got != nil && *got != *tt.admin) {
+				t.Errorf("admin = %+v, want %+v", got, tt.admin)
+			}
+		})
+	}
+}
+
+func TestAdminGuardStream(t *testing.T) {
+	guard := &AdminGuard{Secret: secret}
+	called := false
+	handler := func(srv interface{}, ss grpc.ServerStream) error {
+		called = true
+		return nil
+	}
+	info := &grpc.StreamServerInfo{FullMethod: "/blog.BlogAdminService/ListAuditEvents"}
+
+	err := guard.StreamServerInterceptor()(nil, &fakeStream{ctx: context.Background()}, info, handler)
+	if status.Code(err) != codes.Unauthenticated || called {
+		t.Fatalf("stream without credentials: err = %v, handler called = %v", err, called)
+	}
+}
+
+type fakeStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *fakeStream) Context() context.Context { return s.ctx }
+
+func TestCheckTenant(t *testing.T) {
+	tests := []struct {
+		name   string
+		admin  *Admin
+		tenant string
+		code   codes.Code
+	}{
+		{name: "no admin", tenant: "acme", code: codes.Unauthenticated},
+		{name: "admin of every tenant", admin: &Admin{Subject: "ops"}, tenant: "acme", code: codes.OK},
+		{name: "admin of every tenant, every tenant", admin: &Admin{Subject: "ops"}, tenant: "", code: codes.OK},
+		{name: "own tenant", admin: &Admin{Subject: "a", TenantID: "acme"}, tenant: "acme", code: codes.OK},
+		{name: "other tenant", admin: &Admin{Subject: "a", TenantID: "acme"}, tenant: "globex", code: codes.PermissionDenied},
+		{name: "every tenant", admin: &Admin{Subject: "a", TenantID: "acme"}, tenant: "", code: codes.PermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.admin != nil {
+				ctx = NewAdminContext(ctx, tt.admin)
+			}
+			if code := status.Code(CheckTenant(ctx, tt.tenant)); code != tt.code {
+				t.Errorf("code = %s, want %s", code, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package backup
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"learn-grpc/blog/repository"
+	"learn-grpc/blog/tenant"
+	"os"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Conflict decides what happens to a post of the snapshot that is already
+// in the store
+type Conflict int
+
+const (
+	// ConflictSkip keeps the post in the store
+	ConflictSkip Conflict = iota
+	// ConflictOverwrite replaces the post in the store with the snapshot
+	ConflictOverwrite
+	// ConflictFail restores nothing when any post is already in the store
+	ConflictFail
+)
+
+// ErrConflict is returned by Restore with ConflictFail
+var ErrConflict = errors.New("backup: posts already exist")
+
+// maxReported bounds the ids listed in a report
+const maxReported = 100
+
+// RestoreOptions configures a restore
+type RestoreOptions struct {
+	Conflict Conflict
+	// DryRun reports what would be restored without writing anything
+	DryRun bool
+	// TenantID restores only the posts of one tenant, empty restores all
+	TenantID string
+	// Restored is called for every post written, e.g. to update indexes
+	Restored func(item *repository.BlogItem)
+}
+
+// Report tells what a restore did, or would do on a dry run
+type Report struct {
+	Snapshot    *Snapshot
+	Posts       int
+	Created     int
+	Overwritten int
+	Skipped     int
+	// Conflicts lists the first ids of posts that were already in the store
+	Conflicts []string
+}
+
+// Restore writes the posts of a snapshot into store. Posts are written one
+// by one, a failure halfway leaves the posts written so far in place, run
+// the restore again with ConflictOverwrite to finish it.
+func Restore(ctx context.Context, store repository.Store, snap *Snapshot, opts RestoreOptions) (*Report, error) {
+	report := &Report{Snapshot: snap}
+
+	// with ConflictFail every post is checked before the first write
+	if opts.Conflict == ConflictFail && !opts.DryRun {
+		check := opts
+		check.DryRun = true
+		checked, err := Restore(ctx, store, snap, check)
+		if err != nil {
+			return nil, err
+		}
+		if len(checked.Conflicts) > 0 {
+			return checked, fmt.Errorf("%w: %d of %d", ErrConflict, checked.Overwritten+checked.Skipped, checked.Posts)
+		}
+	}
+
+	err := read(snap.Path, func(rec *record) error {
+		if rec.Kind != kindBlog || rec.Blog == nil {
+			return nil
+		}
+		item := rec.Blog
+		if opts.TenantID != "" && item.TenantID != opts.TenantID {
+			return nil
+		}
+		report.Posts++
+
+		exists, err := exists(ctx, store, item)
+		if err != nil {
+			return err
+		}
+		if exists {
+			if len(report.Conflicts) < maxReported {
+				report.Conflicts = append(report.Conflicts, item.ID.Hex())
+			}
+			if opts.Conflict != ConflictOverwrite {
+				report.Skipped++
+				return nil
+			}
+			report.Overwritten++
+		} else {
+			report.Created++
+		}
+
+		if opts.DryRun {
+			return nil
+		}
+		if err := store.RestoreBlog(ctx, item, rec.Reactions, rec.Revisions); err != nil {
+			return fmt.Errorf("backup: restore %s: %w", item.ID.Hex(), err)
+		}
+		if opts.Restored != nil {
+			opts.Restored(item)
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// exists reports whether the store already has the post
+func exists(ctx context.Context, store repository.Store, item *repository.BlogItem) (bool, error) {
+	_, err := store.Blogs().FindByID(tenant.NewContext(ctx, item.TenantID), item.ID.Hex())
+	if errors.Is(err, repository.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// read calls fn for every record of a snapshot
+func read(path string, fn func(*record) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("backup: open snapshot: %w", err)
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return fmt.Errorf("backup: decompress snapshot: %w", err)
+	}
+	defer zr.Close()
+
+	scanner := bufio.NewScanner(zr)
+	// a line holds a whole post
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		rec := new(record)
+		if err := bson.UnmarshalExtJSON(scanner.Bytes(), true, rec); err != nil {
+			return fmt.Errorf("backup: line %d: %w", line, err)
+		}
+		if rec.Kind == kindHeader && rec.Version > formatVersion {
+			return fmt.Errorf("backup: snapshot format %d is newer than %d", rec.Version, formatVersion)
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("backup: read snapshot: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"learn-grpc/blog/repository"
+	"learn-grpc/blog/tenant"
+	"testing"
+	"time"
+)
+
+// snapshot takes a snapshot of a post of acme with a reaction and a post of
+// globex, and returns it with the two posts
+func snapshot(t *testing.T) (*Snapshot, *repository.BlogItem, *repository.BlogItem) {
+	t.Helper()
+	store := repository.NewMemoryStore(repository.Options{})
+	acme := &repository.BlogItem{AuthorID: "fajar", Title: "acme post", Content: "hello", Status: repository.StatusPublished, CreatedAt: time.Now()}
+	if err := store.Blogs().Create(tenant.NewContext(context.Background(), "acme"), acme); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Reactions().Add(tenant.NewContext(context.Background(), "acme"), acme.ID.Hex(), "sari", "like"); err != nil {
+		t.Fatal(err)
+	}
+	globex := &repository.BlogItem{AuthorID: "budi", Title: "globex post", Content: "hello", Status: repository.StatusPublished, CreatedAt: time.Now()}
+	if err := store.Blogs().Create(tenant.NewContext(context.Background(), "globex"), globex); err != nil {
+		t.Fatal(err)
+	}
+
+	snap, err := (&Snapshotter{Store: store, Dir: t.TempDir()}).Snapshot(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return snap, acme, globex
+}
+
+func TestRestore(t *testing.T) {
+	snap, acme, globex := snapshot(t)
+
+	tests := []struct {
+		name string
+		opts RestoreOptions
+		// changed puts a changed acme post in the store before the restore
+		changed bool
+		err     error
+		report  Report
+		// titles of the posts in the store after the restore, empty when
+		// the post is missing
+		acme, globex string
+	}{
+		{name: "empty store", report: Report{Posts: 2, Created: 2}, acme: "acme post", globex: "globex post"},
+		{name: "one tenant", opts: RestoreOptions{TenantID: "globex"}, report: Report{Posts: 1, Created: 1}, globex: "globex post"},
+		{
+			name:    "skip",
+			opts:    RestoreOptions{Conflict: ConflictSkip},
+			changed: true,
+			report:  Report{Posts: 2, Created: 1, Skipped: 1, Conflicts: []string{acme.ID.Hex()}},
+			acme:    "changed", globex: "globex post",
+		},
+		{
+			name:    "overwrite",
+			opts:    RestoreOptions{Conflict: ConflictOverwrite},
+			changed: true,
+			report:  Report{Posts: 2, Created: 1, Overwritten: 1, Conflicts: []string{acme.ID.Hex()}},
+			acme:    "acme post", globex: "globex post",
+		},
+		{
+			name:    "fail",
+			opts:    RestoreOptions{Conflict: ConflictFail},
+			changed: true,
+			err:     ErrConflict,
+			report:  Report{Posts: 2, Created: 1, Skipped: 1, Conflicts: []string{acme.ID.Hex()}},
+			acme:    "changed",
+		},
+		{name: "fail without conflicts", opts: RestoreOptions{Conflict: ConflictFail}, report: Report{Posts: 2, Created: 2}, acme: "acme post", globex: "globex post"},
+		{
+			name:    "dry run",
+			opts:    RestoreOptions{Conflict: ConflictOverwrite, DryRun: true},
+			changed: true,
+			report:  Report{Posts: 2, Created: 1, Overwritten: 1, Conflicts: []string{acme.ID.Hex()}},
+			acme:    "changed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := repository.NewMemoryStore(repository.Options{})
+			if tt.changed {
+				changed := *acme
+				changed.Title = "changed"
+				if err := store.RestoreBlog(context.Background(), &changed, nil, nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var restored int
+			tt.opts.Restored = func(item *repository.BlogItem) { restored++ }
+			report, err := Restore(context.Background(), store, snap, tt.opts)
+			if !errors.Is(err, tt.err) || (tt.err == nil && err != nil) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if report.Posts != tt.report.Posts || report.Created != tt.report.Created || report.Overwritten != tt.report.Overwritten || report.Skipped != tt.report.Skipped {
+				t.Errorf("report %+v, want %+v", report, tt.report)
+			}
+			if len(report.Conflicts) != len(tt.report.Conflicts) || (len(report.Conflicts) > 0 && report.Conflicts[0] != tt.report.Conflicts[0]) {
+				t.Errorf("conflicts %v, want %v", report.Conflicts, tt.report.Conflicts)
+			}
+			want := report.Created + report.Overwritten
+			if tt.opts.DryRun || tt.err != nil {
+				want = 0
+			}
+			if restored != want {
+				t.Errorf("Restored called %d times, want %d", restored, want)
+			}
+
+			for _, post := range []struct {
+				item  *repository.BlogItem
+				title string
+			}{{acme, tt.acme}, {globex, tt.globex}} {
+				found, err := store.Blogs().FindByID(tenant.NewContext(context.Background(), post.item.TenantID), post.item.ID.Hex())
+				switch {
+				case post.title == "" && !errors.Is(err, repository.ErrNotFound):
+					t.Errorf("%s: err = %v, want ErrNotFound", post.item.TenantID, err)
+				case post.title != "" && err != nil:
+					t.Errorf("%s: %v", post.item.TenantID, err)
+				case post.title != "" && found.Title != post.title:
+					t.Errorf("%s: title %q, want %q", post.item.TenantID, found.Title, post.title)
+				}
+			}
+		})
+	}
+}
+
+func TestRestoreKeepsReactions(t *testing.T) {
+	snap, acme, _ := snapshot(t)
+	store := repository.NewMemoryStore(repository.Options{})
+	if _, err := Restore(context.Background(), store, snap, RestoreOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	found, err := store.Blogs().FindByID(tenant.NewContext(context.Background(), "acme"), acme.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Reactions["like"] != 1 {
+		t.Errorf("reactions = %v, want one like", found.Reactions)
+	}
+	// the restored reaction is the one of sari, she can not like it twice
+	added, err := store.Reactions().Add(tenant.NewContext(context.Background(), "acme"), acme.ID.Hex(), "sari", "like")
+	if err != nil || added {
+		t.Errorf("Add = %v, %v, want the reaction to exist", added, err)
+	}
+}
@@ -0,0 +1,286 @@
+// Package backup writes snapshots of the blog data to a directory and
+// restores them.
+//
+// A snapshot is a gzip compressed file of extended JSON lines named after
+// the time it was taken, e.g. blog-20060102T150405Z.jsonl.gz. The first
+// line is a header, every other line holds one post with its reactions and
+// revisions.
+// Files are written under a temporary name and renamed when complete, so a
+// crash never leaves a partial snapshot behind.
+package backup
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"learn-grpc/blog/repository"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// default values used when the matching field of Snapshotter is zero
+const (
+	DefaultInterval = time.Hour
+	DefaultKeep     = 24
+)
+
+const (
+	formatVersion = 2
+	filePrefix    = "blog-"
+	fileSuffix    = ".jsonl.gz"
+	timeLayout    = "20060102T150405Z"
+)
+
+// ErrNoSnapshot is returned when no snapshot matches the request
+var ErrNoSnapshot = errors.New("backup: no snapshot found")
+
+// record kinds
+const (
+	kindHeader = "header"
+	kindBlog   = "blog"
+)
+
+// record is one line of a snapshot
+type record struct {
+	Kind string `bson:"kind"`
+
+	// header
+	Version   int       `bson:"version,omitempty"`
+	CreatedAt time.Time `bson:"created_at,omitempty"`
+
+	// blog
+	Blog      *repository.BlogItem   `bson:"blog,omitempty"`
+	Reactions []*repository.Reaction `bson:"reactions,omitempty"`
+	Revisions []*repository.Revision `bson:"revisions,omitempty"`
+}
+
+// Snapshot is a snapshot file in the backup directory
+type Snapshot struct {
+	Name string
+	Path string
+	// Time the snapshot was taken, from its name
+	Time time.Time
+	Size int64
+}
+
+// Snapshotter takes a snapshot every Interval and removes old ones. It keeps
+// the Keep newest snapshots, and of those drops the ones older than MaxAge
+// when it is set.
+type Snapshotter struct {
+	Store    repository.Store
+	Dir      string
+	Interval time.Duration
+	Keep     int
+	MaxAge   time.Duration
+}
+
+// Run takes a snapshot every Interval until ctx is canceled
+func (s *Snapshotter) Run(ctx context.Context) {
+	interval := s.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.Snapshot(ctx); err != nil {
+				log.Printf("backup: snapshot failed: %v", err)
+			}
+		}
+	}
+}
+
+// Snapshot writes a snapshot of every tenant now and applies the retention
+// policy. Posts, reactions and revisions are read one after the other, not
+// at a single point in time, a reaction given in between may be missing.
+func (s *Snapshotter) Snapshot(ctx context.Context) (*Snapshot, error) {
+	if err := os.MkdirAll(s.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("backup: create directory: %w", err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	name := filePrefix + now.Format(timeLayout) + fileSuffix
+	path := filepath.Join(s.Dir, name)
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("backup: snapshot %s already exists", name)
+	}
+
+	tmp, err := os.CreateTemp(s.Dir, ".tmp-"+name+"-*")
+	if err != nil {
+		return nil, fmt.Errorf("backup: create file: %w", err)
+	}
+	// removing the renamed file fails, which is fine
+	defer os.Remove(tmp.Name())
+
+	if err := s.write(ctx, tmp, now); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return nil, fmt.Errorf("backup: sync: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("backup: close: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return nil, fmt.Errorf("backup: rename: %w", err)
+	}
+
+	if err := s.prune(now); err != nil {
+		log.Printf("backup: retention failed: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{Name: name, Path: path, Time: now, Size: info.Size()}, nil
+}
+
+func (s *Snapshotter) write(ctx context.Context, f *os.File, now time.Time) error {
+	// reactions and revisions are grouped by post so a post is restored with
+	// them
+	reactions := map[string][]*repository.Reaction{}
+	err := s.Store.ScanReactions(ctx, func(reaction *repository.Reaction) error {
+		key := reaction.BlogID.Hex()
+		reactions[key] = append(reactions[key], reaction)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("backup: read reactions: %w", err)
+	}
+	revisions := map[string][]*repository.Revision{}
+	err = s.Store.ScanRevisions(ctx, func(rev *repository.Revision) error {
+		key := rev.BlogID.Hex()
+		revisions[key] = append(revisions[key], rev)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("backup: read revisions: %w", err)
+	}
+
+	buf := bufio.NewWriter(f)
+	zw := gzip.NewWriter(buf)
+	writeRecord := func(rec *record) error {
+		line, err := bson.MarshalExtJSON(rec, true, false)
+		if err != nil {
+			return err
+		}
+		if _, err := zw.Write(append(line, '\n')); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if err := writeRecord(&record{Kind: kindHeader, Version: formatVersion, CreatedAt: now}); err != nil {
+		return fmt.Errorf("backup: write header: %w", err)
+	}
+	err = s.Store.Scan(ctx, func(item *repository.BlogItem) error {
+		return writeRecord(&record{Kind: kindBlog, Blog: item, Reactions: reactions[item.ID.Hex()], Revisions: revisions[item.ID.Hex()]})
+	})
+	if err != nil {
+		return fmt.Errorf("backup: write posts: %w", err)
+	}
+
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("backup: compress: %w", err)
+	}
+	return buf.Flush()
+}
+
+// prune applies the retention policy, the snapshot just taken is always kept
+func (s *Snapshotter) prune(now time.Time) error {
+	keep := s.Keep
+	if keep <= 0 {
+		keep = DefaultKeep
+	}
+	snapshots, err := List(s.Dir)
+	if err != nil {
+		return err
+	}
+
+	// List returns the newest first
+	for i, snap := range snapshots {
+		expired := s.MaxAge > 0 && now.Sub(snap.Time) > s.MaxAge
+		if i == 0 || (i < keep && !expired) {
+			continue
+		}
+		if err := os.Remove(snap.Path); err != nil {
+			return err
+		}
+		log.Printf("backup: removed snapshot %s", snap.Name)
+	}
+	return nil
+}
+
+// List returns the snapshots in dir, newest first
+func List(dir string) ([]*Snapshot, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("backup: read directory: %w", err)
+	}
+
+	var snapshots []*Snapshot
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileSuffix) {
+			continue
+		}
+		taken, err := time.Parse(timeLayout, strings.TrimSuffix(strings.TrimPrefix(name, filePrefix), fileSuffix))
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, &Snapshot{
+			Name: name,
+			Path: filepath.Join(dir, name),
+			Time: taken,
+			Size: info.Size(),
+		})
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Time.After(snapshots[j].Time)
+	})
+	return snapshots, nil
+}
+
+// Find returns the snapshot named name, or when name is empty the newest
+// snapshot taken at or before at, or the newest one when at is zero. Only
+// files listed in dir are returned, so name can not point elsewhere.
+func Find(dir, name string, at time.Time) (*Snapshot, error) {
+	snapshots, err := List(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, snap := range snapshots {
+		switch {
+		case name != "":
+			if snap.Name == name {
+				return snap, nil
+			}
+		case at.IsZero() || !snap.Time.After(at):
+			return snap, nil
+		}
+	}
+	return nil, ErrNoSnapshot
+}
@@ -0,0 +1,114 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// files creates empty snapshot files named after the times in dir
+func files(t *testing.T, dir string, times ...string) {
+	t.Helper()
+	for _, name := range times {
+		if err := os.WriteFile(filepath.Join(dir, filePrefix+name+fileSuffix), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func names(snapshots []*Snapshot) []string {
+	var list []string
+	for _, snap := range snapshots {
+		list = append(list, snap.Name)
+	}
+	return list
+}
+
+func TestList(t *testing.T) {
+	dir := t.TempDir()
+	files(t, dir, "20260101T000000Z", "20260103T000000Z", "20260102T000000Z")
+	// not snapshots
+	for _, name := range []string{"notes.txt", filePrefix + "latest" + fileSuffix, ".tmp-" + filePrefix + "20260104T000000Z" + fileSuffix + "-1"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snapshots, err := List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"blog-20260103T000000Z.jsonl.gz", "blog-20260102T000000Z.jsonl.gz", "blog-20260101T000000Z.jsonl.gz"}
+	if got := names(snapshots); !reflect.DeepEqual(got, want) {
+		t.Errorf("listed %v, want %v", got, want)
+	}
+
+	if snapshots, err := List(filepath.Join(dir, "missing")); err != nil || len(snapshots) != 0 {
+		t.Errorf("List of a missing directory = %v, %v", snapshots, err)
+	}
+}
+
+func TestFind(t *testing.T) {
+	dir := t.TempDir()
+	files(t, dir, "20260101T000000Z", "20260102T000000Z", "20260103T000000Z")
+	day := func(d int) time.Time { return time.Date(2026, 1, d, 12, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name     string
+		snapshot string
+		at       time.Time
+		want     string
+		err      error
+	}{
+		{name: "newest", want: "blog-20260103T000000Z.jsonl.gz"},
+		{name: "at a time", at: day(2), want: "blog-20260102T000000Z.jsonl.gz"},
+		{name: "at the time of a snapshot", at: time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC), want: "blog-20260102T000000Z.jsonl.gz"},
+		{name: "before the first", at: time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC), err: ErrNoSnapshot},
+		{name: "by name", snapshot: "blog-20260101T000000Z.jsonl.gz", want: "blog-20260101T000000Z.jsonl.gz"},
+		{name: "unknown name", snapshot: "../blog-20260101T000000Z.jsonl.gz", err: ErrNoSnapshot},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snap, err := Find(dir, tt.snapshot, tt.at)
+			if err != tt.err {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if err == nil && snap.Name != tt.want {
+				t.Errorf("found %s, want %s", snap.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrune(t *testing.T) {
+	now := time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		keep   int
+		maxAge time.Duration
+		want   []string
+	}{
+		{name: "keep", keep: 2, want: []string{"blog-20260110T000000Z.jsonl.gz", "blog-20260109T000000Z.jsonl.gz"}},
+		{name: "max age", keep: 10, maxAge: 36 * time.Hour, want: []string{"blog-20260110T000000Z.jsonl.gz", "blog-20260109T000000Z.jsonl.gz"}},
+		{name: "the newest is kept when it is too old", keep: 10, maxAge: time.Nanosecond, want: []string{"blog-20260110T000000Z.jsonl.gz"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			files(t, dir, "20260101T000000Z", "20260108T000000Z", "20260109T000000Z", "20260110T000000Z")
+			s := &Snapshotter{Dir: dir, Keep: tt.keep, MaxAge: tt.maxAge}
+			if err := s.prune(now); err != nil {
+				t.Fatal(err)
+			}
+			snapshots, err := List(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := names(snapshots); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("kept %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
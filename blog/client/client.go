@@ -8,10 +8,11 @@ import (
 	"log"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 )
 
 func main() {
-	fmt.Println("---> Blog client <---\n")
+	fmt.Println("---> Blog client <---")
 
 	opts := grpc.WithInsecure()
 
@@ -24,7 +25,8 @@ func main() {
 
 	c := domain.NewBlogServiceClient(cc)
 
-	ctx := context.Background()
+	// the server uses the user id to check who may update and delete a blog
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-user-id", "fajar")
 
 	// create blog
 	req := domain.CreateBlogRequest{
@@ -90,4 +92,5 @@ func main() {
 		}
 		fmt.Println(res.GetBlog())
 	}
+	fmt.Printf("ListBlog trailer: %v\n", stream.Trailer())
 }
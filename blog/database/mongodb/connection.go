@@ -11,31 +11,286 @@ package mongodb
 
 import (
 	"context"
-	"log"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
 	"time"
 
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 )
 
+// default values used when the matching field of MongoDB is left empty
+const (
+	DefaultConnectTimeout = 20 * time.Second
+	DefaultPingAttempts   = 5
+	DefaultPingBackoff    = 500 * time.Millisecond
+	DefaultMaxPingBackoff = 10 * time.Second
+)
+
+// MongoDB holds everything needed to open a connection to mongodb.
+// only URI, DB and Table are required, the rest fall back to the driver defaults.
 type MongoDB struct {
 	URI   string
 	DB    string
 	Table string
+
+	// credentials, only used when Username is not empty
+	Username      string
+	Password      string
+	AuthSource    string
+	AuthMechanism string
+
+	// TLS, enabled when any of the files is set or TLS is true
+	TLS                   bool
+	TLSCAFile             string
+	TLSCertFile           string
+	TLSKeyFile            string
+	TLSInsecureSkipVerify bool
+
+	ReplicaSet string
+
+	// connection pool
+	MinPoolSize uint64
+	MaxPoolSize uint64
+
+	// timeouts, zero means driver default (ConnectTimeout defaults to DefaultConnectTimeout)
+	ConnectTimeout         time.Duration
+	ServerSelectionTimeout time.Duration
+	SocketTimeout          time.Duration
+
+	// ReadConcern is a level such as "local", "majority" or "linearizable"
+	ReadConcern string
+	// WriteConcern is "majority" or the number of nodes that must acknowledge a write
+	WriteConcern string
+	WriteTimeout time.Duration
+	Journal      bool
+
+	// startup ping, retried with exponential backoff
+	PingAttempts   int
+	PingBackoff    time.Duration
+	MaxPingBackoff time.Duration
 }
 
+// ClientOptions converts the config into driver options.
+func (m *MongoDB) ClientOptions() (*options.ClientOptions, error) {
+	if m.URI == "" {
+		return nil, errors.New("mongodb: URI is required")
+	}
+
+	opts := options.Client().ApplyURI(m.URI)
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("mongodb: invalid URI: %w", err)
+	}
+
+	if m.Username != "" {
+		opts.SetAuth(options.Credential{
+			Username:      m.Username,
+			Password:      m.Password,
+			AuthSource:    m.AuthSource,
+			AuthMechanism: m.AuthMechanism,
+		})
+	}
+
+	if m.TLS || m.TLSCAFile != "" || m.TLSCertFile != "" || m.TLSKeyFile != "" {
+		tlsConfig, err := m.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+
+	if m.ReplicaSet != "" {
+		opts.SetReplicaSet(m.ReplicaSet)
+	}
+
+	if m.MaxPoolSize > 0 && m.MinPoolSize > m.MaxPoolSize {
+		return nil, fmt.Errorf("mongodb: min pool size %d is bigger than max pool size %d", m.MinPoolSize, m.MaxPoolSize)
+	}
+	if m.MinPoolSize > 0 {
+		opts.SetMinPoolSize(m.MinPoolSize)
+	}
+	if m.MaxPoolSize > 0 {
+		opts.SetMaxPoolSize(m.MaxPoolSize)
+	}
+
+	connectTimeout := m.ConnectTimeout
+	if connectTimeout == 0 {
+		connectTimeout = DefaultConnectTimeout
+	}
+	opts.SetConnectTimeout(connectTimeout)
+	if m.ServerSelectionTimeout > 0 {
+		opts.SetServerSelectionTimeout(m.ServerSelectionTimeout)
+	}
+	if m.SocketTimeout > 0 {
+		opts.SetSocketTimeout(m.SocketTimeout)
+	}
+
+	if m.ReadConcern != "" {
+		opts.SetReadConcern(readconcern.New(readconcern.Level(m.ReadConcern)))
+	}
+
+	if m.WriteConcern != "" || m.WriteTimeout > 0 || m.Journal {
+		wc, err := m.writeConcern()
+		if err != nil {
+			return nil, err
+		}
+		opts.SetWriteConcern(wc)
+	}
+
+	return opts, nil
+}
+
+func (m *MongoDB) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: m.TLSInsecureSkipVerify,
+	}
+
+	if m.TLSCAFile != "" {
+		pem, err := os.ReadFile(m.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("mongodb: read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("mongodb: no certificate found in %s", m.TLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if m.TLSCertFile != "" || m.TLSKeyFile != "" {
+		if m.TLSCertFile == "" || m.TLSKeyFile == "" {
+			return nil, errors.New("mongodb: both TLS cert and key file are required for a client certificate")
+		}
+		cert, err := tls.LoadX509KeyPair(m.TLSCertFile, m.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("mongodb: load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+func (m *MongoDB) writeConcern() (*writeconcern.WriteConcern, error) {
+	var wcOpts []writeconcern.Option
+	switch m.WriteConcern {
+	case "":
+	case "majority":
+		wcOpts = append(wcOpts, writeconcern.WMajority())
+	default:
+		w, err := strconv.Atoi(m.WriteConcern)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("mongodb: write concern must be \"majority\" or a number, got %q", m.WriteConcern)
+		}
+		wcOpts = append(wcOpts, writeconcern.W(w))
+	}
+	if m.WriteTimeout > 0 {
+		wcOpts = append(wcOpts, writeconcern.WTimeout(m.WriteTimeout))
+	}
+	if m.Journal {
+		wcOpts = append(wcOpts, writeconcern.J(true))
+	}
+	return writeconcern.New(wcOpts...), nil
+}
+
+// Connect opens the client, pings the server until it answers and returns the configured collection.
 func (m *MongoDB) Connect() (*mongo.Client, *mongo.Collection, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-	defer cancel()
+	return m.ConnectContext(context.Background())
+}
 
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(m.URI))
+// ConnectContext is Connect with a caller supplied context, cancelling ctx stops the ping retries.
+func (m *MongoDB) ConnectContext(ctx context.Context) (*mongo.Client, *mongo.Collection, error) {
+	if m.DB == "" || m.Table == "" {
+		return nil, nil, errors.New("mongodb: DB and Table are required")
+	}
+
+	opts, err := m.ClientOptions()
 	if err != nil {
-		log.Fatalf("error while setup mongo client\n%v", err)
 		return nil, nil, err
 	}
+
+	// mongo.Connect does not do any I/O, the server is first contacted in Ping
+	client, err := mongo.Connect(ctx, opts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("mongodb: setup client: %w", err)
+	}
+
+	if err := m.PingWithRetry(ctx, client); err != nil {
+		client.Disconnect(context.Background())
+		return nil, nil, err
+	}
+
 	return client, client.Database(m.DB).Collection(m.Table), nil
 }
 
-func (m *MongoDB) Disconnect(client *mongo.Client) {
-	client.Disconnect(context.Background())
+// Ping checks once that the primary is reachable.
+func (m *MongoDB) Ping(ctx context.Context, client *mongo.Client) error {
+	timeout := m.ConnectTimeout
+	if timeout == 0 {
+		timeout = DefaultConnectTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	return client.Ping(ctx, readpref.Primary())
+}
+
+// PingWithRetry pings the server up to PingAttempts times, doubling the wait between attempts.
+func (m *MongoDB) PingWithRetry(ctx context.Context, client *mongo.Client) error {
+	waits := m.pingWaits()
+	var err error
+	for i := 0; ; i++ {
+		if err = m.Ping(ctx, client); err == nil {
+			return nil
+		}
+		if i == len(waits) {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("mongodb: ping canceled after %d attempts: %w", i+1, ctx.Err())
+		case <-time.After(waits[i]):
+		}
+	}
+	return fmt.Errorf("mongodb: ping failed after %d attempts: %w", len(waits)+1, err)
+}
+
+// pingWaits returns the waits between the ping attempts, starting at
+// PingBackoff and doubling up to MaxPingBackoff
+func (m *MongoDB) pingWaits() []time.Duration {
+	attempts := m.PingAttempts
+	if attempts <= 0 {
+		attempts = DefaultPingAttempts
+	}
+	backoff := m.PingBackoff
+	if backoff <= 0 {
+		backoff = DefaultPingBackoff
+	}
+	maxBackoff := m.MaxPingBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = DefaultMaxPingBackoff
+	}
+
+	waits := make([]time.Duration, attempts-1)
+	for i := range waits {
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+		waits[i] = backoff
+		backoff *= 2
+	}
+	return waits
+}
+
+func (m *MongoDB) Disconnect(client *mongo.Client) error {
+	return client.Disconnect(context.Background())
 }
@@ -0,0 +1,263 @@
+package mongodb
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// writeCert writes a self-signed certificate and its key to dir
+func writeCert(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "mongodb test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestClientOptionsTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCert(t, dir)
+	notPEM := filepath.Join(dir, "not.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		m    MongoDB
+		// tls is whether a TLS config is set
+		tls       bool
+		roots     bool
+		clientCrt bool
+		insecure  bool
+		err       bool
+	}{
+		{name: "off"},
+		{name: "on", m: MongoDB{TLS: true}, tls: true},
+		{name: "insecure", m: MongoDB{TLS: true, TLSInsecureSkipVerify: true}, tls: true, insecure: true},
+		{name: "CA file turns it on", m: MongoDB{TLSCAFile: certFile}, tls: true, roots: true},
+		{name: "client certificate", m: MongoDB{TLSCertFile: certFile, TLSKeyFile: keyFile}, tls: true, clientCrt: true},
+		{name: "certificate without a key", m: MongoDB{TLSCertFile: certFile}, err: true},
+		{name: "key without a certificate", m: MongoDB{TLSKeyFile: keyFile}, err: true},
+		{name: "key of another file", m: MongoDB{TLSCertFile: certFile, TLSKeyFile: notPEM}, err: true},
+		{name: "CA file without a certificate", m: MongoDB{TLSCAFile: notPEM}, err: true},
+		{name: "missing CA file", m: MongoDB{TLSCAFile: filepath.Join(dir, "missing.pem")}, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.m.URI = "mongodb://localhost:27017"
+			opts, err := tt.m.ClientOptions()
+			if (err != nil) != tt.err {
+				t.Fatalf("err = %v, want an error %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			config := opts.TLSConfig
+			if (config != nil) != tt.tls {
+				t.Fatalf("TLS config %v, want one %v", config, tt.tls)
+			}
+			if config == nil {
+				return
+			}
+			if config.MinVersion != tls.VersionTLS12 {
+				t.Errorf("MinVersion = %x, want TLS 1.2", config.MinVersion)
+			}
+			if config.InsecureSkipVerify != tt.insecure {
+				t.Errorf("InsecureSkipVerify = %v, want %v", config.InsecureSkipVerify, tt.insecure)
+			}
+			if (config.RootCAs != nil) != tt.roots {
+				t.Errorf("RootCAs %v, want them %v", config.RootCAs, tt.roots)
+			}
+			if (len(config.Certificates) == 1) != tt.clientCrt {
+				t.Errorf("%d client certificates, want one %v", len(config.Certificates), tt.clientCrt)
+			}
+		})
+	}
+}
+
+func TestClientOptionsWriteConcern(t *testing.T) {
+	tests := []struct {
+		name     string
+		m        MongoDB
+		none     bool
+		w        interface{}
+		journal  bool
+		wTimeout time.Duration
+		err      bool
+	}{
+		{name: "driver default", none: true},
+		{name: "majority", m: MongoDB{WriteConcern: "majority"}, w: "majority"},
+		{name: "nodes", m: MongoDB{WriteConcern: "2"}, w: 2},
+		{name: "unacknowledged", m: MongoDB{WriteConcern: "0"}, w: 0},
+		{name: "timeout only", m: MongoDB{WriteTimeout: time.Second}, wTimeout: time.Second},
+		{name: "journal only", m: MongoDB{Journal: true}, journal: true},
+		{name: "all", m: MongoDB{WriteConcern: "majority", WriteTimeout: 5 * time.Second, Journal: true}, w: "majority", journal: true, wTimeout: 5 * time.Second},
+		{name: "negative", m: MongoDB{WriteConcern: "-1"}, err: true},
+		{name: "not a number", m: MongoDB{WriteConcern: "all"}, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.m.URI = "mongodb://localhost:27017"
+			opts, err := tt.m.ClientOptions()
+			if (err != nil) != tt.err {
+				t.Fatalf("err = %v, want an error %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			wc := opts.WriteConcern
+			if (wc == nil) != tt.none {
+				t.Fatalf("write concern %v, want none %v", wc, tt.none)
+			}
+			if wc == nil {
+				return
+			}
+			if got := wc.GetW(); !reflect.DeepEqual(got, tt.w) {
+				t.Errorf("w = %v, want %v", got, tt.w)
+			}
+			if got := wc.GetJ(); got != tt.journal {
+				t.Errorf("j = %v, want %v", got, tt.journal)
+			}
+			if got := wc.GetWTimeout(); got != tt.wTimeout {
+				t.Errorf("wtimeout = %v, want %v", got, tt.wTimeout)
+			}
+		})
+	}
+}
+
+func TestClientOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		m    MongoDB
+		err  bool
+	}{
+		{name: "no URI", m: MongoDB{}, err: true},
+		{name: "invalid URI", m: MongoDB{URI: "localhost:27017"}, err: true},
+		{name: "min pool above max", m: MongoDB{URI: "mongodb://localhost", MinPoolSize: 10, MaxPoolSize: 5}, err: true},
+		{name: "min pool without max", m: MongoDB{URI: "mongodb://localhost", MinPoolSize: 10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := tt.m.ClientOptions()
+			if (err != nil) != tt.err {
+				t.Fatalf("err = %v, want an error %v", err, tt.err)
+			}
+			if err == nil && *opts.ConnectTimeout != DefaultConnectTimeout {
+				t.Errorf("connect timeout %v, want %v", *opts.ConnectTimeout, DefaultConnectTimeout)
+			}
+		})
+	}
+}
+
+func TestPingWaits(t *testing.T) {
+	tests := []struct {
+		name string
+		m    MongoDB
+		want []time.Duration
+	}{
+		{
+			name: "defaults",
+			want: []time.Duration{500 * time.Millisecond, time.Second, 2 * time.Second, 4 * time.Second},
+		},
+		{
+			name: "capped",
+			m:    MongoDB{PingAttempts: 6, PingBackoff: time.Second, MaxPingBackoff: 3 * time.Second},
+			want: []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second, 3 * time.Second},
+		},
+		{
+			name: "first wait above the cap",
+			m:    MongoDB{PingAttempts: 3, PingBackoff: time.Minute, MaxPingBackoff: time.Second},
+			want: []time.Duration{time.Second, time.Second},
+		},
+		{
+			name: "default cap",
+			m:    MongoDB{PingAttempts: 4, PingBackoff: 8 * time.Second},
+			want: []time.Duration{8 * time.Second, DefaultMaxPingBackoff, DefaultMaxPingBackoff},
+		},
+		{
+			name: "one attempt",
+			m:    MongoDB{PingAttempts: 1},
+			want: []time.Duration{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.m.pingWaits(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("waits = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPingWithRetry(t *testing.T) {
+	// nothing listens on port 1, every ping fails
+	m := &MongoDB{
+		URI:            "mongodb://127.0.0.1:1/?connect=direct",
+		ConnectTimeout: 20 * time.Millisecond,
+		PingAttempts:   3,
+		PingBackoff:    time.Millisecond,
+	}
+	opts, err := m.ClientOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := mongo.Connect(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect(context.Background())
+
+	err = m.PingWithRetry(context.Background(), client)
+	if err == nil || !strings.Contains(err.Error(), "after 3 attempts") {
+		t.Errorf("err = %v, want a failure after 3 attempts", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	m.PingBackoff = time.Hour
+	err = m.PingWithRetry(ctx, client)
+	if !errors.Is(err, context.Canceled) || !strings.Contains(err.Error(), "after 1 attempts") {
+		t.Errorf("err = %v, want it canceled after the first attempt", err)
+	}
+}
@@ -0,0 +1,280 @@
+// Package feed serves RSS 2.0 and Atom feeds of the recent published posts
+// and an XML sitemap over HTTP. Responses carry an ETag and Last-Modified
+// header so crawlers can ask with If-None-Match / If-Modified-Since and get a
+// 304 instead of the whole document.
+//
+//	/feed.rss                  /feed.atom
+//	/authors/{author}/feed.rss /authors/{author}/feed.atom
+//	/tags/{tag}/feed.rss       /tags/{tag}/feed.atom
+//	/sitemap.xml
+//
+// The tenant is taken from the tenant query parameter or the X-Tenant-ID
+// header, DefaultTenant is used when neither is sent.
+package feed
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"learn-grpc/blog/repository"
+	"learn-grpc/blog/tenant"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// default values used when the matching field of Handler is left empty
+const (
+	DefaultLimit        = 20
+	DefaultSitemapLimit = 50000 // the maximum number of urls in one sitemap
+)
+
+// Handler serves the feeds and the sitemap
+type Handler struct {
+	Store repository.Store
+	// BaseURL is the public url of the blog, a post lives at BaseURL/posts/{id}
+	BaseURL string
+	Title   string
+	// Limit is the number of posts in a feed
+	Limit         int
+	DefaultTenant string
+}
+
+// feedFilter is what a feed url selects
+type feedFilter struct {
+	authorID string
+	tag      string
+	format   string // rss or atom
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tenantID := r.URL.Query().Get("tenant")
+	if tenantID == "" {
+		tenantID = r.Header.Get("X-Tenant-ID")
+	}
+	if tenantID == "" {
+		tenantID = h.DefaultTenant
+	}
+	if !tenant.Valid(tenantID) {
+		http.Error(w, "unknown tenant", http.StatusNotFound)
+		return
+	}
+	ctx := tenant.NewContext(r.Context(), tenantID)
+
+	if r.URL.Path == "/sitemap.xml" {
+		items, err := h.posts(ctx, repository.ListFilter{Limit: DefaultSitemapLimit})
+		h.respond(w, r, items, err, "application/xml; charset=utf-8", h.sitemap)
+		return
+	}
+
+	filter, ok := parseFeedPath(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	items, err := h.posts(ctx, repository.ListFilter{
+		AuthorID: filter.authorID,
+		Tag:      filter.tag,
+		Limit:    h.limit(),
+	})
+	if filter.format == "atom" {
+		h.respond(w, r, items, err, "application/atom+xml; charset=utf-8", func(items []*repository.BlogItem) ([]byte, error) {
+			return h.atom(r, filter, items)
+		})
+		return
+	}
+	h.respond(w, r, items, err, "application/rss+xml; charset=utf-8", func(items []*repository.BlogItem) ([]byte, error) {
+		return h.rss(r, filter, items)
+	})
+}
+
+// parseFeedPath understands /feed.xxx, /authors/{id}/feed.xxx and /tags/{tag}/feed.xxx
+func parseFeedPath(path string) (feedFilter, bool) {
+	var filter feedFilter
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+
+	switch {
+	case len(parts) == 1:
+	case len(parts) == 3 && parts[0] == "authors" && parts[1] != "":
+		filter.authorID = parts[1]
+	case len(parts) == 3 && parts[0] == "tags" && parts[1] != "":
+		filter.tag = strings.ToLower(parts[1])
+	default:
+		return filter, false
+	}
+
+	switch parts[len(parts)-1] {
+	case "feed.rss":
+		filter.format = "rss"
+	case "feed.atom":
+		filter.format = "atom"
+	default:
+		return filter, false
+	}
+	return filter, true
+}
+
+// posts returns the published posts, newest first
+func (h *Handler) posts(ctx context.Context, filter repository.ListFilter) ([]*repository.BlogItem, error) {
+	filter.Status = repository.StatusPublished
+	filter.NewestFirst = true
+
+	var items []*repository.BlogItem
+	err := h.Store.Blogs().List(ctx, filter, func(item *repository.BlogItem) error {
+		items = append(items, item)
+		return nil
+	})
+	return items, err
+}
+
+// respond renders the document and lets http.ServeContent answer
+// conditional requests from the ETag and Last-Modified headers
+func (h *Handler) respond(w http.ResponseWriter, r *http.Request, items []*repository.BlogItem, err error, contentType string, render func([]*repository.BlogItem) ([]byte, error)) {
+	var body []byte
+	if err == nil {
+		body, err = render(items)
+	}
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		log.Printf("feed: %s: %v", r.URL.Path, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	sum := sha256.Sum256(body)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	http.ServeContent(w, r, "", lastModified(items), bytes.NewReader(body))
+}
+
+func (h *Handler) rss(r *http.Request, filter feedFilter, items []*repository.BlogItem) ([]byte, error) {
+	doc := rss{
+		Version: "2.0",
+		AtomNS:  "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:       h.feedTitle(filter),
+			Link:        h.BaseURL,
+			Description: h.feedTitle(filter),
+			Self:        atomLink{Href: h.selfURL(r), Rel: "self", Type: "application/rss+xml"},
+		},
+	}
+	if modified := lastModified(items); !modified.IsZero() {
+		doc.Channel.LastBuildDate = rssTime(modified)
+	}
+
+	for _, item := range items {
+		link := h.postURL(item)
+		doc.Channel.Items = append(doc.Channel.Items, rssItem{
+			Title:       item.Title,
+			Link:        link,
+			GUID:        rssGUID{IsPermaLink: true, Value: link},
+			PubDate:     rssTime(item.CreatedAt),
+			Categories:  item.Tags,
+			Description: item.Content,
+		})
+	}
+	return marshal(doc)
+}
+
+func (h *Handler) atom(r *http.Request, filter feedFilter, items []*repository.BlogItem) ([]byte, error) {
+	self := h.selfURL(r)
+	// atom requires updated even for an empty feed
+	updated := lastModified(items)
+	if updated.IsZero() {
+		updated = time.Unix(0, 0)
+	}
+	doc := atomFeed{
+		Title:   h.feedTitle(filter),
+		ID:      self,
+		Updated: atomTime(updated),
+		Links: []atomLink{
+			{Href: self, Rel: "self", Type: "application/atom+xml"},
+			{Href: h.BaseURL, Rel: "alternate", Type: "text/html"},
+		},
+	}
+
+	for _, item := range items {
+		link := h.postURL(item)
+		entry := atomEntry{
+			Title:     item.Title,
+			ID:        link,
+			Link:      atomLink{Href: link, Rel: "alternate"},
+			Published: atomTime(item.CreatedAt),
+			Updated:   atomTime(item.UpdatedAt),
+			Author:    atomPerson{Name: item.AuthorID},
+			Content:   atomText{Type: "text", Value: item.Content},
+		}
+		for _, tag := range item.Tags {
+			entry.Categories = append(entry.Categories, atomCategory{Term: tag})
+		}
+		doc.Entries = append(doc.Entries, entry)
+	}
+	return marshal(doc)
+}
+
+func (h *Handler) sitemap(items []*repository.BlogItem) ([]byte, error) {
+	doc := urlSet{}
+	for _, item := range items {
+		doc.URLs = append(doc.URLs, sitemapURL{
+			Loc:     h.postURL(item),
+			LastMod: atomTime(item.UpdatedAt),
+		})
+	}
+	return marshal(doc)
+}
+
+func (h *Handler) feedTitle(filter feedFilter) string {
+	title := h.Title
+	if title == "" {
+		title = "Blog"
+	}
+	switch {
+	case filter.authorID != "":
+		return title + " - posts by " + filter.authorID
+	case filter.tag != "":
+		return title + " - posts tagged " + filter.tag
+	}
+	return title
+}
+
+func (h *Handler) postURL(item *repository.BlogItem) string {
+	return strings.TrimRight(h.BaseURL, "/") + "/posts/" + item.ID.Hex()
+}
+
+func (h *Handler) selfURL(r *http.Request) string {
+	u := url.URL{Path: r.URL.Path, RawQuery: r.URL.RawQuery}
+	return strings.TrimRight(h.BaseURL, "/") + u.String()
+}
+
+func (h *Handler) limit() int {
+	if h.Limit > 0 {
+		return h.Limit
+	}
+	return DefaultLimit
+}
+
+// lastModified is the newest update of the posts, at second precision like
+// the Last-Modified header. A delete does not move it, clients that send
+// If-None-Match as well notice the change through the ETag.
+func lastModified(items []*repository.BlogItem) time.Time {
+	var newest time.Time
+	for _, item := range items {
+		if item.UpdatedAt.After(newest) {
+			newest = item.UpdatedAt
+		}
+	}
+	return newest.Truncate(time.Second)
+}
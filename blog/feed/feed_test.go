@@ -0,0 +1,145 @@
+package feed
+
+import (
+	"context"
+	"learn-grpc/blog/repository"
+	"learn-grpc/blog/tenant"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newHandler returns a handler of tenant acme with a published post by fajar
+// tagged go, one by sari and a draft
+func newHandler(t *testing.T) (*Handler, repository.Store) {
+	t.Helper()
+	store := repository.NewMemoryStore(repository.Options{})
+	updated := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, item := range []*repository.BlogItem{
+		{AuthorID: "fajar", Title: "grpc streams", Tags: []string{"go"}, Status: repository.StatusPublished},
+		{AuthorID: "sari", Title: "mongo indexes", Status: repository.StatusPublished},
+		{AuthorID: "fajar", Title: "unfinished draft", Tags: []string{"go"}, Status: repository.StatusDraft},
+	} {
+		item.CreatedAt, item.UpdatedAt = updated, updated
+		if err := store.Blogs().Create(tenant.NewContext(context.Background(), "acme"), item); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &Handler{Store: store, BaseURL: "https://blog.example", Title: "Blog", DefaultTenant: "acme"}, store
+}
+
+func serve(h *Handler, method, path string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, nil)
+	for key, values := range header {
+		r.Header[key] = values
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestRoutes(t *testing.T) {
+	h, _ := newHandler(t)
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		code        int
+		contentType string
+		titles      []string
+		missing     []string
+	}{
+		{name: "rss", path: "/feed.rss", code: http.StatusOK, contentType: "application/rss+xml", titles: []string{"grpc streams", "mongo indexes"}, missing: []string{"unfinished draft"}},
+		{name: "atom", path: "/feed.atom", code: http.StatusOK, contentType: "application/atom+xml", titles: []string{"grpc streams", "mongo indexes"}},
+		{name: "author", path: "/authors/sari/feed.atom", code: http.StatusOK, titles: []string{"mongo indexes"}, missing: []string{"grpc streams"}},
+		{name: "tag", path: "/tags/Go/feed.rss", code: http.StatusOK, titles: []string{"grpc streams"}, missing: []string{"mongo indexes", "unfinished draft"}},
+		{name: "sitemap", path: "/sitemap.xml", code: http.StatusOK, contentType: "application/xml"},
+		{name: "other tenant", path: "/feed.rss?tenant=globex", code: http.StatusOK, missing: []string{"grpc streams"}},
+		{name: "invalid tenant", path: "/feed.rss?tenant=a+b", code: http.StatusNotFound},
+		{name: "unknown format", path: "/feed.json", code: http.StatusNotFound},
+		{name: "unknown path", path: "/authors/feed.rss", code: http.StatusNotFound},
+		{name: "post", method: http.MethodPost, path: "/feed.rss", code: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			w := serve(h, method, tt.path, nil)
+			if w.Code != tt.code {
+				t.Fatalf("code = %d, want %d", w.Code, tt.code)
+			}
+			if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, tt.contentType) {
+				t.Errorf("Content-Type = %q, want %q", got, tt.contentType)
+			}
+			body := w.Body.String()
+			for _, title := range tt.titles {
+				if !strings.Contains(body, title) {
+					t.Errorf("%q is missing", title)
+				}
+			}
+			for _, title := range tt.missing {
+				if strings.Contains(body, title) {
+					t.Errorf("%q is listed", title)
+				}
+			}
+		})
+	}
+}
+
+func TestConditionalGet(t *testing.T) {
+	h, _ := newHandler(t)
+	first := serve(h, http.MethodGet, "/feed.rss", nil)
+	etag, modified := first.Header().Get("ETag"), first.Header().Get("Last-Modified")
+	if etag == "" || modified == "" {
+		t.Fatalf("ETag %q and Last-Modified %q, want both", etag, modified)
+	}
+
+	tests := []struct {
+		name   string
+		header http.Header
+		code   int
+	}{
+		{name: "matching etag", header: http.Header{"If-None-Match": {etag}}, code: http.StatusNotModified},
+		{name: "one of the etags", header: http.Header{"If-None-Match": {`"other", ` + etag}}, code: http.StatusNotModified},
+		{name: "other etag", header: http.Header{"If-None-Match": {`"other"`}}, code: http.StatusOK},
+		{name: "not modified since", header: http.Header{"If-Modified-Since": {modified}}, code: http.StatusNotModified},
+		{name: "modified since", header: http.Header{"If-Modified-Since": {"Sat, 01 Mar 2025 12:00:00 GMT"}}, code: http.StatusOK},
+		// If-None-Match wins over If-Modified-Since
+		{name: "other etag, not modified since", header: http.Header{"If-None-Match": {`"other"`}, "If-Modified-Since": {modified}}, code: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(h, http.MethodGet, "/feed.rss", tt.header)
+			if w.Code != tt.code {
+				t.Errorf("code = %d, want %d", w.Code, tt.code)
+			}
+			if tt.code == http.StatusNotModified && w.Body.Len() != 0 {
+				t.Errorf("304 with a body of %d bytes", w.Body.Len())
+			}
+		})
+	}
+}
+
+func TestETagChangesWithThePosts(t *testing.T) {
+	h, store := newHandler(t)
+	etag := serve(h, http.MethodGet, "/feed.atom", nil).Header().Get("ETag")
+
+	item := &repository.BlogItem{AuthorID: "sari", Title: "new post", Status: repository.StatusPublished, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	if err := store.Blogs().Create(tenant.NewContext(context.Background(), "acme"), item); err != nil {
+		t.Fatal(err)
+	}
+	w := serve(h, http.MethodGet, "/feed.atom", http.Header{"If-None-Match": {etag}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("code = %d, want %d", w.Code, http.StatusOK)
+	}
+	if got := w.Header().Get("ETag"); got == etag {
+		t.Errorf("ETag %s did not change", got)
+	}
+	if !strings.Contains(w.Body.String(), "new post") {
+		t.Error("the new post is missing")
+	}
+}
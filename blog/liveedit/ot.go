@@ -0,0 +1,253 @@
+package liveedit
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// ErrInvalidOp is returned for an operation that does not fit the document
+var ErrInvalidOp = errors.New("liveedit: invalid operation")
+
+// Component is one step of an operation, exactly one field is set. Lengths
+// and positions count unicode code points, not bytes.
+type Component struct {
+	// Retain skips that many characters
+	Retain int
+	// Insert inserts the text at the current position
+	Insert string
+	// Delete removes that many characters
+	Delete int
+}
+
+// Op is a text operation in the style of ot.js: the components walk over
+// the whole document, retaining, inserting and deleting characters.
+type Op []Component
+
+// NewOp builds an operation from components, merging neighbours of the same
+// kind and dropping empty ones so equal edits compare equal
+func NewOp(components ...Component) (Op, error) {
+	var op Op
+	for _, c := range components {
+		set := 0
+		if c.Retain != 0 {
+			set++
+		}
+		if c.Insert != "" {
+			set++
+		}
+		if c.Delete != 0 {
+			set++
+		}
+		if set > 1 || c.Retain < 0 || c.Delete < 0 || !utf8.ValidString(c.Insert) {
+			return nil, fmt.Errorf("%w: bad component %+v", ErrInvalidOp, c)
+		}
+		switch {
+		case c.Retain > 0:
+			op = op.retain(c.Retain)
+		case c.Insert != "":
+			op = op.insert(c.Insert)
+		case c.Delete > 0:
+			op = op.delete(c.Delete)
+		}
+	}
+	return op, nil
+}
+
+func (o Op) retain(n int) Op {
+	if n == 0 {
+		return o
+	}
+	if last := len(o) - 1; last >= 0 && o[last].Retain > 0 {
+		o[last].Retain += n
+		return o
+	}
+	return append(o, Component{Retain: n})
+}
+
+// insert keeps inserts before deletes at the same position, an insert and
+// a delete commute so this is the canonical order
+func (o Op) insert(s string) Op {
+	if s == "" {
+		return o
+	}
+	last := len(o) - 1
+	if last >= 0 && o[last].Insert != "" {
+		o[last].Insert += s
+		return o
+	}
+	if last >= 0 && o[last].Delete > 0 {
+		if last > 0 && o[last-1].Insert != "" {
+			o[last-1].Insert += s
+			return o
+		}
+		o = append(o, o[last])
+		o[last] = Component{Insert: s}
+		return o
+	}
+	return append(o, Component{Insert: s})
+}
+
+func (o Op) delete(n int) Op {
+	if n == 0 {
+		return o
+	}
+	if last := len(o) - 1; last >= 0 && o[last].Delete > 0 {
+		o[last].Delete += n
+		return o
+	}
+	return append(o, Component{Delete: n})
+}
+
+// BaseLen is the length of the document the operation applies to
+func (o Op) BaseLen() int {
+	n := 0
+	for _, c := range o {
+		n += c.Retain + c.Delete
+	}
+	return n
+}
+
+// TargetLen is the length of the document after the operation
+func (o Op) TargetLen() int {
+	n := 0
+	for _, c := range o {
+		n += c.Retain + utf8.RuneCountInString(c.Insert)
+	}
+	return n
+}
+
+// IsNoop reports whether the operation leaves the document unchanged
+func (o Op) IsNoop() bool {
+	for _, c := range o {
+		if c.Retain == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply returns doc changed by the operation
+func Apply(doc string, op Op) (string, error) {
+	runes := []rune(doc)
+	if op.BaseLen() != len(runes) {
+		return "", fmt.Errorf("%w: operation is for a document of %d characters, got %d", ErrInvalidOp, op.BaseLen(), len(runes))
+	}
+
+	out := make([]rune, 0, op.TargetLen())
+	pos := 0
+	for _, c := range op {
+		switch {
+		case c.Retain > 0:
+			out = append(out, runes[pos:pos+c.Retain]...)
+			pos += c.Retain
+		case c.Insert != "":
+			out = append(out, []rune(c.Insert)...)
+		case c.Delete > 0:
+			pos += c.Delete
+		}
+	}
+	return string(out), nil
+}
+
+// Transform takes two operations made on the same document and returns a'
+// and b' so that applying a then b' gives the same document as applying b
+// then a'. When both insert at the same position the text of a comes first.
+func Transform(a, b Op) (Op, Op, error) {
+	if a.BaseLen() != b.BaseLen() {
+		return nil, nil, fmt.Errorf("%w: operations are for documents of %d and %d characters", ErrInvalidOp, a.BaseLen(), b.BaseLen())
+	}
+
+	var aPrime, bPrime Op
+	// the components are copied so the inputs are not changed
+	ai, bi := 0, 0
+	var ac, bc *Component
+	next := func(op Op, i *int) *Component {
+		if *i >= len(op) {
+			return nil
+		}
+		c := op[*i]
+		*i++
+		return &c
+	}
+	ac, bc = next(a, &ai), next(b, &bi)
+
+	for ac != nil || bc != nil {
+		switch {
+		case ac != nil && ac.Insert != "":
+			aPrime = aPrime.insert(ac.Insert)
+			bPrime = bPrime.retain(utf8.RuneCountInString(ac.Insert))
+			ac = next(a, &ai)
+			continue
+		case bc != nil && bc.Insert != "":
+			aPrime = aPrime.retain(utf8.RuneCountInString(bc.Insert))
+			bPrime = bPrime.insert(bc.Insert)
+			bc = next(b, &bi)
+			continue
+		case ac == nil || bc == nil:
+			return nil, nil, fmt.Errorf("%w: operations do not cover the same document", ErrInvalidOp)
+		}
+
+		aLen, bLen := ac.Retain+ac.Delete, bc.Retain+bc.Delete
+		n := aLen
+		if bLen < n {
+			n = bLen
+		}
+		switch {
+		case ac.Retain > 0 && bc.Retain > 0:
+			aPrime = aPrime.retain(n)
+			bPrime = bPrime.retain(n)
+		case ac.Delete > 0 && bc.Retain > 0:
+			aPrime = aPrime.delete(n)
+		case ac.Retain > 0 && bc.Delete > 0:
+			bPrime = bPrime.delete(n)
+		}
+		// both deleting the same text leaves nothing to do
+
+		ac = consume(ac, n, func() *Component { return next(a, &ai) })
+		bc = consume(bc, n, func() *Component { return next(b, &bi) })
+	}
+	return aPrime, bPrime, nil
+}
+
+// consume takes n characters off a retain or delete component, moving to
+// the next component when it is used up
+func consume(c *Component, n int, next func() *Component) *Component {
+	if c.Retain > 0 {
+		c.Retain -= n
+		if c.Retain == 0 {
+			return next()
+		}
+		return c
+	}
+	c.Delete -= n
+	if c.Delete == 0 {
+		return next()
+	}
+	return c
+}
+
+// TransformIndex moves a cursor position over an operation, a cursor where
+// text is inserted ends up after the inserted text
+func TransformIndex(index int, op Op) int {
+	newIndex := index
+	for _, c := range op {
+		switch {
+		case c.Retain > 0:
+			index -= c.Retain
+		case c.Insert != "":
+			newIndex += utf8.RuneCountInString(c.Insert)
+		case c.Delete > 0:
+			if index < c.Delete {
+				newIndex -= index
+			} else {
+				newIndex -= c.Delete
+			}
+			index -= c.Delete
+		}
+		if index < 0 {
+			break
+		}
+	}
+	return newIndex
+}
@@ -0,0 +1,139 @@
+package liveedit
+
+import (
+	"context"
+	"testing"
+)
+
+// op builds an operation from components given as retain counts (int),
+// inserts (string) and deletes (negative int)
+func op(t *testing.T, parts ...interface{}) Op {
+	t.Helper()
+	var components []Component
+	for _, part := range parts {
+		switch p := part.(type) {
+		case int:
+			if p < 0 {
+				components = append(components, Component{Delete: -p})
+			} else {
+				components = append(components, Component{Retain: p})
+			}
+		case string:
+			components = append(components, Component{Insert: p})
+		}
+	}
+	o, err := NewOp(components...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func TestTransformConverges(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		a, b Op
+		want string
+	}{
+		{name: "inserts apart", doc: "hello world", a: op(t, 5, ",", 6), b: op(t, 11, "!"), want: "hello, world!"},
+		{name: "inserts at the same place", doc: "ab", a: op(t, 1, "x", 1), b: op(t, 1, "y", 1), want: "axyb"},
+		{name: "same delete", doc: "hello world", a: op(t, 5, -6), b: op(t, 5, -6), want: "hello"},
+		{name: "overlapping deletes", doc: "abcdef", a: op(t, 1, -3, 2), b: op(t, 2, -3, 1), want: "af"},
+		{name: "insert in deleted text", doc: "abcdef", a: op(t, 1, -4, 1), b: op(t, 3, "XY", 3), want: "aXYf"},
+		{name: "delete and insert at the start", doc: "abc", a: op(t, -1, 2), b: op(t, "z", 3), want: "zbc"},
+		{name: "unicode", doc: "héllo 👋", a: op(t, 6, -1, "🌍"), b: op(t, 1, -1, "e", 5), want: "hello 🌍"},
+		{name: "empty document", doc: "", a: op(t, "a"), b: op(t, "b"), want: "ab"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aPrime, bPrime, err := Transform(tt.a, tt.b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ab := apply(t, apply(t, tt.doc, tt.a), bPrime)
+			ba := apply(t, apply(t, tt.doc, tt.b), aPrime)
+			if ab != ba {
+				t.Fatalf("a then b' gives %q, b then a' gives %q", ab, ba)
+			}
+			if ab != tt.want {
+				t.Errorf("got %q, want %q", ab, tt.want)
+			}
+		})
+	}
+}
+
+func apply(t *testing.T, doc string, o Op) string {
+	t.Helper()
+	out, err := Apply(doc, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestSessionConverges(t *testing.T) {
+	for _, first := range []string{"fajar", "sari"} {
+		t.Run(first+" first", func(t *testing.T) {
+			m := &Manager{Save: func(ctx context.Context, tenantID, blogID, base, content string, editors []string) error { return nil }}
+			clients := []struct {
+				p   *Participant
+				own Op
+			}{
+				{m.Join("acme", "blog", "fajar", false, "hello world"), op(t, 5, ",", 6)},
+				{m.Join("acme", "blog", "sari", false, "hello world"), op(t, 6, -5, "there")},
+			}
+			if first == "sari" {
+				clients[0], clients[1] = clients[1], clients[0]
+			}
+
+			// both edit revision 0 without seeing the change of the other
+			for _, c := range clients {
+				if _, err := c.p.Submit(0, c.own, ""); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// each client applies its own change at once and, like an ot.js
+			// client, transforms the operations received before the ack
+			// over it
+			for _, c := range clients {
+				doc := apply(t, "hello world", c.own)
+				pending := c.own
+				for ev := range drainEvents(c.p) {
+					switch {
+					case ev.Kind == EventAck:
+						pending = nil
+					case ev.Kind == EventOperation && pending != nil:
+						var received Op
+						var err error
+						pending, received, err = Transform(pending, ev.Op)
+						if err != nil {
+							t.Fatal(err)
+						}
+						doc = apply(t, doc, received)
+					case ev.Kind == EventOperation:
+						doc = apply(t, doc, ev.Op)
+					}
+				}
+				if doc != "hello, there" {
+					t.Errorf("%s sees %q, want %q", c.p.UserID, doc, "hello, there")
+				}
+			}
+		})
+	}
+}
+
+// drainEvents returns the events queued for p so far
+func drainEvents(p *Participant) chan Event {
+	out := make(chan Event, len(p.events))
+	for {
+		select {
+		case ev := <-p.events:
+			out <- ev
+		default:
+			close(out)
+			return out
+		}
+	}
+}
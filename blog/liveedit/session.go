@@ -0,0 +1,543 @@
+// Package liveedit runs collaborative editing sessions of blog posts.
+//
+// Everyone editing a post joins the same session. Clients send operations
+// made against a revision of the session, the session transforms them over
+// the operations applied since, applies them and broadcasts them to the
+// other participants, the same way an ot.js server does. The merged content
+// is saved every PersistInterval and when the last participant leaves.
+//
+// Sessions live in the memory of one server process, editors of a post must
+// reach the same server. A session only saves over the content it started
+// from: when the post was changed outside the session, an editor lost the
+// right to edit it or moderation refused or held the content, the save fails
+// and the session is closed, its participants join again to start from the
+// stored post.
+package liveedit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// default values used when the matching field of Manager is zero
+const (
+	DefaultPersistInterval = 10 * time.Second
+	DefaultMaxHistory      = 1000
+	DefaultQueueSize       = 256
+	DefaultMaxLength       = 1 << 20
+)
+
+var (
+	// ErrReadOnly is returned for an operation of a participant who may
+	// only watch
+	ErrReadOnly = errors.New("liveedit: read only participant")
+	// ErrStaleRevision is returned for an operation made against a revision
+	// the session no longer keeps, the client has to join again
+	ErrStaleRevision = errors.New("liveedit: revision is too old")
+	// ErrTooLong is returned for an operation that makes the document
+	// longer than MaxLength
+	ErrTooLong = errors.New("liveedit: document too long")
+	// ErrLeft is returned for calls of a participant who left or was
+	// dropped
+	ErrLeft = errors.New("liveedit: participant left the session")
+	// ErrConflict is returned by a SaveFunc when the stored content is no
+	// longer the one the session started from
+	ErrConflict = errors.New("liveedit: blog was changed outside the session")
+	// ErrNotEditor is returned by a SaveFunc when one of the editors may no
+	// longer change the post
+	ErrNotEditor = errors.New("liveedit: editor may no longer change the blog")
+	// ErrRejected is returned by a SaveFunc when moderation refused the
+	// content, it was not stored
+	ErrRejected = errors.New("liveedit: content was rejected by moderation")
+	// ErrHeld is returned by a SaveFunc when the content was stored but
+	// moderation held the post for review
+	ErrHeld = errors.New("liveedit: blog is held for review")
+)
+
+// SaveFunc stores the content of a post. base is the content the session
+// started from, the content stored at the last save or when the session was
+// opened, editors are the users who changed it since. It returns an error
+// wrapping ErrConflict, ErrNotEditor, ErrRejected or ErrHeld to close the
+// session, other errors are retried at the next save.
+type SaveFunc func(ctx context.Context, tenantID, blogID, base, content string, editors []string) error
+
+// Manager keeps the open sessions
+type Manager struct {
+	Save            SaveFunc
+	PersistInterval time.Duration
+	// MaxHistory is the number of operations kept to transform late ones
+	MaxHistory int
+	// QueueSize bounds the events waiting for a participant, a participant
+	// who falls further behind is dropped
+	QueueSize int
+	// MaxLength bounds the length of a document in characters
+	MaxLength int
+
+	mu       sync.Mutex
+	sessions map[sessionKey]*Session
+	clients  int64
+}
+
+type sessionKey struct {
+	tenantID string
+	blogID   string
+}
+
+// EventKind tells which fields of an Event are set
+type EventKind int
+
+const (
+	// EventAck confirms an operation of the participant, with Revision and
+	// ClientOpID
+	EventAck EventKind = iota
+	// EventOperation is an operation of another participant, with
+	// Revision, Op and Presence.UserID and ClientID
+	EventOperation
+	// EventPresence is a cursor move or a participant leaving, with Presence
+	EventPresence
+	// EventSaved tells that the session was saved up to Revision, or that
+	// saving failed with Err
+	EventSaved
+)
+
+// Event is sent to a participant
+type Event struct {
+	Kind       EventKind
+	Revision   int64
+	ClientOpID string
+	Op         Op
+	Presence   Presence
+	SavedAt    time.Time
+	Err        error
+}
+
+// Presence is where a participant is in the document, the selection runs
+// from Cursor to SelectionEnd
+type Presence struct {
+	UserID       string
+	ClientID     string
+	Cursor       int
+	SelectionEnd int
+	Left         bool
+}
+
+// Session is the editing session of one post
+type Session struct {
+	manager  *Manager
+	tenantID string
+	blogID   string
+
+	// saveMu keeps saves of the session in order
+	saveMu sync.Mutex
+
+	mu       sync.Mutex
+	doc      string
+	length   int
+	rev      int64
+	savedRev int64
+	// saved is the content at savedRev
+	saved string
+	// err is set when a save closed the session
+	err error
+	// history holds the operations that made revisions base+1 to rev
+	history      []Op
+	base         int64
+	participants map[string]*Participant
+	// editors maps the users who changed the document since the last save
+	// to the last revision they made
+	editors map[string]int64
+}
+
+// Participant is one client in a session
+type Participant struct {
+	UserID   string
+	ClientID string
+	ReadOnly bool
+
+	// Content and Revision are the document when the participant joined,
+	// Others are the participants already there
+	Content  string
+	Revision int64
+	Others   []Presence
+
+	session *Session
+	events  chan Event
+	left    bool
+	dropped bool
+	// cursor and selectionEnd are guarded by the session
+	cursor       int
+	selectionEnd int
+}
+
+// Join adds a participant to the session of a post, opening it with content
+// when it is not open yet. Read events until the channel is closed and call
+// Leave when done.
+func (m *Manager) Join(tenantID, blogID, userID string, readOnly bool, content string) *Participant {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.sessions == nil {
+		m.sessions = map[sessionKey]*Session{}
+	}
+	key := sessionKey{tenantID, blogID}
+	s, ok := m.sessions[key]
+	if !ok || s.closed() {
+		s = &Session{
+			manager:      m,
+			tenantID:     tenantID,
+			blogID:       blogID,
+			doc:          content,
+			saved:        content,
+			length:       utf8.RuneCountInString(content),
+			participants: map[string]*Participant{},
+			editors:      map[string]int64{},
+		}
+		m.sessions[key] = s
+	}
+	m.clients++
+
+	queue := m.QueueSize
+	if queue <= 0 {
+		queue = DefaultQueueSize
+	}
+	p := &Participant{
+		UserID:   userID,
+		ClientID: strconv.FormatInt(m.clients, 10),
+		ReadOnly: readOnly,
+		session:  s,
+		events:   make(chan Event, queue),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p.Content, p.Revision = s.doc, s.rev
+	for _, other := range s.participants {
+		p.Others = append(p.Others, other.presence())
+	}
+	sort.Slice(p.Others, func(i, j int) bool {
+		return p.Others[i].ClientID < p.Others[j].ClientID
+	})
+	s.participants[p.ClientID] = p
+	s.broadcast(p, Event{Kind: EventPresence, Revision: s.rev, Presence: p.presence()})
+	return p
+}
+
+// Events returns the events for the participant, the channel is closed when
+// the participant leaves or is dropped for falling behind
+func (p *Participant) Events() <-chan Event {
+	return p.events
+}
+
+// Dropped reports whether the session dropped the participant for not
+// reading its events
+func (p *Participant) Dropped() bool {
+	p.session.mu.Lock()
+	defer p.session.mu.Unlock()
+	return p.dropped
+}
+
+// Err returns the error that closed the session of the participant, nil
+// while it is open
+func (p *Participant) Err() error {
+	p.session.mu.Lock()
+	defer p.session.mu.Unlock()
+	return p.session.err
+}
+
+// Submit applies an operation made against revision baseRev and returns the
+// revision it made. The participant gets an EventAck, the others the
+// transformed operation.
+func (p *Participant) Submit(baseRev int64, op Op, clientOpID string) (int64, error) {
+	s := p.session
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p.left {
+		return 0, ErrLeft
+	}
+	if p.ReadOnly {
+		return 0, ErrReadOnly
+	}
+	if baseRev > s.rev {
+		return 0, fmt.Errorf("%w: revision %d is ahead of %d", ErrInvalidOp, baseRev, s.rev)
+	}
+	if baseRev < s.base {
+		return 0, fmt.Errorf("%w: %d, the oldest kept is %d", ErrStaleRevision, baseRev, s.base)
+	}
+
+	for _, applied := range s.history[baseRev-s.base:] {
+		transformed, _, err := Transform(op, applied)
+		if err != nil {
+			return 0, err
+		}
+		op = transformed
+	}
+	if op.BaseLen() != s.length {
+		return 0, fmt.Errorf("%w: operation is for a document of %d characters, it has %d", ErrInvalidOp, op.BaseLen(), s.length)
+	}
+	if op.TargetLen() > s.manager.maxLength() && op.TargetLen() > s.length {
+		return 0, fmt.Errorf("%w: %d characters, at most %d", ErrTooLong, op.TargetLen(), s.manager.maxLength())
+	}
+	doc, err := Apply(s.doc, op)
+	if err != nil {
+		return 0, err
+	}
+
+	s.doc, s.length = doc, op.TargetLen()
+	s.rev++
+	s.history = append(s.history, op)
+	if max := s.manager.maxHistory(); len(s.history) > max {
+		drop := len(s.history) - max
+		s.history = append([]Op(nil), s.history[drop:]...)
+		s.base += int64(drop)
+	}
+	s.editors[p.UserID] = s.rev
+
+	for _, other := range s.participants {
+		other.cursor = TransformIndex(other.cursor, op)
+		other.selectionEnd = TransformIndex(other.selectionEnd, op)
+	}
+
+	s.send(p, Event{Kind: EventAck, Revision: s.rev, ClientOpID: clientOpID})
+	s.broadcast(p, Event{Kind: EventOperation, Revision: s.rev, Op: op, Presence: p.presence()})
+	return s.rev, nil
+}
+
+// SetPresence moves the cursor of the participant, the positions are for
+// revision rev and are moved over the operations applied since
+func (p *Participant) SetPresence(rev int64, cursor, selectionEnd int) error {
+	s := p.session
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p.left {
+		return ErrLeft
+	}
+	if rev > s.rev {
+		return fmt.Errorf("%w: revision %d is ahead of %d", ErrInvalidOp, rev, s.rev)
+	}
+	if rev < s.base {
+		return fmt.Errorf("%w: %d, the oldest kept is %d", ErrStaleRevision, rev, s.base)
+	}
+	for _, applied := range s.history[rev-s.base:] {
+		cursor = TransformIndex(cursor, applied)
+		selectionEnd = TransformIndex(selectionEnd, applied)
+	}
+	p.cursor, p.selectionEnd = clamp(cursor, s.length), clamp(selectionEnd, s.length)
+
+	s.broadcast(p, Event{Kind: EventPresence, Revision: s.rev, Presence: p.presence()})
+	return nil
+}
+
+// Leave removes the participant from the session. A participant who could
+// edit saves the session, so its changes are stored when Leave returns, the
+// last participant to leave also closes it.
+func (p *Participant) Leave(ctx context.Context) {
+	s := p.session
+	s.mu.Lock()
+	if !p.left {
+		s.remove(p)
+	}
+	empty := len(s.participants) == 0
+	s.mu.Unlock()
+
+	if empty {
+		s.manager.release(ctx, s)
+	} else if !p.ReadOnly {
+		s.save(ctx)
+	}
+}
+
+func (p *Participant) presence() Presence {
+	return Presence{
+		UserID:       p.UserID,
+		ClientID:     p.ClientID,
+		Cursor:       p.cursor,
+		SelectionEnd: p.selectionEnd,
+		Left:         p.left,
+	}
+}
+
+// remove takes p out of the session and tells the others, s.mu is held
+func (s *Session) remove(p *Participant) {
+	p.left = true
+	delete(s.participants, p.ClientID)
+	close(p.events)
+	s.broadcast(p, Event{Kind: EventPresence, Revision: s.rev, Presence: p.presence()})
+}
+
+// broadcast sends ev to everyone but from, s.mu is held
+func (s *Session) broadcast(from *Participant, ev Event) {
+	for _, p := range s.participants {
+		if p != from {
+			s.send(p, ev)
+		}
+	}
+}
+
+// send queues ev for p without blocking, a participant whose queue is full
+// is dropped so one slow client does not hold up the session. s.mu is held.
+func (s *Session) send(p *Participant, ev Event) {
+	if p.left {
+		return
+	}
+	select {
+	case p.events <- ev:
+	default:
+		log.Printf("liveedit: dropping client %s of %s from blog %s, it is too slow", p.ClientID, p.UserID, s.blogID)
+		p.dropped = true
+		s.remove(p)
+	}
+}
+
+// save stores the content when it changed since the last save and tells the
+// participants how it went
+func (s *Session) save(ctx context.Context) {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mu.Lock()
+	if s.rev == s.savedRev || s.err != nil {
+		s.mu.Unlock()
+		return
+	}
+	base, doc, rev := s.saved, s.doc, s.rev
+	var editors []string
+	for userID := range s.editors {
+		editors = append(editors, userID)
+	}
+	sort.Strings(editors)
+	s.mu.Unlock()
+
+	err := s.manager.Save(ctx, s.tenantID, s.blogID, base, doc, editors)
+	if err != nil {
+		log.Printf("liveedit: failed to save blog %s at revision %d: %v", s.blogID, rev, err)
+	}
+
+	s.mu.Lock()
+	if err == nil {
+		s.savedRev, s.saved = rev, doc
+		// users who edited after the saved revision count for the next save
+		for userID, last := range s.editors {
+			if last <= rev {
+				delete(s.editors, userID)
+			}
+		}
+	}
+	s.broadcast(nil, Event{Kind: EventSaved, Revision: rev, SavedAt: time.Now(), Err: err})
+	if !closing(err) {
+		s.mu.Unlock()
+		return
+	}
+
+	// the changes can not be saved anymore, everyone starts over
+	s.err = err
+	for _, p := range s.participants {
+		s.remove(p)
+	}
+	s.mu.Unlock()
+
+	m := s.manager
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if key := (sessionKey{s.tenantID, s.blogID}); m.sessions[key] == s {
+		delete(m.sessions, key)
+	}
+}
+
+// closing reports whether a save failed with an error that closes the
+// session, retrying the same save would fail again
+func closing(err error) bool {
+	for _, target := range []error{ErrConflict, ErrNotEditor, ErrRejected, ErrHeld} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// closed reports whether a save closed the session
+func (s *Session) closed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err != nil
+}
+
+// release saves a session without participants and closes it, unless
+// someone joined while it was saved
+func (m *Manager) release(ctx context.Context, s *Session) {
+	s.save(ctx)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := sessionKey{s.tenantID, s.blogID}
+	if len(s.participants) == 0 && m.sessions[key] == s {
+		delete(m.sessions, key)
+	}
+}
+
+// Run saves the changed sessions every PersistInterval until ctx is
+// canceled, then saves them one last time
+func (m *Manager) Run(ctx context.Context) {
+	interval := m.PersistInterval
+	if interval <= 0 {
+		interval = DefaultPersistInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			m.saveAll(context.Background())
+			return
+		case <-ticker.C:
+			m.saveAll(ctx)
+		}
+	}
+}
+
+func (m *Manager) saveAll(ctx context.Context) {
+	m.mu.Lock()
+	sessions := make([]*Session, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		sessions = append(sessions, s)
+	}
+	m.mu.Unlock()
+
+	for _, s := range sessions {
+		s.save(ctx)
+	}
+}
+
+func (m *Manager) maxHistory() int {
+	if m.MaxHistory <= 0 {
+		return DefaultMaxHistory
+	}
+	return m.MaxHistory
+}
+
+func (m *Manager) maxLength() int {
+	if m.MaxLength <= 0 {
+		return DefaultMaxLength
+	}
+	return m.MaxLength
+}
+
+func clamp(index, length int) int {
+	if index < 0 {
+		return 0
+	}
+	if index > length {
+		return length
+	}
+	return index
+}
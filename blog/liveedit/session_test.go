@@ -0,0 +1,115 @@
+package liveedit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// store is a SaveFunc keeping the content of one post
+type store struct {
+	mu      sync.Mutex
+	content string
+	// fail is returned by the next save
+	fail error
+}
+
+func (s *store) save(ctx context.Context, tenantID, blogID, base, content string, editors []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.fail; err != nil {
+		s.fail = nil
+		return err
+	}
+	if s.content != base {
+		return ErrConflict
+	}
+	s.content = content
+	return nil
+}
+
+func (s *store) set(content string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.content = content
+}
+
+func insert(t *testing.T, at int, text string, length int) Op {
+	t.Helper()
+	op, err := NewOp(Component{Retain: at}, Component{Insert: text}, Component{Retain: length - at})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return op
+}
+
+// drain reads the events of p until the session closes them
+func drain(p *Participant) {
+	go func() {
+		for range p.Events() {
+		}
+	}()
+}
+
+func TestSessionSaves(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		// outside changes the stored post before the save
+		outside string
+		fail    error
+		closed  error
+		stored  string
+	}{
+		{name: "saved", stored: "hello world!"},
+		{name: "changed outside the session", outside: "hello there", closed: ErrConflict, stored: "hello there"},
+		{name: "editor lost the role", fail: ErrNotEditor, closed: ErrNotEditor, stored: "hello world"},
+		{name: "rejected by moderation", fail: ErrRejected, closed: ErrRejected, stored: "hello world"},
+		{name: "store failing", fail: errors.New("connection reset"), stored: "hello world"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := &store{content: "hello world"}
+			m := &Manager{Save: st.save}
+			p := m.Join("acme", "blog", "fajar", false, st.content)
+			drain(p)
+
+			if _, err := p.Submit(0, insert(t, 11, "!", 11), "1"); err != nil {
+				t.Fatal(err)
+			}
+			if tt.outside != "" {
+				st.set(tt.outside)
+			}
+			st.fail = tt.fail
+			m.saveAll(ctx)
+
+			if err := p.Err(); !errors.Is(err, tt.closed) || (tt.closed == nil && err != nil) {
+				t.Fatalf("Err() = %v, want %v", err, tt.closed)
+			}
+			if st.content != tt.stored {
+				t.Errorf("stored %q, want %q", st.content, tt.stored)
+			}
+			if tt.closed != nil {
+				if _, err := p.Submit(1, insert(t, 0, ">", 12), "2"); !errors.Is(err, ErrLeft) {
+					t.Errorf("Submit after the session closed: err = %v, want ErrLeft", err)
+				}
+				// joining again starts from the stored post
+				again := m.Join("acme", "blog", "fajar", false, st.content)
+				if again.Content != st.content || again.Revision != 0 {
+					t.Errorf("joined again at %q revision %d, want the stored post", again.Content, again.Revision)
+				}
+				return
+			}
+
+			// the next save starts from what is stored now
+			if _, err := p.Submit(1, insert(t, 0, ">", 12), "2"); err != nil {
+				t.Fatal(err)
+			}
+			m.saveAll(ctx)
+			if st.content != ">hello world!" {
+				t.Errorf("second save stored %q, want both changes", st.content)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+// Package locale picks the language a post is shown in. Languages are BCP-47
+// tags such as en, en-US or id, preferences use the syntax of the HTTP
+// Accept-Language header, e.g. "id, en;q=0.8".
+package locale
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/language"
+	"google.golang.org/grpc/metadata"
+)
+
+// MetadataKey is the request metadata read when a request names no
+// preference itself
+const MetadataKey = "accept-language"
+
+// ErrInvalidTag is returned for a language that is not a BCP-47 tag
+var ErrInvalidTag = errors.New("locale: invalid language tag")
+
+// Normalize returns the canonical form of a tag, e.g. en-us becomes en-US
+// and the deprecated in becomes id
+func Normalize(tag string) (string, error) {
+	t, err := language.Parse(strings.TrimSpace(tag))
+	if err != nil || t == language.Und {
+		return "", fmt.Errorf("%w: %q", ErrInvalidTag, tag)
+	}
+	return t.String(), nil
+}
+
+// Preference returns the accept-language preference of a request, the one
+// of the request message wins over the metadata
+func Preference(ctx context.Context, requested string) string {
+	if requested != "" {
+		return requested
+	}
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	return strings.Join(md.Get(MetadataKey), ",")
+}
+
+// Negotiate picks the language to show a post in. original is the language
+// of the post itself and available the languages of its translations.
+//
+// The best match of the preference wins, a request for en-US is served en
+// and one for ms may be served id, a close language. Without a preference,
+// with an invalid one or when nothing matches, the original is shown and
+// Negotiate returns original.
+func Negotiate(accept, original string, available []string) string {
+	if accept == "" || len(available) == 0 {
+		return original
+	}
+	preferred, _, err := language.ParseAcceptLanguage(accept)
+	if err != nil || len(preferred) == 0 {
+		return original
+	}
+
+	// the first tag is the default of the matcher, und stands for a post
+	// of unknown language
+	tags := []string{original}
+	supported := []language.Tag{language.Und}
+	if t, err := language.Parse(original); err == nil {
+		supported[0] = t
+	}
+	for _, tag := range available {
+		t, err := language.Parse(tag)
+		if err != nil {
+			continue
+		}
+		tags = append(tags, tag)
+		supported = append(supported, t)
+	}
+
+	_, index, confidence := language.NewMatcher(supported).Match(preferred...)
+	if confidence == language.No {
+		return original
+	}
+	return tags[index]
+}
@@ -0,0 +1,88 @@
+package locale
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name      string
+		accept    string
+		original  string
+		available []string
+		want      string
+	}{
+		{name: "no preference", accept: "", original: "en", available: []string{"id"}, want: "en"},
+		{name: "no translations", accept: "id", original: "en", want: "en"},
+		{name: "translation", accept: "id", original: "en", available: []string{"id", "fr"}, want: "id"},
+		{name: "original", accept: "en", original: "en", available: []string{"id"}, want: "en"},
+		{name: "region", accept: "en-US", original: "id", available: []string{"en"}, want: "en"},
+		{name: "q-values order the preference", accept: "fr;q=0.5, id;q=0.9", original: "en", available: []string{"fr", "id"}, want: "id"},
+		{name: "first of equal q-values", accept: "fr, id", original: "en", available: []string{"id", "fr"}, want: "fr"},
+		{name: "falls back to the next preference", accept: "de, id;q=0.8", original: "en", available: []string{"id"}, want: "id"},
+		{name: "q=0 is not acceptable", accept: "id;q=0, fr;q=0.5", original: "en", available: []string{"id", "fr"}, want: "fr"},
+		{name: "close language", accept: "ms", original: "en", available: []string{"id"}, want: "id"},
+		{name: "nothing matches", accept: "ja", original: "en", available: []string{"id"}, want: "en"},
+		{name: "invalid preference", accept: "en;q=x;;", original: "en", available: []string{"id"}, want: "en"},
+		{name: "invalid translation is skipped", accept: "id", original: "en", available: []string{"not a tag", "id"}, want: "id"},
+		{name: "unknown original", accept: "fr", original: "", available: []string{"fr"}, want: "fr"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Negotiate(tt.accept, tt.original, tt.available); got != tt.want {
+				t.Errorf("Negotiate(%q, %q, %v) = %q, want %q", tt.accept, tt.original, tt.available, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want string
+		err  error
+	}{
+		{tag: "en-us", want: "en-US"},
+		{tag: " id ", want: "id"},
+		{tag: "in", want: "id"},
+		{tag: "und", err: ErrInvalidTag},
+		{tag: "", err: ErrInvalidTag},
+		{tag: "not a tag", err: ErrInvalidTag},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tag, func(t *testing.T) {
+			got, err := Normalize(tt.tag)
+			if !errors.Is(err, tt.err) || (tt.err == nil && err != nil) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("Normalize(%q) = %q, want %q", tt.tag, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreference(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(MetadataKey, "id", MetadataKey, "en;q=0.5"))
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		requested string
+		want      string
+	}{
+		{name: "request wins", ctx: ctx, requested: "fr", want: "fr"},
+		{name: "metadata", ctx: ctx, want: "id,en;q=0.5"},
+		{name: "nothing", ctx: context.Background(), want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Preference(tt.ctx, tt.requested); got != tt.want {
+				t.Errorf("Preference = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package moderation
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"learn-grpc/blog/repository"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// BannedWords flags posts containing one of Words, matched as whole words
+// without regard to case
+type BannedWords struct {
+	Words  []string
+	Action Action
+}
+
+// LoadWords reads one word per line, empty lines and lines starting with #
+// are skipped
+func LoadWords(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("moderation: open banned words: %w", err)
+	}
+	defer f.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		word := strings.TrimSpace(scanner.Text())
+		if word == "" || strings.HasPrefix(word, "#") {
+			continue
+		}
+		words = append(words, strings.ToLower(word))
+	}
+	return words, scanner.Err()
+}
+
+func (c *BannedWords) Check(ctx context.Context, item *repository.BlogItem) *Violation {
+	banned := map[string]bool{}
+	for _, word := range c.Words {
+		banned[strings.ToLower(word)] = true
+	}
+
+	for _, field := range []struct{ name, text string }{{"title", item.Title}, {"content", item.Content}} {
+		for _, word := range words(field.text) {
+			if banned[word] {
+				return &Violation{
+					Check:   "banned_words",
+					Field:   field.name,
+					Message: fmt.Sprintf("contains the banned word %q", word),
+					Action:  c.Action,
+				}
+			}
+		}
+	}
+	return nil
+}
+
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+`)
+
+// LinkLimit flags posts with more than Max links
+type LinkLimit struct {
+	Max    int
+	Action Action
+}
+
+func (c *LinkLimit) Check(ctx context.Context, item *repository.BlogItem) *Violation {
+	n := len(linkPattern.FindAllStringIndex(item.Title, -1)) + len(linkPattern.FindAllStringIndex(item.Content, -1))
+	if n <= c.Max {
+		return nil
+	}
+	return &Violation{
+		Check:   "link_limit",
+		Field:   "content",
+		Message: fmt.Sprintf("has %d links, at most %d are allowed", n, c.Max),
+		Action:  c.Action,
+	}
+}
+
+// words lower cases text and splits it on everything that is not a letter or a digit
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
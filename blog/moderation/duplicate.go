@@ -0,0 +1,176 @@
+package moderation
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"learn-grpc/blog/repository"
+	"learn-grpc/blog/tenant"
+	"strings"
+	"sync"
+)
+
+// default values used when the matching field of DuplicateContent is zero
+const (
+	DefaultShingleSize = 5
+	DefaultThreshold   = 0.8
+)
+
+// only hashes divisible by sampleRate are kept, it shrinks the index while
+// keeping the expected resemblance the same
+const sampleRate = 4
+
+// DuplicateContent flags posts whose content resembles a post already stored
+// by the same tenant. Contents are cut into shingles (runs of ShingleSize
+// words), the resemblance is the Jaccard index of the two sets of shingle
+// hashes. The index lives in memory and is kept current through Indexer.
+type DuplicateContent struct {
+	ShingleSize int
+	// Threshold is the resemblance from which a post is a duplicate
+	Threshold float64
+	Action    Action
+
+	mu      sync.RWMutex
+	tenants map[string]*shingleIndex
+}
+
+type shingleIndex struct {
+	docs map[string]map[uint64]bool
+	// posting maps a shingle hash to the posts having it
+	posting map[uint64]map[string]bool
+}
+
+func (c *DuplicateContent) Check(ctx context.Context, item *repository.BlogItem) *Violation {
+	hashes := c.shingles(item.Content)
+	if len(hashes) == 0 {
+		return nil
+	}
+	self := item.ID.Hex()
+	// a new post gets its tenant when it is stored
+	tenantID, ok := tenant.FromContext(ctx)
+	if !ok {
+		tenantID = item.TenantID
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	ix, ok := c.tenants[tenantID]
+	if !ok {
+		return nil
+	}
+	common := map[string]int{}
+	for h := range hashes {
+		for id := range ix.posting[h] {
+			if id != self {
+				common[id]++
+			}
+		}
+	}
+
+	bestID, best := "", 0.0
+	for id, n := range common {
+		resemblance := float64(n) / float64(len(hashes)+len(ix.docs[id])-n)
+		if resemblance > best {
+			bestID, best = id, resemblance
+		}
+	}
+	if best < c.threshold() {
+		return nil
+	}
+	return &Violation{
+		Check:   "duplicate_content",
+		Field:   "content",
+		Message: fmt.Sprintf("is %.0f%% similar to post %s", best*100, bestID),
+		Action:  c.Action,
+	}
+}
+
+// Upsert indexes the content of a stored post, rejected posts are not indexed
+func (c *DuplicateContent) Upsert(item *repository.BlogItem) {
+	id := item.ID.Hex()
+	hashes := c.shingles(item.Content)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.tenants == nil {
+		c.tenants = map[string]*shingleIndex{}
+	}
+	ix, ok := c.tenants[item.TenantID]
+	if !ok {
+		ix = &shingleIndex{docs: map[string]map[uint64]bool{}, posting: map[uint64]map[string]bool{}}
+		c.tenants[item.TenantID] = ix
+	}
+
+	ix.remove(id)
+	if item.Status == repository.StatusRejected || len(hashes) == 0 {
+		return
+	}
+	ix.docs[id] = hashes
+	for h := range hashes {
+		if ix.posting[h] == nil {
+			ix.posting[h] = map[string]bool{}
+		}
+		ix.posting[h][id] = true
+	}
+}
+
+func (c *DuplicateContent) Remove(tenantID, blogID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if ix, ok := c.tenants[tenantID]; ok {
+		ix.remove(blogID)
+	}
+}
+
+func (ix *shingleIndex) remove(id string) {
+	for h := range ix.docs[id] {
+		delete(ix.posting[h], id)
+		if len(ix.posting[h]) == 0 {
+			delete(ix.posting, h)
+		}
+	}
+	delete(ix.docs, id)
+}
+
+// shingles returns the sampled hashes of every run of ShingleSize words,
+// a text shorter than one shingle is hashed as a whole
+func (c *DuplicateContent) shingles(text string) map[uint64]bool {
+	size := c.ShingleSize
+	if size <= 0 {
+		size = DefaultShingleSize
+	}
+	ws := words(text)
+	if len(ws) == 0 {
+		return nil
+	}
+	if len(ws) < size {
+		size = len(ws)
+	}
+
+	hashes := map[uint64]bool{}
+	all := map[uint64]bool{}
+	for i := 0; i+size <= len(ws); i++ {
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(ws[i:i+size], " ")))
+		sum := h.Sum64()
+		all[sum] = true
+		if sum%sampleRate == 0 {
+			hashes[sum] = true
+		}
+	}
+	// short texts may have no sampled shingle at all, keep them whole
+	if len(hashes) == 0 {
+		return all
+	}
+	return hashes
+}
+
+func (c *DuplicateContent) threshold() float64 {
+	if c.Threshold > 0 {
+		return c.Threshold
+	}
+	return DefaultThreshold
+}
@@ -0,0 +1,95 @@
+// Package moderation runs a post through a list of checks before it is
+// stored. A check can let the post through, hold it for a moderator
+// (Review) or refuse it (Reject); the strictest result wins.
+package moderation
+
+import (
+	"context"
+	"learn-grpc/blog/repository"
+)
+
+// Action is the outcome of a check, a bigger value is stricter
+type Action int
+
+const (
+	Allow Action = iota
+	Review
+	Reject
+)
+
+func (a Action) String() string {
+	switch a {
+	case Review:
+		return "review"
+	case Reject:
+		return "reject"
+	default:
+		return "allow"
+	}
+}
+
+// Violation explains why a check did not allow a post
+type Violation struct {
+	Check string
+	// Field is the field of the post that caused it, e.g. "content"
+	Field   string
+	Message string
+	Action  Action
+}
+
+// Check inspects a post before it is written, nil means the post is fine
+type Check interface {
+	Check(ctx context.Context, item *repository.BlogItem) *Violation
+}
+
+// Decision is the result of running every check of a pipeline
+type Decision struct {
+	Action     Action
+	Violations []Violation
+}
+
+// Pipeline runs its checks in order, every check runs so the author sees
+// every problem at once
+type Pipeline struct {
+	Checks []Check
+}
+
+func (p *Pipeline) Moderate(ctx context.Context, item *repository.BlogItem) Decision {
+	decision := Decision{Action: Allow}
+	for _, check := range p.Checks {
+		v := check.Check(ctx, item)
+		if v == nil {
+			continue
+		}
+		decision.Violations = append(decision.Violations, *v)
+		if v.Action > decision.Action {
+			decision.Action = v.Action
+		}
+	}
+	return decision
+}
+
+// Indexer is implemented by checks that keep state about stored posts, the
+// server tells them about every committed write
+type Indexer interface {
+	Upsert(item *repository.BlogItem)
+	Remove(tenantID, blogID string)
+}
+
+// Upsert forwards a committed create or update to the checks that keep state
+func (p *Pipeline) Upsert(item *repository.BlogItem) {
+	for _, check := range p.Checks {
+		if ix, ok := check.(Indexer); ok {
+			ix.Upsert(item)
+		}
+	}
+}
+
+// Remove forwards a committed delete to the checks that keep state
+func (p *Pipeline) Remove(tenantID, blogID string) {
+	for _, check := range p.Checks {
+		if ix, ok := check.(Indexer); ok {
+			ix.Remove(tenantID, blogID)
+		}
+	}
+}
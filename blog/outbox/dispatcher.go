@@ -0,0 +1,335 @@
+// Package outbox delivers the events written to the repository outbox to the
+// registered webhooks. Delivery is at least once and not ordered: a receiver can
+// see the same delivery id again after a timeout or a restart of the
+// dispatcher, and a retried event can arrive after a newer one.
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"learn-grpc/blog/repository"
+	"log"
+	"net/http"
+	"time"
+)
+
+// default values used when the matching field of Dispatcher is left empty
+const (
+	DefaultPollInterval   = time.Second
+	DefaultBatchSize      = 100
+	DefaultMaxAttempts    = 8
+	DefaultInitialBackoff = 5 * time.Second
+	DefaultMaxBackoff     = time.Hour
+	DefaultRequestTimeout = 10 * time.Second
+	DefaultRetention      = 7 * 24 * time.Hour
+)
+
+// pruneInterval is how often the dispatcher removes the records older than
+// the retention
+const pruneInterval = time.Hour
+
+// Dispatcher polls the outbox, fans every event out to the webhooks of its
+// tenant that subscribed to it and POSTs the signed payload, retrying with exponential
+// backoff until the delivery succeeds or runs out of attempts
+type Dispatcher struct {
+	Store  repository.Store
+	Client *http.Client
+
+	PollInterval time.Duration
+	BatchSize    int
+	// MaxAttempts before a delivery is moved to the dead-letter state
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Retention is how long dispatched events and delivered deliveries are
+	// kept. Dead deliveries are kept until they are removed by hand
+	Retention time.Duration
+
+	lastPrune time.Time
+}
+
+// payload is the JSON body sent to the webhooks
+type payload struct {
+	ID        string    `json:"id"`
+	TenantID  string    `json:"tenant_id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	BlogID    string    `json:"blog_id"`
+	Blog      *blog     `json:"blog,omitempty"`
+}
+
+type blog struct {
+	ID       string `json:"id"`
+	AuthorID string `json:"author_id"`
+	Title    string `json:"title"`
+	Content  string `json:"content"`
+	// Status tells receivers whether the post is visible to readers
+	Status string `json:"status"`
+}
+
+// Run dispatches until ctx is canceled
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.pollInterval())
+	defer ticker.Stop()
+
+	for {
+		if err := d.Tick(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("outbox: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Tick fans out the pending events and attempts the deliveries that are due once
+func (d *Dispatcher) Tick(ctx context.Context) error {
+	if err := d.fanOut(ctx); err != nil {
+		return fmt.Errorf("fan out events: %w", err)
+	}
+	if err := d.deliver(ctx); err != nil {
+		return fmt.Errorf("deliver events: %w", err)
+	}
+	if time.Since(d.lastPrune) >= pruneInterval {
+		if err := d.prune(ctx); err != nil {
+			return fmt.Errorf("prune: %w", err)
+		}
+	}
+	return nil
+}
+
+func (d *Dispatcher) fanOut(ctx context.Context) error {
+	events, err := d.Store.Outbox().ListPending(ctx, d.batchSize())
+	if err != nil {
+		return err
+	}
+	if len(events) == 0 {
+		return nil
+	}
+
+	hooks, err := d.Store.Webhooks().ListAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		body, err := json.Marshal(eventPayload(event))
+		if err != nil {
+			return err
+		}
+
+		// the deliveries and the dispatched flag are written together, so an
+		// event is never fanned out twice
+		err = d.Store.WithTransaction(ctx, func(ctx context.Context, tx repository.Tx) error {
+			now := time.Now()
+			for _, hook := range hooks {
+				if !hook.Wants(event) {
+					continue
+				}
+				delivery := &repository.Delivery{
+					EventID:       event.ID,
+					EventType:     event.Type,
+					WebhookID:     hook.ID,
+					Payload:       body,
+					Status:        repository.DeliveryPending,
+					NextAttemptAt: now,
+					CreatedAt:     now,
+					UpdatedAt:     now,
+				}
+				if err := tx.Deliveries().Create(ctx, delivery); err != nil {
+					return err
+				}
+			}
+			return tx.Outbox().MarkDispatched(ctx, event.ID)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Dispatcher) deliver(ctx context.Context) error {
+	deliveries, err := d.Store.Deliveries().ListDue(ctx, time.Now(), d.batchSize())
+	if err != nil {
+		return err
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	hooks, err := d.Store.Webhooks().ListAll(ctx)
+	if err != nil {
+		return err
+	}
+	byID := map[string]*repository.Webhook{}
+	for _, hook := range hooks {
+		byID[hook.ID.Hex()] = hook
+	}
+
+	for _, delivery := range deliveries {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		hook, ok := byID[delivery.WebhookID.Hex()]
+		if !ok {
+			// the webhook was deleted after the event was fanned out
+			delivery.Status = repository.DeliveryDead
+			delivery.LastError = "webhook was deleted"
+		} else if err := d.post(ctx, hook, delivery); err != nil {
+			d.failed(delivery, err)
+		} else {
+			delivery.Status = repository.DeliveryDelivered
+			delivery.LastError = ""
+		}
+		delivery.UpdatedAt = time.Now()
+
+		if err := d.Store.Deliveries().Update(ctx, delivery); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prune removes the dispatched events and the delivered deliveries older than
+// the retention
+func (d *Dispatcher) prune(ctx context.Context) error {
+	now := time.Now()
+	before := now.Add(-d.retention())
+	if _, err := d.Store.Outbox().DeleteDispatched(ctx, before); err != nil {
+		return err
+	}
+	if _, err := d.Store.Deliveries().DeleteDelivered(ctx, before); err != nil {
+		return err
+	}
+	d.lastPrune = now
+	return nil
+}
+
+// failed schedules the next attempt or moves the delivery to the dead-letter state
+func (d *Dispatcher) failed(delivery *repository.Delivery, err error) {
+	delivery.LastError = err.Error()
+	if delivery.Attempts >= d.maxAttempts() {
+		delivery.Status = repository.DeliveryDead
+		log.Printf("outbox: delivery %s of event %s is dead after %d attempts: %v",
+			delivery.ID.Hex(), delivery.EventID.Hex(), delivery.Attempts, err)
+		return
+	}
+	delivery.NextAttemptAt = time.Now().Add(d.backoff(delivery.Attempts))
+}
+
+// backoff doubles the wait after every attempt, up to MaxBackoff
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	wait := d.initialBackoff()
+	for i := 1; i < attempts; i++ {
+		wait *= 2
+		if wait >= d.maxBackoff() {
+			return d.maxBackoff()
+		}
+	}
+	return wait
+}
+
+func (d *Dispatcher) post(ctx context.Context, hook *repository.Webhook, delivery *repository.Delivery) error {
+	delivery.Attempts++
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, delivery.EventType)
+	req.Header.Set(HeaderDelivery, delivery.ID.Hex())
+	req.Header.Set(HeaderTimestamp, fmt.Sprint(now.Unix()))
+	req.Header.Set(HeaderSignature, Sign(hook.Secret, now, delivery.Payload))
+
+	res, err := d.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	// drain the body so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %s", res.Status)
+	}
+	return nil
+}
+
+func eventPayload(event *repository.OutboxEvent) *payload {
+	p := &payload{
+		ID:        event.ID.Hex(),
+		TenantID:  event.TenantID,
+		Type:      event.Type,
+		CreatedAt: event.CreatedAt.UTC(),
+		BlogID:    event.BlogID,
+	}
+	if event.Blog != nil {
+		p.Blog = &blog{
+			ID:       event.Blog.ID.Hex(),
+			AuthorID: event.Blog.AuthorID,
+			Title:    event.Blog.Title,
+			Content:  event.Blog.Content,
+			Status:   event.Blog.Status,
+		}
+	}
+	return p
+}
+
+func (d *Dispatcher) client() *http.Client {
+	if d.Client != nil {
+		return d.Client
+	}
+	return &http.Client{Timeout: DefaultRequestTimeout}
+}
+
+func (d *Dispatcher) pollInterval() time.Duration {
+	if d.PollInterval > 0 {
+		return d.PollInterval
+	}
+	return DefaultPollInterval
+}
+
+func (d *Dispatcher) batchSize() int {
+	if d.BatchSize > 0 {
+		return d.BatchSize
+	}
+	return DefaultBatchSize
+}
+
+func (d *Dispatcher) maxAttempts() int {
+	if d.MaxAttempts > 0 {
+		return d.MaxAttempts
+	}
+	return DefaultMaxAttempts
+}
+
+func (d *Dispatcher) initialBackoff() time.Duration {
+	if d.InitialBackoff > 0 {
+		return d.InitialBackoff
+	}
+	return DefaultInitialBackoff
+}
+
+func (d *Dispatcher) maxBackoff() time.Duration {
+	if d.MaxBackoff > 0 {
+		return d.MaxBackoff
+	}
+	return DefaultMaxBackoff
+}
+
+func (d *Dispatcher) retention() time.Duration {
+	if d.Retention > 0 {
+		return d.Retention
+	}
+	return DefaultRetention
+}
@@ -0,0 +1,256 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"learn-grpc/blog/repository"
+	"learn-grpc/blog/tenant"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receiver is a webhook endpoint recording the requests it gets
+type receiver struct {
+	*httptest.Server
+	secret string
+
+	mu       sync.Mutex
+	status   int
+	received []received
+}
+
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+func newReceiver(t *testing.T, secret string) *receiver {
+	t.Helper()
+	r := &receiver{secret: secret, status: http.StatusOK}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.received = append(r.received, received{header: req.Header.Clone(), body: body})
+		w.WriteHeader(r.status)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) setStatus(status int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status = status
+}
+
+func (r *receiver) requests() []received {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]received(nil), r.received...)
+}
+
+// register adds a webhook for the receiver to the tenant
+func register(t *testing.T, store repository.Store, tenantID string, r *receiver, events ...string) *repository.Webhook {
+	t.Helper()
+	hook := &repository.Webhook{URL: r.URL, Secret: r.secret, Events: events, CreatedAt: time.Now()}
+	if err := store.Webhooks().Create(tenant.NewContext(context.Background(), tenantID), hook); err != nil {
+		t.Fatal(err)
+	}
+	return hook
+}
+
+// publish writes an event of the tenant to the outbox
+func publish(t *testing.T, store repository.Store, tenantID, eventType, title string) {
+	t.Helper()
+	ctx := tenant.NewContext(context.Background(), tenantID)
+	err := store.WithTransaction(ctx, func(ctx context.Context, tx repository.Tx) error {
+		return tx.Outbox().Add(ctx, &repository.OutboxEvent{
+			Type:      eventType,
+			BlogID:    "5f5b0a6e8f1b2c3d4e5f6a7b",
+			Blog:      &repository.BlogItem{Title: title, Content: "secret content of " + tenantID},
+			CreatedAt: time.Now(),
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func tick(t *testing.T, d *Dispatcher) {
+	t.Helper()
+	if err := d.Tick(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDispatcherKeepsTenantsApart(t *testing.T) {
+	store := repository.NewMemoryStore(repository.Options{})
+	acme := newReceiver(t, "acme secret")
+	globex := newReceiver(t, "globex secret")
+	register(t, store, "acme", acme)
+	register(t, store, "globex", globex, repository.EventBlogCreated)
+
+	publish(t, store, "acme", repository.EventBlogCreated, "acme post")
+	publish(t, store, "globex", repository.EventBlogCreated, "globex post")
+	publish(t, store, "globex", repository.EventBlogUpdated, "globex update")
+
+	d := &Dispatcher{Store: store}
+	tick(t, d)
+
+	tests := []struct {
+		name     string
+		receiver *receiver
+		tenant   string
+		titles   []string
+	}{
+		{name: "acme", receiver: acme, tenant: "acme", titles: []string{"acme post"}},
+		{name: "globex only subscribed to creates", receiver: globex, tenant: "globex", titles: []string{"globex post"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.receiver.requests()
+			if len(got) != len(tt.titles) {
+				t.Fatalf("got %d requests, want %d", len(got), len(tt.titles))
+			}
+			for i, req := range got {
+				var p payload
+				if err := json.Unmarshal(req.body, &p); err != nil {
+					t.Fatal(err)
+				}
+				if p.TenantID != tt.tenant || p.Blog == nil || p.Blog.Title != tt.titles[i] {
+					t.Errorf("request %d is %+v of tenant %s, want %q of tenant %s", i, p.Blog, p.TenantID, tt.titles[i], tt.tenant)
+				}
+			}
+		})
+	}
+}
+
+func TestWebhooksAreScopedByTenant(t *testing.T) {
+	store := repository.NewMemoryStore(repository.Options{})
+	r := newReceiver(t, "secret")
+	acmeHook := register(t, store, "acme", r)
+	register(t, store, "globex", r)
+
+	acme := tenant.NewContext(context.Background(), "acme")
+	globex := tenant.NewContext(context.Background(), "globex")
+
+	hooks, err := store.Webhooks().List(acme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hooks) != 1 || hooks[0].ID != acmeHook.ID {
+		t.Fatalf("acme lists %d webhooks, want only its own", len(hooks))
+	}
+
+	if err := store.Webhooks().Delete(globex, acmeHook.ID.Hex()); err != repository.ErrNotFound {
+		t.Fatalf("globex deleting the acme webhook: err = %v, want ErrNotFound", err)
+	}
+	if err := store.Webhooks().Delete(acme, acmeHook.ID.Hex()); err != nil {
+		t.Fatalf("acme deleting its webhook: %v", err)
+	}
+	if _, err := store.Webhooks().List(context.Background()); err != tenant.ErrMissing {
+		t.Fatalf("listing without a tenant: err = %v, want ErrMissing", err)
+	}
+}
+
+func TestDeliveriesAreSigned(t *testing.T) {
+	store := repository.NewMemoryStore(repository.Options{})
+	r := newReceiver(t, "acme secret")
+	register(t, store, "acme", r)
+	publish(t, store, "acme", repository.EventBlogCreated, "acme post")
+	tick(t, &Dispatcher{Store: store})
+
+	got := r.requests()
+	if len(got) != 1 {
+		t.Fatalf("got %d requests, want 1", len(got))
+	}
+	h := got[0].header
+	if !Verify("acme secret", h.Get(HeaderSignature), h.Get(HeaderTimestamp), got[0].body, time.Minute) {
+		t.Errorf("signature %q does not verify", h.Get(HeaderSignature))
+	}
+	if h.Get(HeaderEvent) != repository.EventBlogCreated || h.Get(HeaderDelivery) == "" {
+		t.Errorf("event %q, delivery %q", h.Get(HeaderEvent), h.Get(HeaderDelivery))
+	}
+}
+
+func TestDeliveryRetries(t *testing.T) {
+	tests := []struct {
+		name string
+		// statuses answered by the receiver, the last one is repeated
+		statuses []int
+		requests int
+	}{
+		{name: "delivered at once", statuses: []int{http.StatusOK}, requests: 1},
+		{name: "delivered after retries", statuses: []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusOK}, requests: 3},
+		{name: "dead after the last attempt", statuses: []int{http.StatusInternalServerError}, requests: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := repository.NewMemoryStore(repository.Options{})
+			r := newReceiver(t, "secret")
+			register(t, store, "acme", r)
+			publish(t, store, "acme", repository.EventBlogCreated, "acme post")
+			d := &Dispatcher{Store: store, MaxAttempts: 4, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+			for i := 0; i < 10; i++ {
+				status := tt.statuses[len(tt.statuses)-1]
+				if n := len(r.requests()); n < len(tt.statuses) {
+					status = tt.statuses[n]
+				}
+				r.setStatus(status)
+				tick(t, d)
+				time.Sleep(2 * time.Millisecond)
+			}
+
+			if got := len(r.requests()); got != tt.requests {
+				t.Errorf("got %d requests, want %d", got, tt.requests)
+			}
+			due, err := store.Deliveries().ListDue(context.Background(), time.Now().Add(time.Hour), 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(due) != 0 {
+				t.Errorf("%d deliveries are still pending", len(due))
+			}
+			// every attempt sends the same body
+			for _, req := range r.requests()[1:] {
+				if string(req.body) != string(r.requests()[0].body) {
+					t.Errorf("retry sent %s, first attempt %s", req.body, r.requests()[0].body)
+				}
+			}
+		})
+	}
+}
+
+func TestDispatcherPrunes(t *testing.T) {
+	store := repository.NewMemoryStore(repository.Options{})
+	ok := newReceiver(t, "secret")
+	failing := newReceiver(t, "secret")
+	failing.setStatus(http.StatusInternalServerError)
+	register(t, store, "acme", ok)
+	register(t, store, "acme", failing)
+	publish(t, store, "acme", repository.EventBlogCreated, "acme post")
+	d := &Dispatcher{Store: store, MaxAttempts: 1, Retention: time.Millisecond}
+
+	tick(t, d)
+	time.Sleep(2 * time.Millisecond)
+	// the next prune is due an hour after the first one
+	d.lastPrune = time.Time{}
+	tick(t, d)
+
+	ctx := context.Background()
+	if n, err := store.Outbox().DeleteDispatched(ctx, time.Now()); err != nil || n != 0 {
+		t.Errorf("%d dispatched events were left (%v)", n, err)
+	}
+	if n, err := store.Deliveries().DeleteDelivered(ctx, time.Now()); err != nil || n != 0 {
+		t.Errorf("%d delivered deliveries were left (%v)", n, err)
+	}
+	if len(ok.requests()) != 1 || len(failing.requests()) != 1 {
+		t.Errorf("got %d and %d requests, want one each", len(ok.requests()), len(failing.requests()))
+	}
+}
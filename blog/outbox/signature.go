@@ -0,0 +1,50 @@
+package outbox
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// headers sent with every webhook request
+const (
+	HeaderEvent     = "X-Blog-Event"
+	HeaderDelivery  = "X-Blog-Delivery"
+	HeaderTimestamp = "X-Blog-Timestamp"
+	HeaderSignature = "X-Blog-Signature"
+)
+
+const signaturePrefix = "sha256="
+
+// Sign returns the value of the signature header, an HMAC-SHA256 over
+// "<unix timestamp>.<body>" keyed with the webhook secret. The timestamp is
+// part of the signed data so a receiver can reject replayed requests.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature and timestamp headers of a received webhook
+// request, requests older than maxAge are rejected (0 disables the check).
+func Verify(secret, signature, timestamp string, body []byte, maxAge time.Duration) bool {
+	if !strings.HasPrefix(signature, signaturePrefix) {
+		return false
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	sentAt := time.Unix(unix, 0)
+	if maxAge > 0 && time.Since(sentAt) > maxAge {
+		return false
+	}
+
+	expected := Sign(secret, sentAt, body)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
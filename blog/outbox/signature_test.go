@@ -0,0 +1,54 @@
+package outbox
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	now := time.Now()
+	body := []byte(`{"type":"blog.created"}`)
+	signature := Sign("secret", now, body)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+
+	tests := []struct {
+		name      string
+		secret    string
+		signature string
+		timestamp string
+		body      []byte
+		maxAge    time.Duration
+		valid     bool
+	}{
+		{name: "valid", secret: "secret", signature: signature, timestamp: timestamp, body: body, maxAge: time.Minute, valid: true},
+		{name: "other secret", secret: "other", signature: signature, timestamp: timestamp, body: body, maxAge: time.Minute},
+		{name: "changed body", secret: "secret", signature: signature, timestamp: timestamp, body: []byte(`{"type":"blog.deleted"}`), maxAge: time.Minute},
+		{name: "changed timestamp", secret: "secret", signature: signature, timestamp: strconv.FormatInt(now.Unix()+1, 10), body: body, maxAge: time.Minute},
+		{name: "missing prefix", secret: "secret", signature: signature[len(signaturePrefix):], timestamp: timestamp, body: body, maxAge: time.Minute},
+		{name: "bad timestamp", secret: "secret", signature: signature, timestamp: "yesterday", body: body, maxAge: time.Minute},
+		{
+			name:      "too old",
+			secret:    "secret",
+			signature: Sign("secret", now.Add(-time.Hour), body),
+			timestamp: strconv.FormatInt(now.Add(-time.Hour).Unix(), 10),
+			body:      body,
+			maxAge:    time.Minute,
+		},
+		{
+			name:      "age not checked",
+			secret:    "secret",
+			signature: Sign("secret", now.Add(-time.Hour), body),
+			timestamp: strconv.FormatInt(now.Add(-time.Hour).Unix(), 10),
+			body:      body,
+			valid:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Verify(tt.secret, tt.signature, tt.timestamp, tt.body, tt.maxAge); got != tt.valid {
+				t.Errorf("Verify = %v, want %v", got, tt.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+// Package related ranks blog posts by how similar they are to a given post,
+// combining the overlap of their tags with the TF-IDF cosine similarity of
+// their title and content.
+//
+// The index is updated on every write, the inverse document frequencies are
+// computed from the current document counts when a query runs, so adding or
+// removing a post never needs a rebuild. Each process keeps its own index in
+// memory and fills it with Load when it starts.
+package related
+
+import (
+	"context"
+	"learn-grpc/blog/repository"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// default weights of the two signals, used when both fields of Index are zero
+const (
+	DefaultTagWeight  = 0.4
+	DefaultTextWeight = 0.6
+)
+
+// title words count more than content words
+const titleBoost = 2
+
+// Match is a related post and its score between 0 and 1
+type Match struct {
+	BlogID string
+	Score  float64
+}
+
+// Index holds the published posts of every tenant
+type Index struct {
+	TagWeight  float64
+	TextWeight float64
+
+	mu      sync.RWMutex
+	tenants map[string]*corpus
+}
+
+// corpus is the index of one tenant
+type corpus struct {
+	docs map[string]*document
+	// postings maps a term to the documents containing it and the term
+	// frequency in each, the document frequency is the size of the inner map
+	postings map[string]map[string]float64
+	// tagged maps a tag to the documents having it
+	tagged map[string]map[string]bool
+}
+
+type document struct {
+	terms map[string]float64
+	tags  map[string]bool
+}
+
+func newCorpus() *corpus {
+	return &corpus{
+		docs:     map[string]*document{},
+		postings: map[string]map[string]float64{},
+		tagged:   map[string]map[string]bool{},
+	}
+}
+
+// Load indexes every post returned by scan, usually Store.Scan
+func (ix *Index) Load(ctx context.Context, scan func(context.Context, func(*repository.BlogItem) error) error) error {
+	return scan(ctx, func(item *repository.BlogItem) error {
+		ix.Upsert(item)
+		return nil
+	})
+}
+
+// Upsert adds the post or replaces its previous version, posts that are not
+// published are removed so they are never recommended
+func (ix *Index) Upsert(item *repository.BlogItem) {
+	id := item.ID.Hex()
+	if item.Status != repository.StatusPublished {
+		ix.Remove(item.TenantID, id)
+		return
+	}
+	doc := analyze(item)
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	if ix.tenants == nil {
+		ix.tenants = map[string]*corpus{}
+	}
+	c, ok := ix.tenants[item.TenantID]
+	if !ok {
+		c = newCorpus()
+		ix.tenants[item.TenantID] = c
+	}
+
+	c.remove(id)
+	c.docs[id] = doc
+	for term, tf := range doc.terms {
+		if c.postings[term] == nil {
+			c.postings[term] = map[string]float64{}
+		}
+		c.postings[term][id] = tf
+	}
+	for tag := range doc.tags {
+		if c.tagged[tag] == nil {
+			c.tagged[tag] = map[string]bool{}
+		}
+		c.tagged[tag][id] = true
+	}
+}
+
+// Remove drops a post from the index
+func (ix *Index) Remove(tenantID, blogID string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	if c, ok := ix.tenants[tenantID]; ok {
+		c.remove(blogID)
+	}
+}
+
+func (c *corpus) remove(id string) {
+	doc, ok := c.docs[id]
+	if !ok {
+		return
+	}
+	for term := range doc.terms {
+		delete(c.postings[term], id)
+		if len(c.postings[term]) == 0 {
+			delete(c.postings, term)
+		}
+	}
+	for tag := range doc.tags {
+		delete(c.tagged[tag], id)
+		if len(c.tagged[tag]) == 0 {
+			delete(c.tagged, tag)
+		}
+	}
+	delete(c.docs, id)
+}
+
+// Related returns up to limit posts of the same tenant most similar to item,
+// best first. item does not need to be in the index.
+func (ix *Index) Related(item *repository.BlogItem, limit int) []Match {
+	tagWeight, textWeight := ix.TagWeight, ix.TextWeight
+	if tagWeight == 0 && textWeight == 0 {
+		tagWeight, textWeight = DefaultTagWeight, DefaultTextWeight
+	}
+	self := item.ID.Hex()
+	query := analyze(item)
+
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	c, ok := ix.tenants[item.TenantID]
+	if !ok || limit <= 0 {
+		return nil
+	}
+	n := float64(len(c.docs))
+
+	// only documents sharing a term or a tag can score above zero
+	dots := map[string]float64{}
+	queryNorm := 0.0
+	for term, tf := range query.terms {
+		idf := c.idf(term, n)
+		queryNorm += (tf * idf) * (tf * idf)
+		for id, dtf := range c.postings[term] {
+			dots[id] += tf * dtf * idf * idf
+		}
+	}
+	queryNorm = math.Sqrt(queryNorm)
+
+	shared := map[string]int{}
+	for tag := range query.tags {
+		for id := range c.tagged[tag] {
+			shared[id]++
+		}
+	}
+
+	scores := map[string]float64{}
+	for id, dot := range dots {
+		if norm := c.norm(c.docs[id], n); norm > 0 && queryNorm > 0 {
+			scores[id] += textWeight * dot / (norm * queryNorm)
+		}
+	}
+	for id, common := range shared {
+		union := len(query.tags) + len(c.docs[id].tags) - common
+		scores[id] += tagWeight * float64(common) / float64(union)
+	}
+	delete(scores, self)
+
+	matches := make([]Match, 0, len(scores))
+	for id, score := range scores {
+		matches = append(matches, Match{BlogID: id, Score: score})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].BlogID > matches[j].BlogID
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
+
+// idf is the smoothed inverse document frequency of a term
+func (c *corpus) idf(term string, n float64) float64 {
+	return math.Log((n+1)/(float64(len(c.postings[term]))+1)) + 1
+}
+
+func (c *corpus) norm(doc *document, n float64) float64 {
+	sum := 0.0
+	for term, tf := range doc.terms {
+		w := tf * c.idf(term, n)
+		sum += w * w
+	}
+	return math.Sqrt(sum)
+}
+
+func analyze(item *repository.BlogItem) *document {
+	doc := &document{terms: map[string]float64{}, tags: map[string]bool{}}
+	for _, term := range tokenize(item.Title) {
+		doc.terms[term] += titleBoost
+	}
+	for _, term := range tokenize(item.Content) {
+		doc.terms[term]++
+	}
+	for _, tag := range item.Tags {
+		doc.tags[tag] = true
+	}
+	return doc
+}
+
+// tokenize lower cases the text and splits it into words, dropping short
+// words and stop words
+func tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	terms := words[:0]
+	for _, word := range words {
+		if len([]rune(word)) < 2 || stopWords[word] {
+			continue
+		}
+		terms = append(terms, word)
+	}
+	return terms
+}
+
+// stopWords are common english and indonesian words that say nothing about a post
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true,
+	"by": true, "for": true, "from": true, "has": true, "have": true, "in": true, "is": true,
+	"it": true, "its": true, "of": true, "on": true, "or": true, "that": true, "the": true,
+	"this": true, "to": true, "was": true, "were": true, "will": true, "with": true,
+	"my": true, "we": true, "you": true, "i": true, "not": true, "but": true,
+	"dan": true, "yang": true, "di": true, "ke": true, "dari": true, "ini": true, "itu": true,
+	"untuk": true, "dengan": true, "pada": true, "adalah": true, "tidak": true, "akan": true,
+}
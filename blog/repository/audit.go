@@ -0,0 +1,126 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrAuditConflict is returned when another entry took the next place in the
+// chain first, the caller may append again. Inside a transaction it has to
+// run the transaction again.
+var ErrAuditConflict = errors.New("repository: audit chain moved")
+
+// AuditEntry records one call of a mutating RPC. Entries of a tenant form a
+// chain, every entry holds the hash of the one before it, so changing or
+// removing an entry breaks every hash after it.
+type AuditEntry struct {
+	ID primitive.ObjectID `bson:"_id,omitempty"`
+	// TenantID is empty for calls that are not scoped to a tenant
+	TenantID string `bson:"tenant_id"`
+	// Seq is the place in the chain of the tenant, starting at 1
+	Seq  int64     `bson:"seq"`
+	Time time.Time `bson:"time"`
+	// Actor is the subject of the verified token, or the unverified
+	// x-user-id metadata when ActorVerified is false
+	Actor         string `bson:"actor"`
+	ActorVerified bool   `bson:"actor_verified"`
+	Peer          string `bson:"peer"`
+	Method        string `bson:"method"`
+	TargetID      string `bson:"target_id,omitempty"`
+	// hashes of the target before and after the call, empty when it did
+	// not exist
+	BeforeHash string `bson:"before_hash,omitempty"`
+	AfterHash  string `bson:"after_hash,omitempty"`
+	// Code is the grpc status code of the call, e.g. "OK"
+	Code     string `bson:"code"`
+	PrevHash string `bson:"prev_hash"`
+	Hash     string `bson:"hash"`
+}
+
+// ComputeHash returns the hash of the entry, it covers every field except
+// ID and Hash itself
+func (e *AuditEntry) ComputeHash() string {
+	fields := []string{
+		e.PrevHash,
+		strconv.FormatInt(e.Seq, 10),
+		e.TenantID,
+		e.Time.UTC().Format(time.RFC3339Nano),
+		e.Actor,
+		strconv.FormatBool(e.ActorVerified),
+		e.Peer,
+		e.Method,
+		e.TargetID,
+		e.BeforeHash,
+		e.AfterHash,
+		e.Code,
+	}
+	sum := sha256.Sum256([]byte(strings.Join(fields, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// chain links entry after last, last is nil for the first entry of a tenant
+func (e *AuditEntry) chain(last *AuditEntry) {
+	e.Seq, e.PrevHash = 1, ""
+	if last != nil {
+		e.Seq, e.PrevHash = last.Seq+1, last.Hash
+	}
+	// mongodb keeps milliseconds, the hash must survive a round trip
+	e.Time = e.Time.UTC().Truncate(time.Millisecond)
+	e.Hash = e.ComputeHash()
+}
+
+// AuditHead is the last entry of the chain of a tenant. It is kept apart
+// from the entries, so entries removed from the end of the chain are noticed.
+type AuditHead struct {
+	TenantID string `bson:"_id"`
+	Seq      int64  `bson:"seq"`
+	Hash     string `bson:"hash"`
+}
+
+// AuditFilter selects the entries returned by AuditRepository.List, empty
+// fields do not filter
+type AuditFilter struct {
+	TenantID string
+	Actor    string
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+}
+
+// Matches reports whether e passes the filter
+func (f AuditFilter) Matches(e *AuditEntry) bool {
+	if e.TenantID != f.TenantID {
+		return false
+	}
+	if f.Actor != "" && e.Actor != f.Actor {
+		return false
+	}
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !e.Time.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+// AuditRepository is the append-only audit trail, entries can not be changed
+// or removed through it
+type AuditRepository interface {
+	// Append chains the entry after the last entry of entry.TenantID and
+	// stores it, it sets ID, Seq, PrevHash and Hash and moves the head
+	Append(ctx context.Context, entry *AuditEntry) error
+	// Head returns the head of the chain of a tenant, nil when the chain was
+	// started before heads were kept and nothing was appended since
+	Head(ctx context.Context, tenantID string) (*AuditHead, error)
+	// List calls fn for the entries of filter.TenantID matching the filter,
+	// in chain order
+	List(ctx context.Context, filter AuditFilter, fn func(*AuditEntry) error) error
+}
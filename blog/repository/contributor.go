@@ -0,0 +1,64 @@
+package repository
+
+import "time"
+
+// contributor roles, from the most to the least rights
+const (
+	// RoleOwner can do everything, including managing contributors and
+	// deleting the post
+	RoleOwner = "owner"
+	// RoleEditor can change the post
+	RoleEditor = "editor"
+	// RoleViewer can read the post before it is published
+	RoleViewer = "viewer"
+)
+
+var roleRank = map[string]int{RoleViewer: 1, RoleEditor: 2, RoleOwner: 3}
+
+// ValidRole reports whether role is one of the contributor roles
+func ValidRole(role string) bool {
+	return roleRank[role] > 0
+}
+
+// RoleAtLeast reports whether role has the rights of min, an empty role has none
+func RoleAtLeast(role, min string) bool {
+	return roleRank[role] > 0 && roleRank[role] >= roleRank[min]
+}
+
+// Contributor is a user working on a post
+type Contributor struct {
+	UserID  string    `bson:"user_id"`
+	Role    string    `bson:"role"`
+	AddedAt time.Time `bson:"added_at"`
+}
+
+// RoleOf returns the role of a user on the post, empty when the user is not
+// a contributor
+func (b *BlogItem) RoleOf(userID string) string {
+	for _, c := range b.Contributors {
+		if c.UserID == userID {
+			return c.Role
+		}
+	}
+	return ""
+}
+
+// Owners returns the number of contributors with the owner role
+func (b *BlogItem) Owners() int {
+	n := 0
+	for _, c := range b.Contributors {
+		if c.Role == RoleOwner {
+			n++
+		}
+	}
+	return n
+}
+
+// ensureOwner makes the author the owner of a post that has no contributors,
+// posts written before contributors existed only have an author
+func (b *BlogItem) ensureOwner() {
+	if len(b.Contributors) > 0 || b.AuthorID == "" {
+		return
+	}
+	b.Contributors = []Contributor{{UserID: b.AuthorID, Role: RoleOwner, AddedAt: b.CreatedAt}}
+}
@@ -0,0 +1,472 @@
+package repository
+
+import (
+	"context"
+	"learn-grpc/blog/tenant"
+	"sort"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryStore keeps the blog data in memory, it is meant for development and
+// for running the server without mongodb
+type MemoryStore struct {
+	mu    sync.RWMutex
+	state *memoryState
+	opts  Options
+}
+
+type memoryState struct {
+	blogs      map[primitive.ObjectID]*BlogItem
+	outbox     map[primitive.ObjectID]*OutboxEvent
+	webhooks   map[primitive.ObjectID]*Webhook
+	deliveries map[primitive.ObjectID]*Delivery
+	// reactions are keyed by reactionKey
+	reactions map[string]*Reaction
+	// audit holds the chain of every tenant, entries are never changed
+	audit      map[string][]*AuditEntry
+	auditHeads map[string]*AuditHead
+	revisions  map[primitive.ObjectID]*Revision
+}
+
+func NewMemoryStore(opts Options) *MemoryStore {
+	return &MemoryStore{state: newMemoryState(), opts: opts}
+}
+
+func newMemoryState() *memoryState {
+	return &memoryState{
+		blogs:      map[primitive.ObjectID]*BlogItem{},
+		outbox:     map[primitive.ObjectID]*OutboxEvent{},
+		webhooks:   map[primitive.ObjectID]*Webhook{},
+		deliveries: map[primitive.ObjectID]*Delivery{},
+		reactions:  map[string]*Reaction{},
+		audit:      map[string][]*AuditEntry{},
+		auditHeads: map[string]*AuditHead{},
+		revisions:  map[primitive.ObjectID]*Revision{},
+	}
+}
+
+// collection names a map of memoryState, a transaction copies the ones it
+// writes to
+type collection int
+
+const (
+	collBlogs collection = iota
+	collOutbox
+	collWebhooks
+	collDeliveries
+	collReactions
+	collAudit
+	collRevisions
+)
+
+// copy gives st its own copy of the collection, st shares the other
+// collections with the state it was copied from
+func (st *memoryState) copy(c collection) {
+	switch c {
+	case collBlogs:
+		blogs := make(map[primitive.ObjectID]*BlogItem, len(st.blogs))
+		for id, item := range st.blogs {
+			blogs[id] = cloneBlog(item)
+		}
+		st.blogs = blogs
+	case collOutbox:
+		outbox := make(map[primitive.ObjectID]*OutboxEvent, len(st.outbox))
+		for id, event := range st.outbox {
+			outbox[id] = cloneEvent(event)
+		}
+		st.outbox = outbox
+	case collWebhooks:
+		webhooks := make(map[primitive.ObjectID]*Webhook, len(st.webhooks))
+		for id, hook := range st.webhooks {
+			webhooks[id] = cloneWebhook(hook)
+		}
+		st.webhooks = webhooks
+	case collDeliveries:
+		deliveries := make(map[primitive.ObjectID]*Delivery, len(st.deliveries))
+		for id, delivery := range st.deliveries {
+			deliveries[id] = cloneDelivery(delivery)
+		}
+		st.deliveries = deliveries
+	case collReactions:
+		reactions := make(map[string]*Reaction, len(st.reactions))
+		for key, reaction := range st.reactions {
+			copied := *reaction
+			reactions[key] = &copied
+		}
+		st.reactions = reactions
+	case collAudit:
+		// entries and heads are never changed, only appended and replaced,
+		// so the chains share their arrays. An entry appended by a
+		// transaction that is rolled back lies past the length of the live
+		// chain, where the next append overwrites it.
+		audit := make(map[string][]*AuditEntry, len(st.audit))
+		for tenantID, chain := range st.audit {
+			audit[tenantID] = chain
+		}
+		heads := make(map[string]*AuditHead, len(st.auditHeads))
+		for tenantID, head := range st.auditHeads {
+			heads[tenantID] = head
+		}
+		st.audit, st.auditHeads = audit, heads
+	case collRevisions:
+		revisions := make(map[primitive.ObjectID]*Revision, len(st.revisions))
+		for id, rev := range st.revisions {
+			revisions[id] = cloneRevision(rev)
+		}
+		st.revisions = revisions
+	}
+}
+
+func cloneBlog(item *BlogItem) *BlogItem {
+	c := *item
+	c.Tags = append([]string(nil), item.Tags...)
+	c.Contributors = append([]Contributor(nil), item.Contributors...)
+	if item.Translations != nil {
+		c.Translations = make(map[string]Translation, len(item.Translations))
+		for tag, t := range item.Translations {
+			c.Translations[tag] = t
+		}
+	}
+	if item.Reactions != nil {
+		c.Reactions = make(map[string]int64, len(item.Reactions))
+		for reaction, n := range item.Reactions {
+			c.Reactions[reaction] = n
+		}
+	}
+	return &c
+}
+
+func (s *MemoryStore) Blogs() BlogRepository {
+	return &memoryBlogs{memoryView{store: s}, s.opts.Quotas}
+}
+
+func (s *MemoryStore) Outbox() OutboxRepository {
+	return &memoryOutbox{memoryView{store: s}}
+}
+
+func (s *MemoryStore) Webhooks() WebhookRepository {
+	return &memoryWebhooks{memoryView{store: s}}
+}
+
+func (s *MemoryStore) Deliveries() DeliveryRepository {
+	return &memoryDeliveries{memoryView{store: s}}
+}
+
+func (s *MemoryStore) Reactions() ReactionRepository {
+	return &memoryReactions{memoryView{store: s}}
+}
+
+func (s *MemoryStore) Audit() AuditRepository {
+	return &memoryAudit{memoryView{store: s}}
+}
+
+func (s *MemoryStore) Revisions() RevisionRepository {
+	return &memoryRevisions{memoryView{store: s}}
+}
+
+// WithTransaction holds the write lock while fn runs on its own state, the
+// state replaces the live data only when fn succeeds. The state shares the
+// collections with the live data until fn writes to one, which copies that
+// collection only. Calling the repositories of the store itself (instead of
+// tx) inside fn deadlocks.
+func (s *MemoryStore) WithTransaction(ctx context.Context, fn func(ctx context.Context, tx Tx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := *s.state
+	tx := &memoryTx{state: &state, copied: map[collection]bool{}, opts: s.opts}
+	if err := fn(ctx, tx); err != nil {
+		return err
+	}
+	s.state = tx.state
+	return nil
+}
+
+func (s *MemoryStore) Scan(ctx context.Context, fn func(*BlogItem) error) error {
+	s.mu.RLock()
+	items := make([]*BlogItem, 0, len(s.state.blogs))
+	for _, item := range s.state.blogs {
+		items = append(items, cloneBlog(item))
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].ID.Hex() < items[j].ID.Hex()
+	})
+	for _, item := range items {
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type memoryTx struct {
+	state *memoryState
+	// copied are the collections state does not share with the live data
+	copied map[collection]bool
+	opts   Options
+}
+
+// view returns the view of a repository writing to the collections writes
+func (tx *memoryTx) view(writes ...collection) memoryView {
+	return memoryView{tx: tx, writes: writes}
+}
+
+func (tx *memoryTx) Blogs() BlogRepository {
+	return &memoryBlogs{tx.view(collBlogs), tx.opts.Quotas}
+}
+
+func (tx *memoryTx) Outbox() OutboxRepository {
+	return &memoryOutbox{tx.view(collOutbox)}
+}
+
+func (tx *memoryTx) Webhooks() WebhookRepository {
+	return &memoryWebhooks{tx.view(collWebhooks)}
+}
+
+func (tx *memoryTx) Deliveries() DeliveryRepository {
+	return &memoryDeliveries{tx.view(collDeliveries)}
+}
+
+// Reactions also writes the reaction counters of the posts
+func (tx *memoryTx) Reactions() ReactionRepository {
+	return &memoryReactions{tx.view(collReactions, collBlogs)}
+}
+
+func (tx *memoryTx) Audit() AuditRepository {
+	return &memoryAudit{tx.view(collAudit)}
+}
+
+func (tx *memoryTx) Revisions() RevisionRepository {
+	return &memoryRevisions{tx.view(collRevisions)}
+}
+
+// memoryView runs an operation either on the live data, taking the store
+// lock, or on the state of a transaction, which is already locked. In a
+// transaction writes lists the collections the repository writes to, they
+// are copied before the first write.
+type memoryView struct {
+	store  *MemoryStore
+	tx     *memoryTx
+	writes []collection
+}
+
+func (v memoryView) read(fn func(st *memoryState) error) error {
+	if v.tx != nil {
+		return fn(v.tx.state)
+	}
+	v.store.mu.RLock()
+	defer v.store.mu.RUnlock()
+	return fn(v.store.state)
+}
+
+func (v memoryView) write(fn func(st *memoryState) error) error {
+	if v.tx != nil {
+		for _, c := range v.writes {
+			if !v.tx.copied[c] {
+				v.tx.state.copy(c)
+				v.tx.copied[c] = true
+			}
+		}
+		return fn(v.tx.state)
+	}
+	v.store.mu.Lock()
+	defer v.store.mu.Unlock()
+	return fn(v.store.state)
+}
+
+type memoryBlogs struct {
+	memoryView
+	quotas *tenant.Quotas
+}
+
+// usage returns how many posts and bytes a tenant stores, st must be locked
+func (st *memoryState) usage(tenantID string) (posts, bytes int64) {
+	for _, item := range st.blogs {
+		if item.TenantID == tenantID {
+			posts++
+			bytes += item.SizeBytes
+		}
+	}
+	return posts, bytes
+}
+
+// blog returns the post only when it belongs to the tenant, st must be locked
+func (st *memoryState) blog(tenantID string, id primitive.ObjectID) (*BlogItem, bool) {
+	item, ok := st.blogs[id]
+	if !ok || item.TenantID != tenantID {
+		return nil, false
+	}
+	return item, true
+}
+
+func (r *memoryBlogs) Create(ctx context.Context, item *BlogItem) error {
+	tenantID, err := tenantOf(ctx)
+	if err != nil {
+		return err
+	}
+
+	return r.write(func(st *memoryState) error {
+		item.TenantID = tenantID
+		item.derive()
+
+		posts, bytes := st.usage(tenantID)
+		if err := checkQuota(r.quotas, tenantID, posts, bytes, 1, item.SizeBytes); err != nil {
+			return err
+		}
+
+		item.ID = primitive.NewObjectID()
+		st.blogs[item.ID] = cloneBlog(item)
+		return nil
+	})
+}
+
+func (r *memoryBlogs) FindByID(ctx context.Context, id string) (*BlogItem, error) {
+	tenantID, err := tenantOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	oid, err := parseID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var found *BlogItem
+	err = r.read(func(st *memoryState) error {
+		item, ok := st.blog(tenantID, oid)
+		if !ok {
+			return ErrNotFound
+		}
+		found = cloneBlog(item)
+		return nil
+	})
+	return found, err
+}
+
+func (r *memoryBlogs) Replace(ctx context.Context, item *BlogItem) error {
+	tenantID, err := tenantOf(ctx)
+	if err != nil {
+		return err
+	}
+
+	return r.write(func(st *memoryState) error {
+		old, ok := st.blog(tenantID, item.ID)
+		if !ok {
+			return ErrNotFound
+		}
+		item.TenantID = tenantID
+		item.derive()
+
+		posts, bytes := st.usage(tenantID)
+		if err := checkQuota(r.quotas, tenantID, posts, bytes, 0, item.SizeBytes-old.SizeBytes); err != nil {
+			return err
+		}
+
+		replaced := cloneBlog(item)
+		replaced.Reactions = old.Reactions
+		replaced.ViewCount = old.ViewCount
+		st.blogs[item.ID] = replaced
+		return nil
+	})
+}
+
+func (r *memoryBlogs) Delete(ctx context.Context, id string) error {
+	tenantID, err := tenantOf(ctx)
+	if err != nil {
+		return err
+	}
+	oid, err := parseID(id)
+	if err != nil {
+		return err
+	}
+
+	return r.write(func(st *memoryState) error {
+		if _, ok := st.blog(tenantID, oid); !ok {
+			return ErrNotFound
+		}
+		delete(st.blogs, oid)
+		return nil
+	})
+}
+
+func (r *memoryBlogs) IncrementViews(ctx context.Context, id string, n int64) error {
+	tenantID, err := tenantOf(ctx)
+	if err != nil {
+		return err
+	}
+	oid, err := parseID(id)
+	if err != nil {
+		return err
+	}
+
+	return r.write(func(st *memoryState) error {
+		item, ok := st.blog(tenantID, oid)
+		if !ok {
+			return ErrNotFound
+		}
+		item.ViewCount += n
+		return nil
+	})
+}
+
+func (r *memoryBlogs) List(ctx context.Context, filter ListFilter, fn func(*BlogItem) error) error {
+	tenantID, err := tenantOf(ctx)
+	if err != nil {
+		return err
+	}
+
+	// copy under the lock and call fn without it, fn may be slow
+	var items []*BlogItem
+	r.read(func(st *memoryState) error {
+		for _, item := range st.blogs {
+			if item.TenantID == tenantID && filter.matches(item) {
+				items = append(items, cloneBlog(item))
+			}
+		}
+		return nil
+	})
+	// object ids grow with time, so this is insertion order like mongodb
+	sort.Slice(items, func(i, j int) bool {
+		if filter.NewestFirst {
+			if !items[i].CreatedAt.Equal(items[j].CreatedAt) {
+				return items[i].CreatedAt.After(items[j].CreatedAt)
+			}
+			return items[i].ID.Hex() > items[j].ID.Hex()
+		}
+		return items[i].ID.Hex() < items[j].ID.Hex()
+	})
+	if filter.Limit > 0 && len(items) > filter.Limit {
+		items = items[:filter.Limit]
+	}
+
+	for _, item := range items {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *memoryBlogs) Stats(ctx context.Context, filter StatsFilter) (*BlogStats, error) {
+	tenantID, err := tenantOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	agg := newStatsAggregator()
+	r.read(func(st *memoryState) error {
+		for _, item := range st.blogs {
+			if item.TenantID == tenantID && filter.matches(item) {
+				agg.add(item)
+			}
+		}
+		return nil
+	})
+	return agg.stats(filter.Limit), nil
+}
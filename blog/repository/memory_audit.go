@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type memoryAudit struct {
+	memoryView
+}
+
+func (r *memoryAudit) Append(ctx context.Context, entry *AuditEntry) error {
+	return r.write(func(st *memoryState) error {
+		// the last entry, not the head, like mongoAudit
+		chain := st.audit[entry.TenantID]
+		var last *AuditEntry
+		if len(chain) > 0 {
+			last = chain[len(chain)-1]
+		}
+		entry.ID = primitive.NewObjectID()
+		entry.chain(last)
+
+		copied := *entry
+		st.audit[entry.TenantID] = append(chain, &copied)
+		st.auditHeads[entry.TenantID] = &AuditHead{TenantID: entry.TenantID, Seq: entry.Seq, Hash: entry.Hash}
+		return nil
+	})
+}
+
+func (r *memoryAudit) Head(ctx context.Context, tenantID string) (*AuditHead, error) {
+	var head *AuditHead
+	r.read(func(st *memoryState) error {
+		if h, ok := st.auditHeads[tenantID]; ok {
+			copied := *h
+			head = &copied
+		}
+		return nil
+	})
+	return head, nil
+}
+
+func (r *memoryAudit) List(ctx context.Context, filter AuditFilter, fn func(*AuditEntry) error) error {
+	var entries []*AuditEntry
+	r.read(func(st *memoryState) error {
+		for _, entry := range st.audit[filter.TenantID] {
+			if filter.Matches(entry) {
+				copied := *entry
+				entries = append(entries, &copied)
+			}
+		}
+		return nil
+	})
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		entries = entries[:filter.Limit]
+	}
+
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
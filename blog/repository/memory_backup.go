@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"context"
+	"sort"
+)
+
+func (s *MemoryStore) ScanReactions(ctx context.Context, fn func(*Reaction) error) error {
+	s.mu.RLock()
+	reactions := make([]*Reaction, 0, len(s.state.reactions))
+	for _, reaction := range s.state.reactions {
+		copied := *reaction
+		reactions = append(reactions, &copied)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(reactions, func(i, j int) bool {
+		return reactions[i].ID.Hex() < reactions[j].ID.Hex()
+	})
+	for _, reaction := range reactions {
+		if err := fn(reaction); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) ScanRevisions(ctx context.Context, fn func(*Revision) error) error {
+	s.mu.RLock()
+	revs := make([]*Revision, 0, len(s.state.revisions))
+	for _, rev := range s.state.revisions {
+		revs = append(revs, cloneRevision(rev))
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(revs, func(i, j int) bool {
+		return revs[i].ID.Hex() < revs[j].ID.Hex()
+	})
+	for _, rev := range revs {
+		if err := fn(rev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) RestoreBlog(ctx context.Context, item *BlogItem, reactions []*Reaction, revisions []*Revision) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item.derive()
+	st := s.state
+	if old, ok := st.blogs[item.ID]; ok && old.TenantID != item.TenantID {
+		return ErrIDTaken
+	}
+	st.blogs[item.ID] = cloneBlog(item)
+
+	for key, reaction := range st.reactions {
+		if reaction.BlogID == item.ID {
+			delete(st.reactions, key)
+		}
+	}
+	for _, reaction := range reactions {
+		copied := *reaction
+		st.reactions[reactionKey(copied.TenantID, copied.BlogID, copied.UserID, copied.Type)] = &copied
+	}
+
+	for id, rev := range st.revisions {
+		if rev.BlogID == item.ID {
+			delete(st.revisions, id)
+		}
+	}
+	for _, rev := range revisions {
+		st.revisions[rev.ID] = cloneRevision(rev)
+	}
+	return nil
+}
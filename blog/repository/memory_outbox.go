@@ -0,0 +1,220 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func cloneEvent(event *OutboxEvent) *OutboxEvent {
+	c := *event
+	if event.Blog != nil {
+		c.Blog = cloneBlog(event.Blog)
+	}
+	return &c
+}
+
+func cloneWebhook(hook *Webhook) *Webhook {
+	c := *hook
+	c.Events = append([]string(nil), hook.Events...)
+	return &c
+}
+
+func cloneDelivery(delivery *Delivery) *Delivery {
+	c := *delivery
+	c.Payload = append([]byte(nil), delivery.Payload...)
+	return &c
+}
+
+type memoryOutbox struct {
+	memoryView
+}
+
+func (r *memoryOutbox) Add(ctx context.Context, event *OutboxEvent) error {
+	tenantID, err := tenantOf(ctx)
+	if err != nil {
+		return err
+	}
+
+	return r.write(func(st *memoryState) error {
+		event.TenantID = tenantID
+		if event.ID.IsZero() {
+			event.ID = primitive.NewObjectID()
+		}
+		st.outbox[event.ID] = cloneEvent(event)
+		return nil
+	})
+}
+
+func (r *memoryOutbox) ListPending(ctx context.Context, limit int) ([]*OutboxEvent, error) {
+	var events []*OutboxEvent
+	r.read(func(st *memoryState) error {
+		for _, event := range st.outbox {
+			if !event.Dispatched {
+				events = append(events, cloneEvent(event))
+			}
+		}
+		return nil
+	})
+
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].ID.Hex() < events[j].ID.Hex()
+	})
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+func (r *memoryOutbox) MarkDispatched(ctx context.Context, id primitive.ObjectID) error {
+	return r.write(func(st *memoryState) error {
+		event, ok := st.outbox[id]
+		if !ok {
+			return ErrNotFound
+		}
+		event.Dispatched = true
+		return nil
+	})
+}
+
+func (r *memoryOutbox) DeleteDispatched(ctx context.Context, before time.Time) (int64, error) {
+	var n int64
+	err := r.write(func(st *memoryState) error {
+		for id, event := range st.outbox {
+			if event.Dispatched && event.CreatedAt.Before(before) {
+				delete(st.outbox, id)
+				n++
+			}
+		}
+		return nil
+	})
+	return n, err
+}
+
+type memoryWebhooks struct {
+	memoryView
+}
+
+func (r *memoryWebhooks) Create(ctx context.Context, hook *Webhook) error {
+	tenantID, err := tenantOf(ctx)
+	if err != nil {
+		return err
+	}
+
+	return r.write(func(st *memoryState) error {
+		hook.TenantID = tenantID
+		if hook.ID.IsZero() {
+			hook.ID = primitive.NewObjectID()
+		}
+		st.webhooks[hook.ID] = cloneWebhook(hook)
+		return nil
+	})
+}
+
+func (r *memoryWebhooks) List(ctx context.Context) ([]*Webhook, error) {
+	tenantID, err := tenantOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.list(func(hook *Webhook) bool { return hook.TenantID == tenantID }), nil
+}
+
+func (r *memoryWebhooks) ListAll(ctx context.Context) ([]*Webhook, error) {
+	return r.list(func(*Webhook) bool { return true }), nil
+}
+
+func (r *memoryWebhooks) list(keep func(*Webhook) bool) []*Webhook {
+	var hooks []*Webhook
+	r.read(func(st *memoryState) error {
+		for _, hook := range st.webhooks {
+			if keep(hook) {
+				hooks = append(hooks, cloneWebhook(hook))
+			}
+		}
+		return nil
+	})
+
+	sort.Slice(hooks, func(i, j int) bool {
+		return hooks[i].ID.Hex() < hooks[j].ID.Hex()
+	})
+	return hooks
+}
+
+func (r *memoryWebhooks) Delete(ctx context.Context, id string) error {
+	tenantID, err := tenantOf(ctx)
+	if err != nil {
+		return err
+	}
+	oid, err := parseID(id)
+	if err != nil {
+		return err
+	}
+
+	return r.write(func(st *memoryState) error {
+		if hook, ok := st.webhooks[oid]; !ok || hook.TenantID != tenantID {
+			return ErrNotFound
+		}
+		delete(st.webhooks, oid)
+		return nil
+	})
+}
+
+type memoryDeliveries struct {
+	memoryView
+}
+
+func (r *memoryDeliveries) Create(ctx context.Context, delivery *Delivery) error {
+	return r.write(func(st *memoryState) error {
+		if delivery.ID.IsZero() {
+			delivery.ID = primitive.NewObjectID()
+		}
+		st.deliveries[delivery.ID] = cloneDelivery(delivery)
+		return nil
+	})
+}
+
+func (r *memoryDeliveries) ListDue(ctx context.Context, now time.Time, limit int) ([]*Delivery, error) {
+	var deliveries []*Delivery
+	r.read(func(st *memoryState) error {
+		for _, delivery := range st.deliveries {
+			if delivery.Status == DeliveryPending && !delivery.NextAttemptAt.After(now) {
+				deliveries = append(deliveries, cloneDelivery(delivery))
+			}
+		}
+		return nil
+	})
+
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].NextAttemptAt.Before(deliveries[j].NextAttemptAt)
+	})
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
+
+func (r *memoryDeliveries) Update(ctx context.Context, delivery *Delivery) error {
+	return r.write(func(st *memoryState) error {
+		if _, ok := st.deliveries[delivery.ID]; !ok {
+			return ErrNotFound
+		}
+		st.deliveries[delivery.ID] = cloneDelivery(delivery)
+		return nil
+	})
+}
+
+func (r *memoryDeliveries) DeleteDelivered(ctx context.Context, before time.Time) (int64, error) {
+	var n int64
+	err := r.write(func(st *memoryState) error {
+		for id, delivery := range st.deliveries {
+			if delivery.Status == DeliveryDelivered && delivery.UpdatedAt.Before(before) {
+				delete(st.deliveries, id)
+				n++
+			}
+		}
+		return nil
+	})
+	return n, err
+}
@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func reactionKey(tenantID string, blogID primitive.ObjectID, userID, reactionType string) string {
+	return strings.Join([]string{tenantID, blogID.Hex(), userID, reactionType}, "\x00")
+}
+
+type memoryReactions struct {
+	memoryView
+}
+
+// change adds or removes the reaction of a user, add is false for a remove
+func (r *memoryReactions) change(ctx context.Context, blogID, userID, reactionType string, add bool) (bool, error) {
+	tenantID, err := tenantOf(ctx)
+	if err != nil {
+		return false, err
+	}
+	oid, err := parseID(blogID)
+	if err != nil {
+		return false, err
+	}
+	if !ValidReaction(reactionType) {
+		return false, ErrInvalidReaction
+	}
+
+	changed := false
+	err = r.write(func(st *memoryState) error {
+		item, ok := st.blog(tenantID, oid)
+		if !ok {
+			return ErrNotFound
+		}
+
+		key := reactionKey(tenantID, oid, userID, reactionType)
+		_, exists := st.reactions[key]
+		switch {
+		case add && !exists:
+			st.reactions[key] = &Reaction{
+				ID:        primitive.NewObjectID(),
+				TenantID:  tenantID,
+				BlogID:    oid,
+				UserID:    userID,
+				Type:      reactionType,
+				CreatedAt: time.Now(),
+			}
+			if item.Reactions == nil {
+				item.Reactions = map[string]int64{}
+			}
+			item.Reactions[reactionType]++
+			changed = true
+		case !add && exists:
+			delete(st.reactions, key)
+			item.Reactions[reactionType]--
+			changed = true
+		}
+		return nil
+	})
+	return changed, err
+}
+
+func (r *memoryReactions) Add(ctx context.Context, blogID, userID, reactionType string) (bool, error) {
+	return r.change(ctx, blogID, userID, reactionType, true)
+}
+
+func (r *memoryReactions) Remove(ctx context.Context, blogID, userID, reactionType string) (bool, error) {
+	return r.change(ctx, blogID, userID, reactionType, false)
+}
+
+func (r *memoryReactions) DeleteForBlog(ctx context.Context, blogID string) error {
+	tenantID, err := tenantOf(ctx)
+	if err != nil {
+		return err
+	}
+	oid, err := parseID(blogID)
+	if err != nil {
+		return err
+	}
+
+	return r.write(func(st *memoryState) error {
+		for key, reaction := range st.reactions {
+			if reaction.TenantID == tenantID && reaction.BlogID == oid {
+				delete(st.reactions, key)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"context"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func cloneRevision(rev *Revision) *Revision {
+	c := *rev
+	c.Editors = append([]string(nil), rev.Editors...)
+	return &c
+}
+
+type memoryRevisions struct {
+	memoryView
+}
+
+func (r *memoryRevisions) Add(ctx context.Context, blogID string, rev *Revision) error {
+	tenantID, err := tenantOf(ctx)
+	if err != nil {
+		return err
+	}
+	oid, err := parseID(blogID)
+	if err != nil {
+		return err
+	}
+
+	return r.write(func(st *memoryState) error {
+		if _, ok := st.blog(tenantID, oid); !ok {
+			return ErrNotFound
+		}
+		rev.ID = primitive.NewObjectID()
+		rev.TenantID = tenantID
+		rev.BlogID = oid
+		rev.Number = 1
+		for _, other := range st.revisions {
+			if other.BlogID == oid && other.Number >= rev.Number {
+				rev.Number = other.Number + 1
+			}
+		}
+		st.revisions[rev.ID] = cloneRevision(rev)
+		return nil
+	})
+}
+
+func (r *memoryRevisions) List(ctx context.Context, blogID string, limit int) ([]*Revision, error) {
+	tenantID, err := tenantOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	oid, err := parseID(blogID)
+	if err != nil {
+		return nil, err
+	}
+
+	var revs []*Revision
+	r.read(func(st *memoryState) error {
+		for _, rev := range st.revisions {
+			if rev.TenantID == tenantID && rev.BlogID == oid {
+				revs = append(revs, cloneRevision(rev))
+			}
+		}
+		return nil
+	})
+	sort.Slice(revs, func(i, j int) bool {
+		return revs[i].Number > revs[j].Number
+	})
+	if limit > 0 && len(revs) > limit {
+		revs = revs[:limit]
+	}
+	return revs, nil
+}
+
+func (r *memoryRevisions) DeleteForBlog(ctx context.Context, blogID string) error {
+	tenantID, err := tenantOf(ctx)
+	if err != nil {
+		return err
+	}
+	oid, err := parseID(blogID)
+	if err != nil {
+		return err
+	}
+
+	return r.write(func(st *memoryState) error {
+		for id, rev := range st.revisions {
+			if rev.TenantID == tenantID && rev.BlogID == oid {
+				delete(st.revisions, id)
+			}
+		}
+		return nil
+	})
+}
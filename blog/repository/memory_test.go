@@ -0,0 +1,170 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"learn-grpc/blog/tenant"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMemoryTransaction(t *testing.T) {
+	ctx := tenant.NewContext(context.Background(), "acme")
+	rollback := errors.New("rollback")
+
+	tests := []struct {
+		name string
+		fn   func(ctx context.Context, tx Tx) error
+		// posts and entries are in the live data after the transaction
+		posts   int
+		entries int
+	}{
+		{
+			name: "committed",
+			fn: func(ctx context.Context, tx Tx) error {
+				if err := tx.Blogs().Create(ctx, &BlogItem{Title: "hello", CreatedAt: time.Now()}); err != nil {
+					return err
+				}
+				return tx.Audit().Append(ctx, &AuditEntry{TenantID: "acme", Method: "create"})
+			},
+			posts:   2,
+			entries: 4,
+		},
+		{
+			name: "rolled back",
+			fn: func(ctx context.Context, tx Tx) error {
+				if err := tx.Blogs().Create(ctx, &BlogItem{Title: "hello", CreatedAt: time.Now()}); err != nil {
+					return err
+				}
+				if err := tx.Audit().Append(ctx, &AuditEntry{TenantID: "acme", Method: "create"}); err != nil {
+					return err
+				}
+				return rollback
+			},
+			posts:   1,
+			entries: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryStore(Options{})
+			if err := store.Blogs().Create(ctx, &BlogItem{Title: "first", CreatedAt: time.Now()}); err != nil {
+				t.Fatal(err)
+			}
+			// three entries leave room in the array of the chain, so the
+			// transaction appends to the array shared with the live chain
+			for i := 0; i < 3; i++ {
+				if err := store.Audit().Append(ctx, &AuditEntry{TenantID: "acme", Method: "first"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := store.WithTransaction(ctx, tt.fn); err != nil && err != rollback {
+				t.Fatal(err)
+			}
+			if got := len(store.state.blogs); got != tt.posts {
+				t.Errorf("%d posts, want %d", got, tt.posts)
+			}
+			if got := len(store.state.audit["acme"]); got != tt.entries {
+				t.Errorf("%d audit entries, want %d", got, tt.entries)
+			}
+
+			// the chain goes on from the live head, not from a rolled back entry
+			if err := store.Audit().Append(ctx, &AuditEntry{TenantID: "acme", Method: "last"}); err != nil {
+				t.Fatal(err)
+			}
+			head, err := store.Audit().Head(ctx, "acme")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var prev *AuditEntry
+			for _, entry := range store.state.audit["acme"] {
+				if prev != nil && (entry.Seq != prev.Seq+1 || entry.PrevHash != prev.Hash) {
+					t.Errorf("entry %d does not follow entry %d", entry.Seq, prev.Seq)
+				}
+				prev = entry
+			}
+			if prev.Seq != head.Seq || prev.Hash != head.Hash || prev.Method != "last" {
+				t.Errorf("head is %d, last entry %d %s", head.Seq, prev.Seq, prev.Method)
+			}
+		})
+	}
+}
+
+func TestMemoryTransactionCopiesWrittenCollections(t *testing.T) {
+	ctx := tenant.NewContext(context.Background(), "acme")
+	store := NewMemoryStore(Options{})
+	live := *store.state
+
+	err := store.WithTransaction(ctx, func(ctx context.Context, tx Tx) error {
+		if _, err := tx.Webhooks().List(ctx); err != nil {
+			return err
+		}
+		return tx.Outbox().Add(ctx, &OutboxEvent{Type: EventBlogCreated, CreatedAt: time.Now()})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	same := func(a, b interface{}) bool {
+		return reflect.ValueOf(a).Pointer() == reflect.ValueOf(b).Pointer()
+	}
+	if same(store.state.outbox, live.outbox) {
+		t.Error("the outbox was written in place")
+	}
+	if !same(store.state.blogs, live.blogs) || !same(store.state.webhooks, live.webhooks) || !same(store.state.deliveries, live.deliveries) {
+		t.Error("collections the transaction did not write were copied")
+	}
+}
+
+func TestMemoryPruning(t *testing.T) {
+	ctx := tenant.NewContext(context.Background(), "acme")
+	store := NewMemoryStore(Options{})
+	old := time.Now().Add(-time.Hour)
+
+	events := []*OutboxEvent{
+		{Type: EventBlogCreated, CreatedAt: old, Dispatched: true},
+		{Type: EventBlogCreated, CreatedAt: old},
+		{Type: EventBlogCreated, CreatedAt: time.Now(), Dispatched: true},
+	}
+	deliveries := []*Delivery{
+		{Status: DeliveryDelivered, UpdatedAt: old},
+		{Status: DeliveryDead, UpdatedAt: old},
+		{Status: DeliveryPending, UpdatedAt: old},
+		{Status: DeliveryDelivered, UpdatedAt: time.Now()},
+	}
+	err := store.WithTransaction(ctx, func(ctx context.Context, tx Tx) error {
+		for _, event := range events {
+			if err := tx.Outbox().Add(ctx, event); err != nil {
+				return err
+			}
+		}
+		for _, delivery := range deliveries {
+			if err := tx.Deliveries().Create(ctx, delivery); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := time.Now().Add(-time.Minute)
+	if n, err := store.Outbox().DeleteDispatched(ctx, before); err != nil || n != 1 {
+		t.Errorf("DeleteDispatched = %d, %v, want 1", n, err)
+	}
+	if n, err := store.Deliveries().DeleteDelivered(ctx, before); err != nil || n != 1 {
+		t.Errorf("DeleteDelivered = %d, %v, want 1", n, err)
+	}
+	if _, ok := store.state.outbox[events[0].ID]; ok {
+		t.Error("the old dispatched event is kept")
+	}
+	if _, ok := store.state.deliveries[deliveries[0].ID]; ok {
+		t.Error("the old delivered delivery is kept")
+	}
+	if len(store.state.outbox) != 2 || len(store.state.deliveries) != 3 {
+		t.Errorf("kept %d events and %d deliveries, want 2 and 3", len(store.state.outbox), len(store.state.deliveries))
+	}
+}
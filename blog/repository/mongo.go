@@ -0,0 +1,553 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"learn-grpc/blog/tenant"
+	"log"
+	"reflect"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoStore keeps the blog data in mongodb
+type MongoStore struct {
+	client       *mongo.Client
+	blogs        *mongo.Collection
+	outbox       *mongo.Collection
+	webhooks     *mongo.Collection
+	deliveries   *mongo.Collection
+	reactions    *mongo.Collection
+	audit        *mongo.Collection
+	auditHeads   *mongo.Collection
+	revisions    *mongo.Collection
+	transactions bool
+	opts         Options
+}
+
+// NewMongoStore uses coll for blog posts, the other collections are created
+// in the same database. It asks the server whether it supports transactions
+// and moves posts written before tenants existed to opts.DefaultTenant.
+func NewMongoStore(ctx context.Context, coll *mongo.Collection, opts Options) (*MongoStore, error) {
+	db := coll.Database()
+
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if err := db.RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&hello); err != nil {
+		return nil, fmt.Errorf("repository: detect server topology: %w", err)
+	}
+
+	s := &MongoStore{
+		client:     db.Client(),
+		blogs:      coll,
+		outbox:     db.Collection(coll.Name() + "_outbox"),
+		webhooks:   db.Collection(coll.Name() + "_webhooks"),
+		deliveries: db.Collection(coll.Name() + "_deliveries"),
+		reactions:  db.Collection(coll.Name() + "_reactions"),
+		audit:      db.Collection(coll.Name() + "_audit"),
+		auditHeads: db.Collection(coll.Name() + "_audit_heads"),
+		revisions:  db.Collection(coll.Name() + "_revisions"),
+		// replica set members report their set name, mongos answers with isdbgrid
+		transactions: hello.SetName != "" || hello.Msg == "isdbgrid",
+		opts:         opts,
+	}
+	if err := s.ensureIndexes(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.migrate(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// migrate brings documents written by older versions up to date
+func (s *MongoStore) migrate(ctx context.Context) error {
+	if s.opts.DefaultTenant != "" {
+		res, err := s.blogs.UpdateMany(ctx,
+			bson.M{"tenant_id": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"tenant_id": s.opts.DefaultTenant}},
+		)
+		if err != nil {
+			return fmt.Errorf("repository: assign default tenant: %w", err)
+		}
+		if res.ModifiedCount > 0 {
+			log.Printf("repository: moved %d posts to tenant %s", res.ModifiedCount, s.opts.DefaultTenant)
+		}
+
+		// webhooks registered before they had a tenant only get the events
+		// of the default tenant
+		res, err = s.webhooks.UpdateMany(ctx,
+			bson.M{"tenant_id": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"tenant_id": s.opts.DefaultTenant}},
+		)
+		if err != nil {
+			return fmt.Errorf("repository: assign default tenant to webhooks: %w", err)
+		}
+		if res.ModifiedCount > 0 {
+			log.Printf("repository: moved %d webhooks to tenant %s", res.ModifiedCount, s.opts.DefaultTenant)
+		}
+	}
+
+	// fields added after the first version, computed from the document
+	cur, err := s.blogs.Find(ctx, bson.M{"$or": bson.A{
+		bson.M{"size_bytes": bson.M{"$exists": false}},
+		bson.M{"word_count": bson.M{"$exists": false}},
+		bson.M{"contributors": bson.M{"$exists": false}, "author_id": bson.M{"$nin": bson.A{"", nil}}},
+		bson.M{"status": bson.M{"$exists": false}},
+		bson.M{"created_at": bson.M{"$exists": false}},
+	}})
+	if err != nil {
+		return fmt.Errorf("repository: find posts to migrate: %w", err)
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		item := new(BlogItem)
+		if err := cur.Decode(item); err != nil {
+			return err
+		}
+		missingTimes := item.CreatedAt.IsZero()
+		if missingTimes {
+			item.CreatedAt = item.ID.Timestamp()
+		}
+		item.derive()
+		set := bson.M{"size_bytes": item.SizeBytes, "word_count": item.WordCount}
+		if len(item.Contributors) > 0 {
+			// the author becomes the owner
+			set["contributors"] = item.Contributors
+		}
+		if item.Status == "" {
+			// every post was public before statuses existed
+			set["status"] = StatusPublished
+		}
+		if missingTimes {
+			set["created_at"] = item.ID.Timestamp()
+			set["updated_at"] = item.ID.Timestamp()
+		}
+		if _, err := s.blogs.UpdateOne(ctx, bson.M{"_id": item.ID}, bson.M{"$set": set}); err != nil {
+			return fmt.Errorf("repository: migrate %s: %w", item.ID.Hex(), err)
+		}
+	}
+	return cur.Err()
+}
+
+// ensureIndexes creates the indexes used by the queries of the store, creating
+// an index that already exists is a no-op. It also creates the collections,
+// mongodb before 4.4 can not create them inside a transaction.
+func (s *MongoStore) ensureIndexes(ctx context.Context) error {
+	indexes := map[*mongo.Collection][]mongo.IndexModel{
+		s.blogs: {
+			{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "_id", Value: 1}}},
+			{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "status", Value: 1}, {Key: "created_at", Value: -1}}},
+		},
+		s.outbox: {
+			{Keys: bson.D{{Key: "dispatched", Value: 1}, {Key: "_id", Value: 1}}},
+		},
+		s.webhooks: {
+			{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "_id", Value: 1}}},
+		},
+		s.deliveries: {
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "updated_at", Value: 1}}},
+		},
+		s.reactions: {
+			{
+				Keys: bson.D{
+					{Key: "tenant_id", Value: 1},
+					{Key: "blog_id", Value: 1},
+					{Key: "user_id", Value: 1},
+					{Key: "type", Value: 1},
+				},
+				Options: options.Index().SetUnique(true),
+			},
+		},
+		s.audit: {
+			{
+				Keys:    bson.D{{Key: "tenant_id", Value: 1}, {Key: "seq", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "time", Value: 1}}},
+		},
+		s.revisions: {
+			{
+				Keys: bson.D{
+					{Key: "tenant_id", Value: 1},
+					{Key: "blog_id", Value: 1},
+					{Key: "number", Value: -1},
+				},
+				Options: options.Index().SetUnique(true),
+			},
+		},
+	}
+	for coll, models := range indexes {
+		if _, err := coll.Indexes().CreateMany(ctx, models); err != nil {
+			return fmt.Errorf("repository: create indexes on %s: %w", coll.Name(), err)
+		}
+	}
+
+	// collections with only the _id index
+	for _, coll := range []*mongo.Collection{s.auditHeads} {
+		err := coll.Database().CreateCollection(ctx, coll.Name())
+		var cmdErr mongo.CommandError
+		if errors.As(err, &cmdErr) && cmdErr.Name == "NamespaceExists" {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("repository: create collection %s: %w", coll.Name(), err)
+		}
+	}
+	return nil
+}
+
+// SupportsTransactions reports whether WithTransaction uses a real transaction
+func (s *MongoStore) SupportsTransactions() bool {
+	return s.transactions
+}
+
+func (s *MongoStore) Blogs() BlogRepository {
+	return &mongoBlogs{coll: s.blogs, quotas: s.opts.Quotas}
+}
+
+func (s *MongoStore) Outbox() OutboxRepository {
+	return &mongoOutbox{coll: s.outbox}
+}
+
+func (s *MongoStore) Webhooks() WebhookRepository {
+	return &mongoWebhooks{coll: s.webhooks}
+}
+
+func (s *MongoStore) Deliveries() DeliveryRepository {
+	return &mongoDeliveries{coll: s.deliveries}
+}
+
+func (s *MongoStore) Reactions() ReactionRepository {
+	return &mongoReactions{coll: s.reactions, blogs: s.blogs}
+}
+
+func (s *MongoStore) Audit() AuditRepository {
+	return &mongoAudit{coll: s.audit, heads: s.auditHeads}
+}
+
+func (s *MongoStore) Revisions() RevisionRepository {
+	return &mongoRevisions{coll: s.revisions, blogs: s.blogs}
+}
+
+func (s *MongoStore) WithTransaction(ctx context.Context, fn func(ctx context.Context, tx Tx) error) error {
+	if !s.transactions {
+		// standalone server, every write is applied on its own
+		return fn(ctx, s)
+	}
+
+	session, err := s.client.StartSession()
+	if err != nil {
+		return fmt.Errorf("repository: start session: %w", err)
+	}
+	defer session.EndSession(ctx)
+
+	// operations get the session from the SessionContext, so the same
+	// repositories can be used inside the transaction
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessCtx, s)
+	})
+	return err
+}
+
+func (s *MongoStore) Scan(ctx context.Context, fn func(*BlogItem) error) error {
+	cur, err := s.blogs.Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		item := new(BlogItem)
+		if err := cur.Decode(item); err != nil {
+			return err
+		}
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+type mongoBlogs struct {
+	coll   *mongo.Collection
+	quotas *tenant.Quotas
+}
+
+// usage returns how many posts and bytes a tenant stores
+func (r *mongoBlogs) usage(ctx context.Context, tenantID string) (posts, bytes int64, err error) {
+	cur, err := r.coll.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"tenant_id": tenantID}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   nil,
+			"posts": bson.M{"$sum": 1},
+			"bytes": bson.M{"$sum": "$size_bytes"},
+		}}},
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var res []struct {
+		Posts int64 `bson:"posts"`
+		Bytes int64 `bson:"bytes"`
+	}
+	if err := cur.All(ctx, &res); err != nil {
+		return 0, 0, err
+	}
+	if len(res) == 0 {
+		return 0, 0, nil
+	}
+	return res[0].Posts, res[0].Bytes, nil
+}
+
+func (r *mongoBlogs) Create(ctx context.Context, item *BlogItem) error {
+	tenantID, err := tenantOf(ctx)
+	if err != nil {
+		return err
+	}
+	item.TenantID = tenantID
+	item.derive()
+
+	if r.quotas != nil {
+		posts, bytes, err := r.usage(ctx, tenantID)
+		if err != nil {
+			return err
+		}
+		if err := checkQuota(r.quotas, tenantID, posts, bytes, 1, item.SizeBytes); err != nil {
+			return err
+		}
+	}
+
+	res, err := r.coll.InsertOne(ctx, item)
+	if err != nil {
+		return err
+	}
+	oid, ok := res.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return fmt.Errorf("repository: can not convert %v to oid", res.InsertedID)
+	}
+	item.ID = oid
+	return nil
+}
+
+func (r *mongoBlogs) FindByID(ctx context.Context, id string) (*BlogItem, error) {
+	tenantID, err := tenantOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	oid, err := parseID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	item := new(BlogItem)
+	if err := r.coll.FindOne(ctx, bson.M{"_id": oid, "tenant_id": tenantID}).Decode(item); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return item, nil
+}
+
+func (r *mongoBlogs) Replace(ctx context.Context, item *BlogItem) error {
+	tenantID, err := tenantOf(ctx)
+	if err != nil {
+		return err
+	}
+	item.TenantID = tenantID
+	item.derive()
+	filter := bson.M{"_id": item.ID, "tenant_id": tenantID}
+
+	if r.quotas != nil {
+		var old BlogItem
+		opts := options.FindOne().SetProjection(bson.M{"size_bytes": 1})
+		if err := r.coll.FindOne(ctx, filter, opts).Decode(&old); err != nil {
+			if err == mongo.ErrNoDocuments {
+				return ErrNotFound
+			}
+			return err
+		}
+		posts, bytes, err := r.usage(ctx, tenantID)
+		if err != nil {
+			return err
+		}
+		if err := checkQuota(r.quotas, tenantID, posts, bytes, 0, item.SizeBytes-old.SizeBytes); err != nil {
+			return err
+		}
+	}
+
+	// an update instead of a replace so a counter incremented in the
+	// meantime is kept
+	update, err := replaceUpdate(item, counterFields...)
+	if err != nil {
+		return err
+	}
+	res, err := r.coll.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// replaceUpdate returns the update giving a stored document the fields of
+// doc, except its _id and the fields listed in skip. Fields doc leaves out,
+// the empty omitempty ones, are unset so they are cleared like the others.
+func replaceUpdate(doc interface{}, skip ...string) (bson.M, error) {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	set := bson.M{}
+	if err := bson.Unmarshal(raw, &set); err != nil {
+		return nil, err
+	}
+	delete(set, "_id")
+	for _, field := range skip {
+		delete(set, field)
+	}
+
+	unset := bson.M{}
+	for _, field := range bsonFields(reflect.TypeOf(doc)) {
+		if _, ok := set[field]; !ok && field != "_id" && !contains(skip, field) {
+			unset[field] = ""
+		}
+	}
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	return update, nil
+}
+
+// bsonFields returns the names of the fields a struct is stored with
+func bsonFields(t reflect.Type) []string {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("bson"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *mongoBlogs) Delete(ctx context.Context, id string) error {
+	tenantID, err := tenantOf(ctx)
+	if err != nil {
+		return err
+	}
+	oid, err := parseID(id)
+	if err != nil {
+		return err
+	}
+
+	res, err := r.coll.DeleteOne(ctx, bson.M{"_id": oid, "tenant_id": tenantID})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *mongoBlogs) IncrementViews(ctx context.Context, id string, n int64) error {
+	tenantID, err := tenantOf(ctx)
+	if err != nil {
+		return err
+	}
+	oid, err := parseID(id)
+	if err != nil {
+		return err
+	}
+
+	res, err := r.coll.UpdateOne(ctx,
+		bson.M{"_id": oid, "tenant_id": tenantID},
+		bson.M{"$inc": bson.M{"view_count": n}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *mongoBlogs) List(ctx context.Context, filter ListFilter, fn func(*BlogItem) error) error {
+	tenantID, err := tenantOf(ctx)
+	if err != nil {
+		return err
+	}
+
+	query := bson.M{"tenant_id": tenantID}
+	if filter.AuthorID != "" {
+		query["author_id"] = filter.AuthorID
+	}
+	if filter.Tag != "" {
+		query["tags"] = filter.Tag
+	}
+	if filter.Status != "" {
+		query["status"] = filter.Status
+	}
+	opts := options.Find()
+	if filter.NewestFirst {
+		opts.SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}})
+	}
+	if filter.Limit > 0 {
+		opts.SetLimit(int64(filter.Limit))
+	}
+	if filter.BatchSize > 0 {
+		opts.SetBatchSize(int32(filter.BatchSize))
+	}
+
+	cur, err := r.coll.Find(ctx, query, opts)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		item := new(BlogItem)
+		if err := cur.Decode(item); err != nil {
+			return err
+		}
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoAudit struct {
+	coll  *mongo.Collection
+	heads *mongo.Collection
+}
+
+// Append relies on the unique index on tenant_id and seq, when two servers
+// append at the same time only one insert wins and the other gets
+// ErrAuditConflict. In a transaction the failed insert aborts the
+// transaction, so the caller retries the whole transaction, not the append.
+// The head is moved after the insert, a head left behind by a crash in
+// between is moved by the next append.
+func (r *mongoAudit) Append(ctx context.Context, entry *AuditEntry) error {
+	// the last entry, not the head, so a head left behind does not stop
+	// the chain
+	opts := options.FindOne().SetSort(bson.D{{Key: "seq", Value: -1}})
+	last := new(AuditEntry)
+	err := r.coll.FindOne(ctx, bson.M{"tenant_id": entry.TenantID}, opts).Decode(last)
+	if err == mongo.ErrNoDocuments {
+		last = nil
+	} else if err != nil {
+		return err
+	}
+
+	entry.ID = primitive.NewObjectID()
+	entry.chain(last)
+	_, err = r.coll.InsertOne(ctx, entry)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrAuditConflict
+	}
+	if err != nil {
+		return err
+	}
+
+	// a head already further along makes the upsert insert a second head
+	// with the same id, which fails and is fine
+	_, err = r.heads.UpdateOne(ctx,
+		bson.M{"_id": entry.TenantID, "seq": bson.M{"$lt": entry.Seq}},
+		bson.M{"$set": bson.M{"seq": entry.Seq, "hash": entry.Hash}},
+		options.Update().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+func (r *mongoAudit) Head(ctx context.Context, tenantID string) (*AuditHead, error) {
+	head := new(AuditHead)
+	err := r.heads.FindOne(ctx, bson.M{"_id": tenantID}).Decode(head)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return head, nil
+}
+
+func (r *mongoAudit) List(ctx context.Context, filter AuditFilter, fn func(*AuditEntry) error) error {
+	query := bson.M{"tenant_id": filter.TenantID}
+	if filter.Actor != "" {
+		query["actor"] = filter.Actor
+	}
+	timeRange := bson.M{}
+	if !filter.Since.IsZero() {
+		timeRange["$gte"] = filter.Since
+	}
+	if !filter.Until.IsZero() {
+		timeRange["$lt"] = filter.Until
+	}
+	if len(timeRange) > 0 {
+		query["time"] = timeRange
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "seq", Value: 1}})
+	if filter.Limit > 0 {
+		opts.SetLimit(int64(filter.Limit))
+	}
+	cur, err := r.coll.Find(ctx, query, opts)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		entry := new(AuditEntry)
+		if err := cur.Decode(entry); err != nil {
+			return err
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (s *MongoStore) ScanReactions(ctx context.Context, fn func(*Reaction) error) error {
+	cur, err := s.reactions.Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		reaction := new(Reaction)
+		if err := cur.Decode(reaction); err != nil {
+			return err
+		}
+		if err := fn(reaction); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+func (s *MongoStore) ScanRevisions(ctx context.Context, fn func(*Revision) error) error {
+	cur, err := s.revisions.Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		rev := new(Revision)
+		if err := cur.Decode(rev); err != nil {
+			return err
+		}
+		if err := fn(rev); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+func (s *MongoStore) RestoreBlog(ctx context.Context, item *BlogItem, reactions []*Reaction, revisions []*Revision) error {
+	item.derive()
+	return s.WithTransaction(ctx, func(ctx context.Context, tx Tx) error {
+		// the filter has the tenant, so a post of another tenant with the
+		// same id makes the upsert insert a duplicate id
+		_, err := s.blogs.ReplaceOne(ctx,
+			bson.M{"_id": item.ID, "tenant_id": item.TenantID},
+			item,
+			options.Replace().SetUpsert(true),
+		)
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrIDTaken
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := replaceAll(ctx, s.reactions, item, reactions); err != nil {
+			return err
+		}
+		return replaceAll(ctx, s.revisions, item, revisions)
+	})
+}
+
+// replaceAll replaces the documents of a post in coll with docs, docs is a
+// slice of pointers
+func replaceAll(ctx context.Context, coll *mongo.Collection, item *BlogItem, docs interface{}) error {
+	if _, err := coll.DeleteMany(ctx, bson.M{"blog_id": item.ID}); err != nil {
+		return err
+	}
+
+	var insert []interface{}
+	switch docs := docs.(type) {
+	case []*Reaction:
+		for _, doc := range docs {
+			insert = append(insert, doc)
+		}
+	case []*Revision:
+		for _, doc := range docs {
+			insert = append(insert, doc)
+		}
+	}
+	if len(insert) == 0 {
+		return nil
+	}
+	_, err := coll.InsertMany(ctx, insert)
+	return err
+}
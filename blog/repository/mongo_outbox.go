@@ -0,0 +1,174 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoOutbox struct {
+	coll *mongo.Collection
+}
+
+func (r *mongoOutbox) Add(ctx context.Context, event *OutboxEvent) error {
+	tenantID, err := tenantOf(ctx)
+	if err != nil {
+		return err
+	}
+	event.TenantID = tenantID
+	if event.ID.IsZero() {
+		event.ID = primitive.NewObjectID()
+	}
+	_, err = r.coll.InsertOne(ctx, event)
+	return err
+}
+
+func (r *mongoOutbox) ListPending(ctx context.Context, limit int) ([]*OutboxEvent, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(limit))
+	cur, err := r.coll.Find(ctx, bson.M{"dispatched": false}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []*OutboxEvent
+	if err := cur.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (r *mongoOutbox) MarkDispatched(ctx context.Context, id primitive.ObjectID) error {
+	res, err := r.coll.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"dispatched": true}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *mongoOutbox) DeleteDispatched(ctx context.Context, before time.Time) (int64, error) {
+	res, err := r.coll.DeleteMany(ctx, bson.M{"dispatched": true, "created_at": bson.M{"$lt": before}})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+type mongoWebhooks struct {
+	coll *mongo.Collection
+}
+
+func (r *mongoWebhooks) Create(ctx context.Context, hook *Webhook) error {
+	tenantID, err := tenantOf(ctx)
+	if err != nil {
+		return err
+	}
+	hook.TenantID = tenantID
+	if hook.ID.IsZero() {
+		hook.ID = primitive.NewObjectID()
+	}
+	_, err = r.coll.InsertOne(ctx, hook)
+	return err
+}
+
+func (r *mongoWebhooks) List(ctx context.Context) ([]*Webhook, error) {
+	tenantID, err := tenantOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.find(ctx, bson.M{"tenant_id": tenantID})
+}
+
+func (r *mongoWebhooks) ListAll(ctx context.Context) ([]*Webhook, error) {
+	return r.find(ctx, bson.M{})
+}
+
+func (r *mongoWebhooks) find(ctx context.Context, filter bson.M) ([]*Webhook, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cur, err := r.coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var hooks []*Webhook
+	if err := cur.All(ctx, &hooks); err != nil {
+		return nil, err
+	}
+	return hooks, nil
+}
+
+func (r *mongoWebhooks) Delete(ctx context.Context, id string) error {
+	tenantID, err := tenantOf(ctx)
+	if err != nil {
+		return err
+	}
+	oid, err := parseID(id)
+	if err != nil {
+		return err
+	}
+
+	res, err := r.coll.DeleteOne(ctx, bson.M{"_id": oid, "tenant_id": tenantID})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type mongoDeliveries struct {
+	coll *mongo.Collection
+}
+
+func (r *mongoDeliveries) Create(ctx context.Context, delivery *Delivery) error {
+	if delivery.ID.IsZero() {
+		delivery.ID = primitive.NewObjectID()
+	}
+	_, err := r.coll.InsertOne(ctx, delivery)
+	return err
+}
+
+func (r *mongoDeliveries) ListDue(ctx context.Context, now time.Time, limit int) ([]*Delivery, error) {
+	filter := bson.M{
+		"status":          DeliveryPending,
+		"next_attempt_at": bson.M{"$lte": now},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).SetLimit(int64(limit))
+	cur, err := r.coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var deliveries []*Delivery
+	if err := cur.All(ctx, &deliveries); err != nil {
+		return nil, fmt.Errorf("repository: decode deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+func (r *mongoDeliveries) Update(ctx context.Context, delivery *Delivery) error {
+	res, err := r.coll.ReplaceOne(ctx, bson.M{"_id": delivery.ID}, delivery)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *mongoDeliveries) DeleteDelivered(ctx context.Context, before time.Time) (int64, error) {
+	res, err := r.coll.DeleteMany(ctx, bson.M{"status": DeliveryDelivered, "updated_at": bson.M{"$lt": before}})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
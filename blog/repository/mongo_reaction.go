@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoReactions struct {
+	coll  *mongo.Collection
+	blogs *mongo.Collection
+}
+
+// reactionFilter identifies the reaction of one user, after checking that
+// the post exists in the tenant
+func (r *mongoReactions) reactionFilter(ctx context.Context, blogID, userID, reactionType string) (bson.M, error) {
+	tenantID, err := tenantOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	oid, err := parseID(blogID)
+	if err != nil {
+		return nil, err
+	}
+	if !ValidReaction(reactionType) {
+		return nil, ErrInvalidReaction
+	}
+
+	opts := options.FindOne().SetProjection(bson.M{"_id": 1})
+	if err := r.blogs.FindOne(ctx, bson.M{"_id": oid, "tenant_id": tenantID}, opts).Err(); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return bson.M{"tenant_id": tenantID, "blog_id": oid, "user_id": userID, "type": reactionType}, nil
+}
+
+func (r *mongoReactions) count(ctx context.Context, filter bson.M, reactionType string, n int64) error {
+	_, err := r.blogs.UpdateOne(ctx,
+		bson.M{"_id": filter["blog_id"], "tenant_id": filter["tenant_id"]},
+		bson.M{"$inc": bson.M{"reactions." + reactionType: n}},
+	)
+	return err
+}
+
+func (r *mongoReactions) Add(ctx context.Context, blogID, userID, reactionType string) (bool, error) {
+	filter, err := r.reactionFilter(ctx, blogID, userID, reactionType)
+	if err != nil {
+		return false, err
+	}
+
+	// an upsert instead of relying on the unique index, a duplicate key error
+	// would abort the surrounding transaction
+	res, err := r.coll.UpdateOne(ctx, filter,
+		bson.M{"$setOnInsert": bson.M{"_id": primitive.NewObjectID(), "created_at": time.Now()}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return false, err
+	}
+	if res.UpsertedCount == 0 {
+		return false, nil
+	}
+	return true, r.count(ctx, filter, reactionType, 1)
+}
+
+func (r *mongoReactions) Remove(ctx context.Context, blogID, userID, reactionType string) (bool, error) {
+	filter, err := r.reactionFilter(ctx, blogID, userID, reactionType)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := r.coll.DeleteOne(ctx, filter)
+	if err != nil {
+		return false, err
+	}
+	if res.DeletedCount == 0 {
+		return false, nil
+	}
+	return true, r.count(ctx, filter, reactionType, -1)
+}
+
+func (r *mongoReactions) DeleteForBlog(ctx context.Context, blogID string) error {
+	tenantID, err := tenantOf(ctx)
+	if err != nil {
+		return err
+	}
+	oid, err := parseID(blogID)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.coll.DeleteMany(ctx, bson.M{"tenant_id": tenantID, "blog_id": oid})
+	return err
+}
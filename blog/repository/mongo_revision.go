@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoRevisions struct {
+	coll  *mongo.Collection
+	blogs *mongo.Collection
+}
+
+// Add takes the next number from the last revision, the unique index on
+// tenant_id, blog_id and number makes a concurrent Add of the same post fail
+func (r *mongoRevisions) Add(ctx context.Context, blogID string, rev *Revision) error {
+	tenantID, err := tenantOf(ctx)
+	if err != nil {
+		return err
+	}
+	oid, err := parseID(blogID)
+	if err != nil {
+		return err
+	}
+
+	opts := options.FindOne().SetProjection(bson.M{"_id": 1})
+	if err := r.blogs.FindOne(ctx, bson.M{"_id": oid, "tenant_id": tenantID}, opts).Err(); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	last := new(Revision)
+	opts = options.FindOne().SetSort(bson.D{{Key: "number", Value: -1}}).SetProjection(bson.M{"number": 1})
+	err = r.coll.FindOne(ctx, bson.M{"tenant_id": tenantID, "blog_id": oid}, opts).Decode(last)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+
+	rev.ID = primitive.NewObjectID()
+	rev.TenantID = tenantID
+	rev.BlogID = oid
+	rev.Number = last.Number + 1
+	_, err = r.coll.InsertOne(ctx, rev)
+	return err
+}
+
+func (r *mongoRevisions) List(ctx context.Context, blogID string, limit int) ([]*Revision, error) {
+	tenantID, err := tenantOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	oid, err := parseID(blogID)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "number", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cur, err := r.coll.Find(ctx, bson.M{"tenant_id": tenantID, "blog_id": oid}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var revs []*Revision
+	if err := cur.All(ctx, &revs); err != nil {
+		return nil, err
+	}
+	return revs, nil
+}
+
+func (r *mongoRevisions) DeleteForBlog(ctx context.Context, blogID string) error {
+	tenantID, err := tenantOf(ctx)
+	if err != nil {
+		return err
+	}
+	oid, err := parseID(blogID)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.coll.DeleteMany(ctx, bson.M{"tenant_id": tenantID, "blog_id": oid})
+	return err
+}
@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Stats computes every total in one aggregation, each $facet groups the
+// same matched posts by a different key
+func (r *mongoBlogs) Stats(ctx context.Context, filter StatsFilter) (*BlogStats, error) {
+	tenantID, err := tenantOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cur, err := r.coll.Aggregate(ctx, statsPipeline(tenantID, filter))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var facets []statsFacets
+	if err := cur.All(ctx, &facets); err != nil {
+		return nil, err
+	}
+	if len(facets) == 0 {
+		return &BlogStats{}, nil
+	}
+	return facets[0].stats(), nil
+}
+
+func statsPipeline(tenantID string, filter StatsFilter) bson.A {
+	match := bson.M{"tenant_id": tenantID}
+	if filter.Status != "" {
+		match["status"] = filter.Status
+	}
+	created := bson.M{}
+	if !filter.Since.IsZero() {
+		created["$gte"] = filter.Since
+	}
+	if !filter.Until.IsZero() {
+		created["$lt"] = filter.Until
+	}
+	if len(created) > 0 {
+		match["created_at"] = created
+	}
+
+	group := func(key interface{}) bson.M {
+		return bson.M{"$group": bson.M{
+			"_id":   key,
+			"posts": bson.M{"$sum": 1},
+			"words": bson.M{"$sum": "$word_count"},
+		}}
+	}
+	busiest := bson.A{bson.M{"$sort": bson.D{{Key: "posts", Value: -1}, {Key: "_id", Value: 1}}}}
+	if filter.Limit > 0 {
+		busiest = append(busiest, bson.M{"$limit": filter.Limit})
+	}
+
+	return bson.A{
+		bson.M{"$match": match},
+		bson.M{"$facet": bson.M{
+			"total":   bson.A{group("")},
+			"authors": append(bson.A{group("$author_id")}, busiest...),
+			"tags":    append(bson.A{bson.M{"$unwind": "$tags"}, group("$tags")}, busiest...),
+			"months": bson.A{
+				group(bson.M{"$dateToString": bson.M{"format": "%Y-%m", "date": "$created_at"}}),
+				bson.M{"$sort": bson.M{"_id": 1}},
+			},
+		}},
+	}
+}
+
+// statsFacets is the document returned by the stats pipeline
+type statsFacets struct {
+	Total   []StatsBucket `bson:"total"`
+	Authors []StatsBucket `bson:"authors"`
+	Tags    []StatsBucket `bson:"tags"`
+	Months  []StatsBucket `bson:"months"`
+}
+
+func (f statsFacets) stats() *BlogStats {
+	stats := &BlogStats{Authors: f.Authors, Tags: f.Tags, Months: f.Months}
+	if len(f.Total) > 0 {
+		stats.Total = f.Total[0]
+		stats.Total.Key = ""
+	}
+	return stats
+}
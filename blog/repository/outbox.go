@@ -0,0 +1,119 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// event types written to the outbox
+const (
+	EventBlogCreated = "blog.created"
+	EventBlogUpdated = "blog.updated"
+	EventBlogDeleted = "blog.deleted"
+)
+
+// EventTypes lists every event a webhook can subscribe to
+var EventTypes = []string{EventBlogCreated, EventBlogUpdated, EventBlogDeleted}
+
+// delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	// DeliveryDead is the dead-letter state, the delivery ran out of attempts
+	DeliveryDead = "dead"
+)
+
+// OutboxEvent is written in the same transaction as the change it describes,
+// the dispatcher turns it into one delivery per subscribed webhook
+type OutboxEvent struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty"`
+	TenantID string             `bson:"tenant_id"`
+	Type     string             `bson:"type"`
+	BlogID   string             `bson:"blog_id"`
+	// Blog is the post after the change, nil for deletes
+	Blog       *BlogItem `bson:"blog,omitempty"`
+	CreatedAt  time.Time `bson:"created_at"`
+	Dispatched bool      `bson:"dispatched"`
+}
+
+// Webhook is an url that receives the blog events of its tenant
+type Webhook struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty"`
+	TenantID string             `bson:"tenant_id"`
+	URL      string             `bson:"url"`
+	Secret   string             `bson:"secret"`
+	// Events the webhook subscribed to, empty means every event
+	Events    []string  `bson:"events,omitempty"`
+	CreatedAt time.Time `bson:"created_at"`
+}
+
+// Wants reports whether the webhook gets event, the events of other
+// tenants are never sent to it
+func (w *Webhook) Wants(event *OutboxEvent) bool {
+	if event.TenantID != w.TenantID {
+		return false
+	}
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == event.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery is one event sent to one webhook
+type Delivery struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	EventID   primitive.ObjectID `bson:"event_id"`
+	EventType string             `bson:"event_type"`
+	WebhookID primitive.ObjectID `bson:"webhook_id"`
+	// Payload is the request body, fixed when the delivery is created so
+	// every attempt sends the same bytes
+	Payload       []byte    `bson:"payload"`
+	Status        string    `bson:"status"`
+	Attempts      int       `bson:"attempts"`
+	NextAttemptAt time.Time `bson:"next_attempt_at"`
+	LastError     string    `bson:"last_error,omitempty"`
+	CreatedAt     time.Time `bson:"created_at"`
+	UpdatedAt     time.Time `bson:"updated_at"`
+}
+
+// OutboxRepository stores events waiting to be dispatched, events of every
+// tenant are dispatched by the same dispatcher
+type OutboxRepository interface {
+	// Add stores the event for the tenant in the context
+	Add(ctx context.Context, event *OutboxEvent) error
+	// ListPending returns the oldest events that are not dispatched yet
+	ListPending(ctx context.Context, limit int) ([]*OutboxEvent, error)
+	MarkDispatched(ctx context.Context, id primitive.ObjectID) error
+	// DeleteDispatched removes the dispatched events created before, it
+	// returns how many it removed
+	DeleteDispatched(ctx context.Context, before time.Time) (int64, error)
+}
+
+// WebhookRepository stores the registered webhooks of the tenant in the
+// context
+type WebhookRepository interface {
+	// Create stores the webhook for the tenant in the context
+	Create(ctx context.Context, hook *Webhook) error
+	List(ctx context.Context) ([]*Webhook, error)
+	Delete(ctx context.Context, id string) error
+	// ListAll returns the webhooks of every tenant, for the dispatcher
+	ListAll(ctx context.Context) ([]*Webhook, error)
+}
+
+// DeliveryRepository stores the state of every webhook delivery
+type DeliveryRepository interface {
+	Create(ctx context.Context, delivery *Delivery) error
+	// ListDue returns pending deliveries whose next attempt is before now
+	ListDue(ctx context.Context, now time.Time, limit int) ([]*Delivery, error)
+	Update(ctx context.Context, delivery *Delivery) error
+	// DeleteDelivered removes the delivered deliveries last updated before,
+	// dead ones are kept. It returns how many it removed.
+	DeleteDelivered(ctx context.Context, before time.Time) (int64, error)
+}
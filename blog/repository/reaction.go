@@ -0,0 +1,46 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrInvalidReaction is returned for reaction names that can not be stored
+var ErrInvalidReaction = errors.New("repository: invalid reaction")
+
+// Reaction is one reaction of one user on a post, a user can give every
+// reaction type once per post
+type Reaction struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	TenantID  string             `bson:"tenant_id"`
+	BlogID    primitive.ObjectID `bson:"blog_id"`
+	UserID    string             `bson:"user_id"`
+	Type      string             `bson:"type"`
+	CreatedAt time.Time          `bson:"created_at"`
+}
+
+// ReactionRepository stores the reactions of the tenant in the context and
+// keeps the aggregated counts on the post up to date, call it inside a
+// transaction so both writes are applied together
+type ReactionRepository interface {
+	// Add returns false when the user already gave this reaction
+	Add(ctx context.Context, blogID, userID, reactionType string) (bool, error)
+	// Remove returns false when the user did not give this reaction
+	Remove(ctx context.Context, blogID, userID, reactionType string) (bool, error)
+	// DeleteForBlog removes every reaction of a post
+	DeleteForBlog(ctx context.Context, blogID string) error
+}
+
+// ValidReaction reports whether a reaction type can be stored, it is used as a
+// field name so it can not contain "." or "$"
+func ValidReaction(reactionType string) bool {
+	if reactionType == "" || len(reactionType) > 32 || !utf8.ValidString(reactionType) {
+		return false
+	}
+	return !strings.ContainsAny(reactionType, ".$ \t\n")
+}
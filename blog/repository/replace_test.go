@@ -0,0 +1,159 @@
+package repository
+
+import (
+	"context"
+	"learn-grpc/blog/tenant"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// applyUpdate does to doc what mongodb does for the $set and $unset of update
+func applyUpdate(t *testing.T, doc bson.M, update bson.M) {
+	t.Helper()
+	for op, fields := range update {
+		switch op {
+		case "$set":
+			for name, value := range fields.(bson.M) {
+				doc[name] = value
+			}
+		case "$unset":
+			for name := range fields.(bson.M) {
+				delete(doc, name)
+			}
+		default:
+			t.Fatalf("unexpected update operator %s", op)
+		}
+	}
+}
+
+// mongoReplace returns stored after MongoStore.Replace with item
+func mongoReplace(t *testing.T, stored, item *BlogItem) *BlogItem {
+	t.Helper()
+	raw, err := bson.Marshal(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := bson.M{}
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	item.derive()
+	update, err := replaceUpdate(item, counterFields...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applyUpdate(t, doc, update)
+
+	raw, err = bson.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replaced := new(BlogItem)
+	if err := bson.Unmarshal(raw, replaced); err != nil {
+		t.Fatal(err)
+	}
+	return replaced
+}
+
+// memoryReplace returns stored after MemoryStore.Replace with item
+func memoryReplace(t *testing.T, stored, item *BlogItem) *BlogItem {
+	t.Helper()
+	ctx := tenant.NewContext(context.Background(), "acme")
+	store := NewMemoryStore(Options{})
+	created := cloneBlog(stored)
+	if err := store.Blogs().Create(ctx, created); err != nil {
+		t.Fatal(err)
+	}
+	// Create starts the counters at zero
+	store.state.blogs[created.ID].Reactions = stored.Reactions
+	store.state.blogs[created.ID].ViewCount = stored.ViewCount
+
+	item.ID = created.ID
+	if err := store.Blogs().Replace(ctx, item); err != nil {
+		t.Fatal(err)
+	}
+	replaced, err := store.Blogs().FindByID(ctx, created.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return replaced
+}
+
+// normalize makes empty and missing values equal, mongodb decodes a
+// missing field as nil
+func normalize(item *BlogItem) *BlogItem {
+	c := cloneBlog(item)
+	if len(c.Tags) == 0 {
+		c.Tags = nil
+	}
+	if len(c.Contributors) == 0 {
+		c.Contributors = nil
+	}
+	if len(c.Translations) == 0 {
+		c.Translations = nil
+	}
+	if len(c.Reactions) == 0 {
+		c.Reactions = nil
+	}
+	return c
+}
+
+func TestReplaceParity(t *testing.T) {
+	// mongodb keeps milliseconds in UTC
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	stored := func() *BlogItem {
+		return &BlogItem{
+			TenantID:     "acme",
+			AuthorID:     "fajar",
+			Title:        "hello",
+			Content:      "hello world",
+			Tags:         []string{"go", "grpc"},
+			Status:       StatusPublished,
+			Contributors: []Contributor{{UserID: "fajar", Role: RoleOwner, AddedAt: now}, {UserID: "sari", Role: RoleEditor, AddedAt: now}},
+			Language:     "en",
+			Translations: map[string]Translation{"id": {Title: "halo", Content: "halo dunia", UpdatedAt: now}},
+			Reactions:    map[string]int64{"like": 3},
+			ViewCount:    42,
+			CreatedAt:    now,
+			UpdatedAt:    now,
+		}
+	}
+
+	tests := []struct {
+		name   string
+		change func(item *BlogItem)
+	}{
+		{name: "unchanged", change: func(item *BlogItem) {}},
+		{name: "title and content", change: func(item *BlogItem) { item.Title, item.Content = "bye", "bye world" }},
+		{name: "tags cleared", change: func(item *BlogItem) { item.Tags = nil }},
+		{name: "tags emptied", change: func(item *BlogItem) { item.Tags = []string{} }},
+		{name: "translations cleared", change: func(item *BlogItem) { item.Translations = nil }},
+		{name: "language cleared", change: func(item *BlogItem) { item.Language = "" }},
+		{name: "contributor removed", change: func(item *BlogItem) { item.Contributors = item.Contributors[:1] }},
+		{name: "counters are kept", change: func(item *BlogItem) { item.Reactions, item.ViewCount = nil, 0 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := stored()
+			tt.change(item)
+			memory := memoryReplace(t, stored(), cloneBlog(item))
+			mongo := mongoReplace(t, stored(), cloneBlog(item))
+			// the memory store gives the post an id when it is created
+			mongo.ID = memory.ID
+
+			if !reflect.DeepEqual(normalize(memory), normalize(mongo)) {
+				t.Errorf("stores differ\nmemory: %+v\nmongo:  %+v", memory, mongo)
+			}
+			if memory.ViewCount != 42 || memory.Reactions["like"] != 3 {
+				t.Errorf("counters = %d views, %v reactions, want them kept", memory.ViewCount, memory.Reactions)
+			}
+			if !reflect.DeepEqual(normalize(memory).Tags, normalize(item).Tags) {
+				t.Errorf("tags = %v, want %v", memory.Tags, item.Tags)
+			}
+		})
+	}
+}
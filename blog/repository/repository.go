@@ -0,0 +1,243 @@
+// Package repository is the persistence layer of the blog service.
+//
+// Every blog post belongs to a tenant, the repositories take the tenant from
+// the context (see package tenant) and never read or write the posts of
+// another tenant. A post of another tenant is reported as ErrNotFound so
+// callers can not learn that it exists.
+//
+// Every write that touches more than one document should go through
+// Store.WithTransaction so it is applied as one unit of work:
+//
+//   - mongodb replica set / sharded cluster: fn runs inside a session with a
+//     multi document transaction, it is committed when fn returns nil and
+//     aborted otherwise. The driver may run fn again on a transient error, so
+//     fn must not have side effects outside the Tx it is given.
+//   - mongodb standalone server: transactions are not supported by the server,
+//     fn runs directly and every write is applied on its own. A failure halfway
+//     leaves the earlier writes in place, so fn should write the main document
+//     last on create and first on delete, to make leftovers easy to find.
+//   - in-memory store: fn runs on a private copy of the data which replaces the
+//     live data only when fn returns nil. Other writers wait until it is done.
+package repository
+
+import (
+	"context"
+	"errors"
+	"learn-grpc/blog/tenant"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	// ErrNotFound is returned when the requested document does not exist
+	ErrNotFound = errors.New("repository: not found")
+	// ErrInvalidID is returned when an id can not be parsed
+	ErrInvalidID = errors.New("repository: invalid id")
+	// ErrQuotaExceeded is returned when a write would go over the tenant quota
+	ErrQuotaExceeded = errors.New("repository: tenant quota exceeded")
+	// ErrIDTaken is returned when a restored post has the id of a post of
+	// another tenant
+	ErrIDTaken = errors.New("repository: id is used by another tenant")
+)
+
+// Options configures a store
+type Options struct {
+	// Quotas limits what every tenant can store, nil means unlimited
+	Quotas *tenant.Quotas
+	// DefaultTenant owns the posts written before tenants existed
+	DefaultTenant string
+}
+
+// post statuses
+const (
+	StatusPublished = "published"
+	StatusDraft     = "draft"
+	// StatusPendingReview posts wait for a moderator, StatusRejected posts
+	// were refused by one, neither is shown to readers
+	StatusPendingReview = "pending_review"
+	StatusRejected      = "rejected"
+)
+
+// BlogItem is a blog post as it is stored in the database
+type BlogItem struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	TenantID  string             `bson:"tenant_id"`
+	AuthorID  string             `bson:"author_id"`
+	Content   string             `bson:"content"`
+	Title     string             `bson:"title" validate:"required"`
+	Tags      []string           `bson:"tags,omitempty"`
+	Status    string             `bson:"status"`
+	CreatedAt time.Time          `bson:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at"`
+	// Contributors decide who may read a draft, edit and delete the post,
+	// AuthorID is only the name shown as the author
+	Contributors []Contributor `bson:"contributors,omitempty"`
+	// ModerationNote explains why the post is held or was rejected
+	ModerationNote string `bson:"moderation_note"`
+	// Language is the BCP-47 tag of Title and Content, empty when unknown
+	Language string `bson:"language,omitempty"`
+	// Translations are keyed by BCP-47 tag in canonical form
+	Translations map[string]Translation `bson:"translations,omitempty"`
+	// SizeBytes is counted against the tenant storage quota
+	SizeBytes int64 `bson:"size_bytes"`
+	// WordCount is the number of words of the content, kept for the stats
+	WordCount int64 `bson:"word_count"`
+
+	// counters are only changed by AddReaction, RemoveReaction and
+	// IncrementViews, Replace keeps the stored values
+	Reactions map[string]int64 `bson:"reactions,omitempty"`
+	ViewCount int64            `bson:"view_count"`
+}
+
+// counterFields are the bson fields Replace must not overwrite
+var counterFields = []string{"reactions", "view_count"}
+
+// Translation is the title and content of a post in another language
+type Translation struct {
+	Title     string    `bson:"title"`
+	Content   string    `bson:"content"`
+	UpdatedAt time.Time `bson:"updated_at"`
+}
+
+// Languages returns the tags of the translations, sorted
+func (b *BlogItem) Languages() []string {
+	tags := make([]string, 0, len(b.Translations))
+	for tag := range b.Translations {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// size returns the number of bytes the post counts against the quota,
+// translations included
+func (b *BlogItem) size() int64 {
+	size := len(b.Title) + len(b.Content)
+	for _, t := range b.Translations {
+		size += len(t.Title) + len(t.Content)
+	}
+	return int64(size)
+}
+
+// derive sets the fields computed from the post, called on every write
+func (b *BlogItem) derive() {
+	b.ensureOwner()
+	b.SizeBytes = b.size()
+	b.WordCount = int64(len(strings.Fields(b.Content)))
+}
+
+// ListFilter selects the posts returned by BlogRepository.List, empty fields
+// do not filter
+type ListFilter struct {
+	AuthorID string
+	Tag      string
+	Status   string
+	// NewestFirst sorts by creation time, otherwise posts come in insertion order
+	NewestFirst bool
+	Limit       int
+	// BatchSize is the number of posts a mongodb cursor fetches per round
+	// trip, 0 uses the driver default
+	BatchSize int
+}
+
+// matches reports whether item passes the filter, used by the memory store
+func (f ListFilter) matches(item *BlogItem) bool {
+	if f.AuthorID != "" && item.AuthorID != f.AuthorID {
+		return false
+	}
+	if f.Status != "" && item.Status != f.Status {
+		return false
+	}
+	if f.Tag == "" {
+		return true
+	}
+	for _, tag := range item.Tags {
+		if tag == f.Tag {
+			return true
+		}
+	}
+	return false
+}
+
+// BlogRepository reads and writes the blog posts of the tenant in the context
+type BlogRepository interface {
+	// Create stores a new post and sets its ID
+	Create(ctx context.Context, item *BlogItem) error
+	FindByID(ctx context.Context, id string) (*BlogItem, error)
+	// Replace overwrites the post with the same ID, except its counters
+	Replace(ctx context.Context, item *BlogItem) error
+	Delete(ctx context.Context, id string) error
+	// List calls fn for every post matching filter, it stops at the first
+	// error returned by fn
+	List(ctx context.Context, filter ListFilter, fn func(*BlogItem) error) error
+	// IncrementViews atomically adds n to the view count of a post
+	IncrementViews(ctx context.Context, id string, n int64) error
+	// Stats counts the posts and words of the posts matching filter
+	Stats(ctx context.Context, filter StatsFilter) (*BlogStats, error)
+}
+
+// Tx gives access to the repositories taking part in a unit of work
+type Tx interface {
+	Blogs() BlogRepository
+	Outbox() OutboxRepository
+	Webhooks() WebhookRepository
+	Deliveries() DeliveryRepository
+	Reactions() ReactionRepository
+	Audit() AuditRepository
+	Revisions() RevisionRepository
+}
+
+// Store is the entry point to the persistence layer, used outside a
+// transaction its repositories write every document on its own
+type Store interface {
+	Tx
+	// WithTransaction runs fn as one unit of work, see the package doc for how
+	// each backend implements it
+	WithTransaction(ctx context.Context, fn func(ctx context.Context, tx Tx) error) error
+	// Scan calls fn for the posts of every tenant. It is meant for background
+	// jobs that maintain data derived from all posts, request handlers must
+	// use Blogs so they stay inside their tenant.
+	Scan(ctx context.Context, fn func(*BlogItem) error) error
+	// ScanReactions and ScanRevisions are Scan for the reactions and the
+	// revisions, they are used by backups
+	ScanReactions(ctx context.Context, fn func(*Reaction) error) error
+	ScanRevisions(ctx context.Context, fn func(*Revision) error) error
+	// RestoreBlog writes a post read from a backup as it is, with its id,
+	// tenant and counters, and replaces its reactions and revisions. A post
+	// with the same id is overwritten, ErrIDTaken is returned when another
+	// tenant has it.
+	RestoreBlog(ctx context.Context, item *BlogItem, reactions []*Reaction, revisions []*Revision) error
+}
+
+// tenantOf returns the tenant every query must be scoped by
+func tenantOf(ctx context.Context) (string, error) {
+	id, ok := tenant.FromContext(ctx)
+	if !ok {
+		return "", tenant.ErrMissing
+	}
+	return id, nil
+}
+
+// checkQuota returns ErrQuotaExceeded when adding newPosts posts and newBytes
+// bytes to the current usage of a tenant goes over its quota. Two writes
+// running at the same time can both pass, so the quota is a soft limit.
+func checkQuota(quotas *tenant.Quotas, tenantID string, posts, bytes, newPosts, newBytes int64) error {
+	if quotas == nil {
+		return nil
+	}
+	if !quotas.For(tenantID).Allows(posts, bytes, newPosts, newBytes) {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+func parseID(id string) (primitive.ObjectID, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return primitive.NilObjectID, ErrInvalidID
+	}
+	return oid, nil
+}
@@ -0,0 +1,33 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Revision is a saved version of the content of a post, written by live
+// editing sessions
+type Revision struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty"`
+	TenantID string             `bson:"tenant_id"`
+	BlogID   primitive.ObjectID `bson:"blog_id"`
+	// Number counts the revisions of a post, starting at 1
+	Number  int64  `bson:"number"`
+	Content string `bson:"content"`
+	// Editors are the users who changed the content since the revision before
+	Editors   []string  `bson:"editors,omitempty"`
+	CreatedAt time.Time `bson:"created_at"`
+}
+
+// RevisionRepository stores the revisions of the posts of the tenant in the
+// context, call Add in the same transaction as the change of the post
+type RevisionRepository interface {
+	// Add numbers the revision after the last one of its post and stores it
+	Add(ctx context.Context, blogID string, rev *Revision) error
+	// List returns the newest revisions of a post first
+	List(ctx context.Context, blogID string, limit int) ([]*Revision, error)
+	// DeleteForBlog removes every revision of a post
+	DeleteForBlog(ctx context.Context, blogID string) error
+}
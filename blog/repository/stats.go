@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"sort"
+	"time"
+)
+
+// StatsFilter selects the posts counted by BlogRepository.Stats, empty
+// fields do not filter
+type StatsFilter struct {
+	Status string
+	// Since and Until bound the creation time, Until is exclusive
+	Since time.Time
+	Until time.Time
+	// Limit caps the number of authors and tags, the busiest come first
+	Limit int
+}
+
+func (f StatsFilter) matches(item *BlogItem) bool {
+	if f.Status != "" && item.Status != f.Status {
+		return false
+	}
+	if !f.Since.IsZero() && item.CreatedAt.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !item.CreatedAt.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+// StatsBucket holds the totals of a group of posts
+type StatsBucket struct {
+	// Key is the author id, the tag or the month as "2006-01"
+	Key   string `bson:"_id"`
+	Posts int64  `bson:"posts"`
+	Words int64  `bson:"words"`
+}
+
+// AverageWords returns the mean length of the posts in words
+func (b StatsBucket) AverageWords() float64 {
+	if b.Posts == 0 {
+		return 0
+	}
+	return float64(b.Words) / float64(b.Posts)
+}
+
+// BlogStats are the totals of the posts of a tenant. Authors and tags are
+// sorted by post count, months in calendar order.
+type BlogStats struct {
+	Total   StatsBucket
+	Authors []StatsBucket
+	Tags    []StatsBucket
+	Months  []StatsBucket
+}
+
+// monthFormat is the key of a month bucket, months are in UTC
+const monthFormat = "2006-01"
+
+// statsAggregator computes BlogStats in memory, it does what the mongodb
+// pipeline does for the stores without one
+type statsAggregator struct {
+	total   StatsBucket
+	authors map[string]*StatsBucket
+	tags    map[string]*StatsBucket
+	months  map[string]*StatsBucket
+}
+
+func newStatsAggregator() *statsAggregator {
+	return &statsAggregator{
+		authors: map[string]*StatsBucket{},
+		tags:    map[string]*StatsBucket{},
+		months:  map[string]*StatsBucket{},
+	}
+}
+
+func (a *statsAggregator) add(item *BlogItem) {
+	count := func(buckets map[string]*StatsBucket, key string) {
+		b, ok := buckets[key]
+		if !ok {
+			b = &StatsBucket{Key: key}
+			buckets[key] = b
+		}
+		b.Posts++
+		b.Words += item.WordCount
+	}
+
+	a.total.Posts++
+	a.total.Words += item.WordCount
+	count(a.authors, item.AuthorID)
+	for _, tag := range item.Tags {
+		count(a.tags, tag)
+	}
+	count(a.months, item.CreatedAt.UTC().Format(monthFormat))
+}
+
+func (a *statsAggregator) stats(limit int) *BlogStats {
+	return &BlogStats{
+		Total:   a.total,
+		Authors: busiest(a.authors, limit),
+		Tags:    busiest(a.tags, limit),
+		Months:  chronological(a.months),
+	}
+}
+
+func busiest(buckets map[string]*StatsBucket, limit int) []StatsBucket {
+	list := make([]StatsBucket, 0, len(buckets))
+	for _, b := range buckets {
+		list = append(list, *b)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Posts != list[j].Posts {
+			return list[i].Posts > list[j].Posts
+		}
+		return list[i].Key < list[j].Key
+	})
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+	return list
+}
+
+func chronological(buckets map[string]*StatsBucket) []StatsBucket {
+	list := make([]StatsBucket, 0, len(buckets))
+	for _, b := range buckets {
+		list = append(list, *b)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Key < list[j].Key
+	})
+	return list
+}
@@ -0,0 +1,287 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"learn-grpc/blog/tenant"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// aggregate runs pipeline over docs the way mongodb does, for the stages and
+// operators the stats pipeline uses
+func aggregate(t *testing.T, docs []bson.M, pipeline bson.A) []bson.M {
+	t.Helper()
+	for _, stage := range pipeline {
+		for op, arg := range stage.(bson.M) {
+			switch op {
+			case "$match":
+				var kept []bson.M
+				for _, doc := range docs {
+					if matches(t, doc, arg.(bson.M)) {
+						kept = append(kept, doc)
+					}
+				}
+				docs = kept
+			case "$facet":
+				result := bson.M{}
+				for name, sub := range arg.(bson.M) {
+					result[name] = aggregate(t, docs, sub.(bson.A))
+				}
+				docs = []bson.M{result}
+			case "$unwind":
+				field := arg.(string)[1:]
+				var unwound []bson.M
+				for _, doc := range docs {
+					values, _ := doc[field].(bson.A)
+					for _, value := range values {
+						copied := bson.M{}
+						for k, v := range doc {
+							copied[k] = v
+						}
+						copied[field] = value
+						unwound = append(unwound, copied)
+					}
+				}
+				docs = unwound
+			case "$group":
+				docs = group(t, docs, arg.(bson.M))
+			case "$sort":
+				var keys bson.D
+				switch arg := arg.(type) {
+				case bson.D:
+					keys = arg
+				case bson.M:
+					for k, v := range arg {
+						keys = append(keys, bson.E{Key: k, Value: v})
+					}
+				}
+				sort.SliceStable(docs, func(i, j int) bool {
+					for _, key := range keys {
+						if c := compare(docs[i][key.Key], docs[j][key.Key]); c != 0 {
+							return (c < 0) == (key.Value.(int) > 0)
+						}
+					}
+					return false
+				})
+			case "$limit":
+				if n := arg.(int); len(docs) > n {
+					docs = docs[:n]
+				}
+			default:
+				t.Fatalf("unexpected stage %s", op)
+			}
+		}
+	}
+	return docs
+}
+
+func matches(t *testing.T, doc, query bson.M) bool {
+	for field, cond := range query {
+		ops, ok := cond.(bson.M)
+		if !ok {
+			if doc[field] != cond {
+				return false
+			}
+			continue
+		}
+		for op, value := range ops {
+			c := compare(doc[field], primitive.NewDateTimeFromTime(value.(time.Time)))
+			switch op {
+			case "$gte":
+				if c < 0 {
+					return false
+				}
+			case "$lt":
+				if c >= 0 {
+					return false
+				}
+			default:
+				t.Fatalf("unexpected query operator %s", op)
+			}
+		}
+	}
+	return true
+}
+
+func group(t *testing.T, docs []bson.M, spec bson.M) []bson.M {
+	var order []interface{}
+	groups := map[interface{}]bson.M{}
+	for _, doc := range docs {
+		key := eval(t, doc, spec["_id"])
+		g, ok := groups[key]
+		if !ok {
+			g = bson.M{"_id": key}
+			groups[key] = g
+			order = append(order, key)
+		}
+		for field, acc := range spec {
+			if field == "_id" {
+				continue
+			}
+			sum, _ := g[field].(int64)
+			switch value := eval(t, doc, acc.(bson.M)["$sum"]).(type) {
+			case int:
+				sum += int64(value)
+			case int64:
+				sum += value
+			}
+			g[field] = sum
+		}
+	}
+	result := make([]bson.M, 0, len(order))
+	for _, key := range order {
+		result = append(result, groups[key])
+	}
+	return result
+}
+
+// eval returns the value of an expression for doc
+func eval(t *testing.T, doc bson.M, expr interface{}) interface{} {
+	switch expr := expr.(type) {
+	case string:
+		if len(expr) > 0 && expr[0] == '$' {
+			return doc[expr[1:]]
+		}
+		return expr
+	case bson.M:
+		spec := expr["$dateToString"].(bson.M)
+		if spec["format"] != "%Y-%m" {
+			t.Fatalf("unexpected date format %v", spec["format"])
+		}
+		return eval(t, doc, spec["date"]).(primitive.DateTime).Time().UTC().Format("2006-01")
+	}
+	return expr
+}
+
+func compare(a, b interface{}) int {
+	switch a := a.(type) {
+	case string:
+		b := b.(string)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+	case int64:
+		b := b.(int64)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+	case primitive.DateTime:
+		b := b.(primitive.DateTime)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+	default:
+		panic(fmt.Sprintf("can not compare %T", a))
+	}
+	return 0
+}
+
+// mongoStats returns what MongoStore.Stats returns for the posts
+func mongoStats(t *testing.T, items []*BlogItem, tenantID string, filter StatsFilter) *BlogStats {
+	t.Helper()
+	var docs []bson.M
+	for _, item := range items {
+		raw, err := bson.Marshal(item)
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc := bson.M{}
+		if err := bson.Unmarshal(raw, &doc); err != nil {
+			t.Fatal(err)
+		}
+		docs = append(docs, doc)
+	}
+
+	result := aggregate(t, docs, statsPipeline(tenantID, filter))
+	raw, err := bson.Marshal(result[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var facets statsFacets
+	if err := bson.Unmarshal(raw, &facets); err != nil {
+		t.Fatal(err)
+	}
+	return facets.stats()
+}
+
+func TestStatsParity(t *testing.T) {
+	ctx := tenant.NewContext(context.Background(), "acme")
+	store := NewMemoryStore(Options{})
+	month := func(m time.Month, day int) time.Time { return time.Date(2026, m, day, 12, 0, 0, 0, time.UTC) }
+	for _, item := range []*BlogItem{
+		{AuthorID: "fajar", Content: "one two three", Tags: []string{"go", "grpc"}, Status: StatusPublished, CreatedAt: month(1, 5)},
+		{AuthorID: "fajar", Content: "one two", Tags: []string{"go"}, Status: StatusPublished, CreatedAt: month(1, 31)},
+		{AuthorID: "sari", Content: "one two three four", Tags: []string{"mongo"}, Status: StatusPublished, CreatedAt: month(2, 1)},
+		{AuthorID: "sari", Content: "one", Status: StatusDraft, CreatedAt: month(3, 10)},
+		{AuthorID: "budi", Content: "one two three four five", Tags: []string{"go"}, Status: StatusPublished, CreatedAt: month(3, 20)},
+	} {
+		item.Title = "post"
+		if err := store.Blogs().Create(ctx, item); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a post of another tenant is never counted
+	other := &BlogItem{AuthorID: "fajar", Title: "post", Content: "one", Tags: []string{"go"}, Status: StatusPublished, CreatedAt: month(1, 1)}
+	if err := store.Blogs().Create(tenant.NewContext(context.Background(), "globex"), other); err != nil {
+		t.Fatal(err)
+	}
+	var items []*BlogItem
+	for _, item := range store.state.blogs {
+		items = append(items, item)
+	}
+
+	tests := []struct {
+		name   string
+		filter StatsFilter
+		total  StatsBucket
+	}{
+		{name: "all", total: StatsBucket{Posts: 5, Words: 15}},
+		{name: "published", filter: StatsFilter{Status: StatusPublished}, total: StatsBucket{Posts: 4, Words: 14}},
+		{name: "since", filter: StatsFilter{Since: month(2, 1)}, total: StatsBucket{Posts: 3, Words: 10}},
+		{name: "until", filter: StatsFilter{Until: month(2, 1)}, total: StatsBucket{Posts: 2, Words: 5}},
+		{name: "limit", filter: StatsFilter{Limit: 1}, total: StatsBucket{Posts: 5, Words: 15}},
+		{name: "nothing", filter: StatsFilter{Status: StatusRejected}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			memory, err := store.Blogs().Stats(ctx, tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if memory.Total != tt.total {
+				t.Errorf("total = %+v, want %+v", memory.Total, tt.total)
+			}
+			mongo := mongoStats(t, items, "acme", tt.filter)
+			for _, lists := range [][2][]StatsBucket{
+				{memory.Authors, mongo.Authors},
+				{memory.Tags, mongo.Tags},
+				{memory.Months, mongo.Months},
+			} {
+				if len(lists[0]) == 0 && len(lists[1]) == 0 {
+					continue
+				}
+				if !reflect.DeepEqual(lists[0], lists[1]) {
+					t.Errorf("memory %+v, mongodb %+v", lists[0], lists[1])
+				}
+			}
+			if memory.Total != mongo.Total {
+				t.Errorf("memory total %+v, mongodb %+v", memory.Total, mongo.Total)
+			}
+		})
+	}
+}
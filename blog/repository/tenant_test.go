@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"context"
+	"learn-grpc/blog/tenant"
+	"testing"
+	"time"
+)
+
+func TestTenantIsolation(t *testing.T) {
+	store := NewMemoryStore(Options{})
+	acme := tenant.NewContext(context.Background(), "acme")
+	globex := tenant.NewContext(context.Background(), "globex")
+
+	item := &BlogItem{AuthorID: "fajar", Title: "acme post", Content: "secret", Status: StatusPublished, CreatedAt: time.Now()}
+	if err := store.Blogs().Create(acme, item); err != nil {
+		t.Fatal(err)
+	}
+	id := item.ID.Hex()
+	if _, err := store.Reactions().Add(acme, id, "fajar", "like"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Revisions().Add(acme, id, &Revision{Content: "secret", CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// every call is made by globex on the post of acme
+	tests := []struct {
+		name string
+		call func(ctx context.Context) error
+	}{
+		{name: "find", call: func(ctx context.Context) error {
+			_, err := store.Blogs().FindByID(ctx, id)
+			return err
+		}},
+		{name: "replace", call: func(ctx context.Context) error {
+			return store.Blogs().Replace(ctx, &BlogItem{ID: item.ID, Title: "taken over"})
+		}},
+		{name: "delete", call: func(ctx context.Context) error {
+			return store.Blogs().Delete(ctx, id)
+		}},
+		{name: "count a view", call: func(ctx context.Context) error {
+			return store.Blogs().IncrementViews(ctx, id, 1)
+		}},
+		{name: "react", call: func(ctx context.Context) error {
+			_, err := store.Reactions().Add(ctx, id, "budi", "like")
+			return err
+		}},
+		{name: "remove a reaction", call: func(ctx context.Context) error {
+			_, err := store.Reactions().Remove(ctx, id, "fajar", "like")
+			return err
+		}},
+		{name: "add a revision", call: func(ctx context.Context) error {
+			return store.Revisions().Add(ctx, id, &Revision{Content: "taken over"})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.call(globex); err != ErrNotFound {
+				t.Errorf("err = %v, want ErrNotFound", err)
+			}
+			if err := tt.call(context.Background()); err != tenant.ErrMissing {
+				t.Errorf("without a tenant: err = %v, want ErrMissing", err)
+			}
+		})
+	}
+
+	t.Run("list", func(t *testing.T) {
+		n := 0
+		err := store.Blogs().List(globex, ListFilter{}, func(*BlogItem) error {
+			n++
+			return nil
+		})
+		if err != nil || n != 0 {
+			t.Errorf("globex lists %d posts (err %v), want none", n, err)
+		}
+	})
+	t.Run("stats", func(t *testing.T) {
+		stats, err := store.Blogs().Stats(globex, StatsFilter{})
+		if err != nil || stats.Total.Posts != 0 {
+			t.Errorf("globex counts %+v (err %v), want no posts", stats.Total, err)
+		}
+	})
+	t.Run("revisions", func(t *testing.T) {
+		revisions, err := store.Revisions().List(globex, id, 10)
+		if err == nil && len(revisions) > 0 {
+			t.Errorf("globex lists %d revisions of the acme post", len(revisions))
+		}
+	})
+
+	// the post of acme is untouched
+	found, err := store.Blogs().FindByID(acme, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Title != "acme post" || found.ViewCount != 0 || found.Reactions["like"] != 1 {
+		t.Errorf("acme post changed: %+v", found)
+	}
+	revisions, err := store.Revisions().List(acme, id, 10)
+	if err != nil || len(revisions) != 1 {
+		t.Errorf("acme has %d revisions (err %v), want 1", len(revisions), err)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"learn-grpc/blog/backup"
+	"learn-grpc/blog/domain"
+	"learn-grpc/blog/repository"
+	"net/url"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AdminServer implements the BlogAdminService
+type AdminServer struct {
+	store repository.Store
+	// blogs keeps the in-memory data of the blog service current
+	blogs *Server
+	// defaultTenant is used by requests that name no tenant
+	defaultTenant string
+	// backups is nil when backups are disabled
+	backups *backup.Snapshotter
+}
+
+func (s *AdminServer) RegisterWebhook(ctx context.Context, req *domain.RegisterWebhookRequest) (*domain.RegisterWebhookResponse, error) {
+	fmt.Println("RegisterWebhook\n", req.GetUrl())
+
+	// the webhook only gets the events of this tenant
+	ctx, err := s.adminTenant(ctx, req.GetTenantId())
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(req.GetUrl())
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "url must be an absolute http or https url, got %q", req.GetUrl())
+	}
+	for _, event := range req.GetEvents() {
+		if !knownEvent(event) {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown event %q, expected one of %v", event, repository.EventTypes)
+		}
+	}
+
+	secret := req.GetSecret()
+	if secret == "" {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, status.Errorf(codes.Internal, "can not generate secret: %v", err)
+		}
+		secret = hex.EncodeToString(buf)
+	}
+
+	hook := &repository.Webhook{
+		URL:       u.String(),
+		Secret:    secret,
+		Events:    req.GetEvents(),
+		CreatedAt: time.Now(),
+	}
+	if err := s.store.Webhooks().Create(ctx, hook); err != nil {
+		return nil, storeError(err)
+	}
+
+	// the secret is only shown once, the receiver needs it to verify signatures
+	res := webhookToPb(hook)
+	res.Secret = hook.Secret
+	return &domain.RegisterWebhookResponse{Webhook: res}, nil
+}
+
+func (s *AdminServer) ListWebhooks(ctx context.Context, req *domain.ListWebhooksRequest) (*domain.ListWebhooksResponse, error) {
+	fmt.Println("ListWebhooks")
+
+	ctx, err := s.adminTenant(ctx, req.GetTenantId())
+	if err != nil {
+		return nil, err
+	}
+	hooks, err := s.store.Webhooks().List(ctx)
+	if err != nil {
+		return nil, storeError(err)
+	}
+
+	res := &domain.ListWebhooksResponse{}
+	for _, hook := range hooks {
+		res.Webhooks = append(res.Webhooks, webhookToPb(hook))
+	}
+	return res, nil
+}
+
+func (s *AdminServer) DeleteWebhook(ctx context.Context, req *domain.DeleteWebhookRequest) (*domain.DeleteWebhookResponse, error) {
+	fmt.Println("DeleteWebhook\n", req.GetWebhookId())
+
+	ctx, err := s.adminTenant(ctx, req.GetTenantId())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.Webhooks().Delete(ctx, req.GetWebhookId()); err != nil {
+		return nil, storeError(err)
+	}
+	return &domain.DeleteWebhookResponse{WebhookId: req.GetWebhookId()}, nil
+}
+
+func knownEvent(event string) bool {
+	for _, e := range repository.EventTypes {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func webhookToPb(hook *repository.Webhook) *domain.Webhook {
+	return &domain.Webhook{
+		Id:       hook.ID.Hex(),
+		Url:      hook.URL,
+		Events:   hook.Events,
+		TenantId: hook.TenantID,
+	}
+}
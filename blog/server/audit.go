@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"learn-grpc/blog/audit"
+	"learn-grpc/blog/auth"
+	"learn-grpc/blog/domain"
+	"learn-grpc/blog/repository"
+	"learn-grpc/blog/tenant"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (s *AdminServer) ListAuditEvents(req *domain.ListAuditEventsRequest, stream domain.BlogAdminService_ListAuditEventsServer) error {
+	fmt.Println("ListAuditEvents\n", req)
+
+	filter := repository.AuditFilter{
+		TenantID: req.GetTenantId(),
+		Actor:    req.GetActor(),
+		Limit:    int(req.GetLimit()),
+	}
+	if req.GetUnscoped() {
+		filter.TenantID = ""
+	} else {
+		if admin, ok := auth.AdminFromContext(stream.Context()); ok && filter.TenantID == "" {
+			filter.TenantID = admin.TenantID
+		}
+		if filter.TenantID == "" {
+			filter.TenantID = s.defaultTenant
+		}
+		if !tenant.Valid(filter.TenantID) {
+			return status.Errorf(codes.InvalidArgument, "invalid tenant id %q", filter.TenantID)
+		}
+	}
+	// the calls made without a tenant are only for admins of every tenant
+	if err := auth.CheckTenant(stream.Context(), filter.TenantID); err != nil {
+		return err
+	}
+	if req.GetSince() != nil {
+		filter.Since = req.GetSince().AsTime()
+	}
+	if req.GetUntil() != nil {
+		filter.Until = req.GetUntil().AsTime()
+	}
+
+	// the whole chain is checked before anything is sent, filtering it
+	// first would skip the links between the entries it leaves out
+	head, err := s.store.Audit().Head(stream.Context(), filter.TenantID)
+	if err != nil {
+		return storeError(err)
+	}
+	chain := &audit.Chain{Head: head}
+	var matched []*repository.AuditEntry
+	err = s.store.Audit().List(stream.Context(), repository.AuditFilter{TenantID: filter.TenantID}, func(entry *repository.AuditEntry) error {
+		if err := chain.Check(entry); err != nil {
+			return err
+		}
+		if filter.Matches(entry) && (filter.Limit <= 0 || len(matched) < filter.Limit) {
+			matched = append(matched, entry)
+		}
+		return nil
+	})
+	if err == nil {
+		err = chain.Finish()
+	}
+	if errors.Is(err, audit.ErrBrokenChain) {
+		return status.Errorf(codes.DataLoss, "audit trail was tampered with: %v", err)
+	}
+	if err != nil {
+		return storeError(err)
+	}
+
+	for _, entry := range matched {
+		if err := stream.Send(&domain.ListAuditEventsResponse{Event: auditEntryToPb(entry)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func auditEntryToPb(entry *repository.AuditEntry) *domain.AuditEvent {
+	return &domain.AuditEvent{
+		Id:            entry.ID.Hex(),
+		TenantId:      entry.TenantID,
+		Seq:           entry.Seq,
+		Time:          timestamppb.New(entry.Time),
+		Actor:         entry.Actor,
+		ActorVerified: entry.ActorVerified,
+		Peer:          entry.Peer,
+		Method:        entry.Method,
+		TargetId:      entry.TargetID,
+		BeforeHash:    entry.BeforeHash,
+		AfterHash:     entry.AfterHash,
+		Code:          entry.Code,
+		PrevHash:      entry.PrevHash,
+		Hash:          entry.Hash,
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"learn-grpc/blog/auth"
+	"learn-grpc/blog/backup"
+	"learn-grpc/blog/domain"
+	"learn-grpc/blog/repository"
+	"learn-grpc/blog/tenant"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (s *AdminServer) CreateBackup(ctx context.Context, req *domain.CreateBackupRequest) (*domain.CreateBackupResponse, error) {
+	fmt.Println("CreateBackup")
+
+	// a backup holds every tenant
+	if err := auth.CheckTenant(ctx, ""); err != nil {
+		return nil, err
+	}
+	if s.backups == nil {
+		return nil, errBackupsDisabled()
+	}
+	snap, err := s.backups.Snapshot(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "backup failed: %v", err)
+	}
+	return &domain.CreateBackupResponse{Backup: snapshotToPb(snap)}, nil
+}
+
+func (s *AdminServer) ListBackups(ctx context.Context, req *domain.ListBackupsRequest) (*domain.ListBackupsResponse, error) {
+	fmt.Println("ListBackups")
+
+	if err := auth.CheckTenant(ctx, ""); err != nil {
+		return nil, err
+	}
+	if s.backups == nil {
+		return nil, errBackupsDisabled()
+	}
+	snapshots, err := backup.List(s.backups.Dir)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "can not list backups: %v", err)
+	}
+
+	res := &domain.ListBackupsResponse{}
+	for _, snap := range snapshots {
+		res.Backups = append(res.Backups, snapshotToPb(snap))
+	}
+	return res, nil
+}
+
+func (s *AdminServer) RestoreBlogs(ctx context.Context, req *domain.RestoreBlogsRequest) (*domain.RestoreBlogsResponse, error) {
+	fmt.Println("RestoreBlogs\n", req)
+
+	if err := auth.CheckTenant(ctx, req.GetTenantId()); err != nil {
+		return nil, err
+	}
+	if s.backups == nil {
+		return nil, errBackupsDisabled()
+	}
+	if req.GetTenantId() != "" && !tenant.Valid(req.GetTenantId()) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid tenant id %q", req.GetTenantId())
+	}
+
+	var at time.Time
+	if req.GetPointInTime() != nil {
+		at = req.GetPointInTime().AsTime()
+	}
+	snap, err := backup.Find(s.backups.Dir, req.GetBackup(), at)
+	if errors.Is(err, backup.ErrNoSnapshot) {
+		return nil, status.Errorf(codes.NotFound, "no backup matches the request")
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "can not list backups: %v", err)
+	}
+
+	opts := backup.RestoreOptions{
+		DryRun:   req.GetDryRun(),
+		TenantID: req.GetTenantId(),
+		Restored: s.blogs.blogChanged,
+	}
+	switch req.GetConflict() {
+	case domain.RestoreConflict_RESTORE_CONFLICT_OVERWRITE:
+		opts.Conflict = backup.ConflictOverwrite
+	case domain.RestoreConflict_RESTORE_CONFLICT_FAIL:
+		opts.Conflict = backup.ConflictFail
+	default:
+		opts.Conflict = backup.ConflictSkip
+	}
+
+	report, err := backup.Restore(ctx, s.store, snap, opts)
+	switch {
+	case errors.Is(err, backup.ErrConflict):
+		return nil, status.Errorf(codes.AlreadyExists, "%v, first ids: %v", err, report.Conflicts)
+	case errors.Is(err, repository.ErrIDTaken):
+		return nil, status.Errorf(codes.FailedPrecondition, "restore stopped after %d posts: %v", report.Created+report.Overwritten, err)
+	case err != nil:
+		return nil, status.Errorf(codes.Internal, "restore stopped after %d posts: %v", report.Created+report.Overwritten, err)
+	}
+
+	return &domain.RestoreBlogsResponse{
+		Backup:      snapshotToPb(snap),
+		Posts:       int64(report.Posts),
+		Created:     int64(report.Created),
+		Overwritten: int64(report.Overwritten),
+		Skipped:     int64(report.Skipped),
+		Conflicts:   report.Conflicts,
+		DryRun:      req.GetDryRun(),
+	}, nil
+}
+
+func errBackupsDisabled() error {
+	return status.Errorf(codes.FailedPrecondition, "backups are disabled, start the server with -backup-dir")
+}
+
+func snapshotToPb(snap *backup.Snapshot) *domain.Backup {
+	return &domain.Backup{
+		Name:      snap.Name,
+		CreatedAt: timestamppb.New(snap.Time),
+		SizeBytes: snap.Size,
+	}
+}
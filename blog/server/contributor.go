@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"learn-grpc/blog/auth"
+	"learn-grpc/blog/domain"
+	"learn-grpc/blog/repository"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// caller returns the user making the request. The x-user-id metadata only
+// counts when the server has no token secret, with a secret only the subject
+// of a valid token does
+func (s *Server) caller(ctx context.Context) string {
+	userID, verified := auth.Identity(ctx, s.authSecret)
+	if (s.requireToken || len(s.authSecret) > 0) && !verified {
+		return ""
+	}
+	return userID
+}
+
+// authorize checks that the caller has at least the role min on the post. A
+// post the caller can not see is not found, so drafts do not give away that
+// they exist
+func (s *Server) authorize(ctx context.Context, data *repository.BlogItem, min string) error {
+	userID := s.caller(ctx)
+	if !visible(data, userID) {
+		return status.Errorf(codes.NotFound, "blog %s not found", data.ID.Hex())
+	}
+	if userID == "" {
+		return status.Errorf(codes.Unauthenticated, "send a bearer token or %s metadata to %s this blog", auth.UserMetadataKey, action(min))
+	}
+	if !repository.RoleAtLeast(data.RoleOf(userID), min) {
+		return status.Errorf(codes.PermissionDenied, "%s needs the %s role to %s blog %s", userID, min, action(min), data.ID.Hex())
+	}
+	return nil
+}
+
+func action(role string) string {
+	switch role {
+	case repository.RoleOwner:
+		return "manage"
+	case repository.RoleEditor:
+		return "edit"
+	default:
+		return "read"
+	}
+}
+
+func (s *Server) AddContributor(ctx context.Context, req *domain.AddContributorRequest) (*domain.AddContributorResponse, error) {
+	fmt.Println("AddContributor\n", req)
+
+	role := roleFromPb(req.GetRole())
+	if req.GetUserId() == "" || role == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "user_id and role are required")
+	}
+
+	data, err := s.changeBlog(ctx, req.GetBlogId(), repository.RoleOwner, func(data *repository.BlogItem) error {
+		if data.RoleOf(req.GetUserId()) != "" {
+			return status.Errorf(codes.AlreadyExists, "%s already contributes to blog %s", req.GetUserId(), req.GetBlogId())
+		}
+		data.Contributors = append(data.Contributors, repository.Contributor{
+			UserID:  req.GetUserId(),
+			Role:    role,
+			AddedAt: time.Now(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &domain.AddContributorResponse{Blog: dataToBlogPb(data)}, nil
+}
+
+func (s *Server) RemoveContributor(ctx context.Context, req *domain.RemoveContributorRequest) (*domain.RemoveContributorResponse, error) {
+	fmt.Println("RemoveContributor\n", req)
+
+	data, err := s.changeBlog(ctx, req.GetBlogId(), repository.RoleOwner, func(data *repository.BlogItem) error {
+		role := data.RoleOf(req.GetUserId())
+		if role == "" {
+			return status.Errorf(codes.NotFound, "%s does not contribute to blog %s", req.GetUserId(), req.GetBlogId())
+		}
+		if role == repository.RoleOwner && data.Owners() == 1 {
+			return status.Errorf(codes.FailedPrecondition, "can not remove the last owner of blog %s", req.GetBlogId())
+		}
+
+		kept := data.Contributors[:0]
+		for _, c := range data.Contributors {
+			if c.UserID != req.GetUserId() {
+				kept = append(kept, c)
+			}
+		}
+		data.Contributors = kept
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &domain.RemoveContributorResponse{Blog: dataToBlogPb(data)}, nil
+}
+
+func (s *Server) UpdateContributorRole(ctx context.Context, req *domain.UpdateContributorRoleRequest) (*domain.UpdateContributorRoleResponse, error) {
+	fmt.Println("UpdateContributorRole\n", req)
+
+	role := roleFromPb(req.GetRole())
+	if role == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "role is required")
+	}
+
+	data, err := s.changeBlog(ctx, req.GetBlogId(), repository.RoleOwner, func(data *repository.BlogItem) error {
+		current := data.RoleOf(req.GetUserId())
+		if current == "" {
+			return status.Errorf(codes.NotFound, "%s does not contribute to blog %s", req.GetUserId(), req.GetBlogId())
+		}
+		if current == repository.RoleOwner && role != repository.RoleOwner && data.Owners() == 1 {
+			return status.Errorf(codes.FailedPrecondition, "can not demote the last owner of blog %s", req.GetBlogId())
+		}
+		for i := range data.Contributors {
+			if data.Contributors[i].UserID == req.GetUserId() {
+				data.Contributors[i].Role = role
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &domain.UpdateContributorRoleResponse{Blog: dataToBlogPb(data)}, nil
+}
+
+func roleFromPb(role domain.ContributorRole) string {
+	switch role {
+	case domain.ContributorRole_CONTRIBUTOR_ROLE_OWNER:
+		return repository.RoleOwner
+	case domain.ContributorRole_CONTRIBUTOR_ROLE_EDITOR:
+		return repository.RoleEditor
+	case domain.ContributorRole_CONTRIBUTOR_ROLE_VIEWER:
+		return repository.RoleViewer
+	default:
+		return ""
+	}
+}
+
+func roleToPb(role string) domain.ContributorRole {
+	switch role {
+	case repository.RoleOwner:
+		return domain.ContributorRole_CONTRIBUTOR_ROLE_OWNER
+	case repository.RoleEditor:
+		return domain.ContributorRole_CONTRIBUTOR_ROLE_EDITOR
+	case repository.RoleViewer:
+		return domain.ContributorRole_CONTRIBUTOR_ROLE_VIEWER
+	default:
+		return domain.ContributorRole_CONTRIBUTOR_ROLE_UNSPECIFIED
+	}
+}
+
+func contributorsToPb(contributors []repository.Contributor) []*domain.Contributor {
+	var res []*domain.Contributor
+	for _, c := range contributors {
+		res = append(res, &domain.Contributor{
+			UserId:  c.UserID,
+			Role:    roleToPb(c.Role),
+			AddedAt: timestamppb.New(c.AddedAt),
+		})
+	}
+	return res
+}
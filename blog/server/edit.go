@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"learn-grpc/blog/audit"
+	"learn-grpc/blog/domain"
+	"learn-grpc/blog/liveedit"
+	"learn-grpc/blog/repository"
+	"learn-grpc/blog/tenant"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// leaveTimeout bounds the save made when a participant leaves
+const leaveTimeout = 30 * time.Second
+
+// EditBlog joins the live editing session of a post. Viewers follow the
+// changes, editors and owners also send operations.
+func (s *Server) EditBlog(stream domain.BlogService_EditBlogServer) error {
+	fmt.Println("EditBlog function was invoked")
+	ctx := stream.Context()
+
+	req, err := stream.Recv()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	join := req.GetJoin()
+	if join == nil {
+		return status.Errorf(codes.InvalidArgument, "the first message must be join")
+	}
+
+	data, err := s.store.Blogs().FindByID(ctx, join.GetBlogId())
+	if err != nil {
+		return storeError(err)
+	}
+	if err := s.authorize(ctx, data, repository.RoleViewer); err != nil {
+		return err
+	}
+	userID := s.caller(ctx)
+	readOnly := !repository.RoleAtLeast(data.RoleOf(userID), repository.RoleEditor)
+	tenantID, _ := tenant.FromContext(ctx)
+	blogID := data.ID.Hex()
+	before := audit.HashBlog(data)
+
+	p := s.edits.Join(tenantID, blogID, userID, readOnly, data.Content)
+	defer func() {
+		// the call may be canceled already, the last changes are still saved
+		leaveCtx, cancel := context.WithTimeout(tenant.NewContext(context.Background(), tenantID), leaveTimeout)
+		defer cancel()
+		p.Leave(leaveCtx)
+
+		if readOnly {
+			return
+		}
+		after, err := s.store.Blogs().FindByID(leaveCtx, blogID)
+		if err != nil {
+			after = nil
+		}
+		audit.Describe(ctx, blogID, before, audit.HashBlog(after))
+	}()
+
+	joined := &domain.EditJoined{
+		Content:  p.Content,
+		Revision: p.Revision,
+		ClientId: p.ClientID,
+		ReadOnly: readOnly,
+	}
+	for _, other := range p.Others {
+		joined.Participants = append(joined.Participants, presenceToPb(other))
+	}
+	if err := stream.Send(&domain.EditBlogResponse{Message: &domain.EditBlogResponse_Joined{Joined: joined}}); err != nil {
+		return err
+	}
+
+	// requests are read in the background, the events of the session are
+	// sent from here
+	errc := make(chan error, 1)
+	go func() {
+		errc <- receiveEdits(stream, p)
+	}()
+
+	for {
+		select {
+		case ev, ok := <-p.Events():
+			if !ok {
+				if p.Dropped() {
+					return status.Errorf(codes.ResourceExhausted, "too slow to follow the changes of blog %s, join again", blogID)
+				}
+				if err := p.Err(); err != nil {
+					return editError(err)
+				}
+				return nil
+			}
+			if err := stream.Send(eventToPb(ev)); err != nil {
+				return err
+			}
+		case err := <-errc:
+			return err
+		}
+	}
+}
+
+// receiveEdits applies the requests of a participant until the client
+// closes its side of the stream
+func receiveEdits(stream domain.BlogService_EditBlogServer, p *liveedit.Participant) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch msg := req.GetMessage().(type) {
+		case *domain.EditBlogRequest_Operation:
+			op, err := opFromPb(msg.Operation.GetOperation())
+			if err != nil {
+				return editError(err)
+			}
+			if _, err := p.Submit(msg.Operation.GetBaseRevision(), op, msg.Operation.GetClientOpId()); err != nil {
+				return editError(err)
+			}
+		case *domain.EditBlogRequest_Presence:
+			presence := msg.Presence
+			if err := p.SetPresence(presence.GetRevision(), int(presence.GetCursor()), int(presence.GetSelectionEnd())); err != nil {
+				return editError(err)
+			}
+		case *domain.EditBlogRequest_Join:
+			return status.Errorf(codes.InvalidArgument, "already joined, open a new stream to edit another blog")
+		default:
+			return status.Errorf(codes.InvalidArgument, "empty message")
+		}
+	}
+}
+
+// saveEdit stores the content of a live editing session as a new revision,
+// it goes through moderation like UpdateBlog. It does not save over content
+// changed outside the session or changes of an editor who lost the role.
+// Rejected content is not stored, content held for review is, both close
+// the session so the editors learn about it.
+func (s *Server) saveEdit(ctx context.Context, tenantID, blogID, base, content string, editors []string) error {
+	ctx = tenant.NewContext(ctx, tenantID)
+
+	var data *repository.BlogItem
+	var held bool
+	err := s.store.WithTransaction(ctx, func(ctx context.Context, tx repository.Tx) error {
+		found, err := tx.Blogs().FindByID(ctx, blogID)
+		if err != nil {
+			return err
+		}
+		if found.Content != base {
+			return liveedit.ErrConflict
+		}
+		for _, editor := range editors {
+			if !repository.RoleAtLeast(found.RoleOf(editor), repository.RoleEditor) {
+				return fmt.Errorf("%w: %s", liveedit.ErrNotEditor, editor)
+			}
+		}
+		found.Content = content
+		found.UpdatedAt = time.Now()
+		wasHeld := found.Status == repository.StatusPendingReview
+		if err := s.moderate(ctx, found); err != nil {
+			return fmt.Errorf("%w: %s", liveedit.ErrRejected, status.Convert(err).Message())
+		}
+		held = !wasHeld && found.Status == repository.StatusPendingReview
+
+		if err := tx.Blogs().Replace(ctx, found); err != nil {
+			return err
+		}
+		err = tx.Revisions().Add(ctx, blogID, &repository.Revision{
+			Content:   content,
+			Editors:   editors,
+			CreatedAt: found.UpdatedAt,
+		})
+		if err != nil {
+			return err
+		}
+		data = found
+		return addEvent(ctx, tx, repository.EventBlogUpdated, blogID, found)
+	})
+	if err != nil {
+		return err
+	}
+	s.blogChanged(data)
+	if held {
+		return fmt.Errorf("%w: %s", liveedit.ErrHeld, data.ModerationNote)
+	}
+	return nil
+}
+
+// editError converts the errors of a live editing session
+func editError(err error) error {
+	switch {
+	case errors.Is(err, liveedit.ErrReadOnly):
+		return status.Errorf(codes.PermissionDenied, "only editors and owners can change the blog: %v", err)
+	case errors.Is(err, liveedit.ErrStaleRevision):
+		return status.Errorf(codes.FailedPrecondition, "join again to catch up: %v", err)
+	case errors.Is(err, liveedit.ErrTooLong):
+		return status.Errorf(codes.ResourceExhausted, "%v", err)
+	case errors.Is(err, liveedit.ErrLeft):
+		return status.Errorf(codes.ResourceExhausted, "dropped from the session, join again: %v", err)
+	case errors.Is(err, liveedit.ErrConflict):
+		return status.Errorf(codes.Aborted, "join again to edit the stored blog: %v", err)
+	case errors.Is(err, liveedit.ErrNotEditor):
+		return status.Errorf(codes.PermissionDenied, "%v", err)
+	case errors.Is(err, liveedit.ErrRejected):
+		return status.Errorf(codes.InvalidArgument, "%v", err)
+	case errors.Is(err, liveedit.ErrHeld):
+		return status.Errorf(codes.FailedPrecondition, "the changes were saved, join again to go on editing: %v", err)
+	case errors.Is(err, liveedit.ErrInvalidOp):
+		return status.Errorf(codes.InvalidArgument, "%v", err)
+	default:
+		return status.Errorf(codes.Internal, "internal error: %v", err)
+	}
+}
+
+func opFromPb(op *domain.TextOperation) (liveedit.Op, error) {
+	components := make([]liveedit.Component, 0, len(op.GetComponents()))
+	for _, c := range op.GetComponents() {
+		switch c := c.GetComponent().(type) {
+		case *domain.TextComponent_Retain:
+			components = append(components, liveedit.Component{Retain: int(c.Retain)})
+		case *domain.TextComponent_Insert:
+			components = append(components, liveedit.Component{Insert: c.Insert})
+		case *domain.TextComponent_Delete:
+			components = append(components, liveedit.Component{Delete: int(c.Delete)})
+		}
+	}
+	return liveedit.NewOp(components...)
+}
+
+func opToPb(op liveedit.Op) *domain.TextOperation {
+	res := &domain.TextOperation{}
+	for _, c := range op {
+		component := &domain.TextComponent{}
+		switch {
+		case c.Retain > 0:
+			component.Component = &domain.TextComponent_Retain{Retain: int32(c.Retain)}
+		case c.Insert != "":
+			component.Component = &domain.TextComponent_Insert{Insert: c.Insert}
+		case c.Delete > 0:
+			component.Component = &domain.TextComponent_Delete{Delete: int32(c.Delete)}
+		}
+		res.Components = append(res.Components, component)
+	}
+	return res
+}
+
+func presenceToPb(p liveedit.Presence) *domain.EditParticipant {
+	return &domain.EditParticipant{
+		UserId:       p.UserID,
+		ClientId:     p.ClientID,
+		Cursor:       int32(p.Cursor),
+		SelectionEnd: int32(p.SelectionEnd),
+		Left:         p.Left,
+	}
+}
+
+func eventToPb(ev liveedit.Event) *domain.EditBlogResponse {
+	switch ev.Kind {
+	case liveedit.EventAck:
+		return &domain.EditBlogResponse{Message: &domain.EditBlogResponse_Ack{Ack: &domain.EditAck{
+			ClientOpId: ev.ClientOpID,
+			Revision:   ev.Revision,
+		}}}
+	case liveedit.EventOperation:
+		return &domain.EditBlogResponse{Message: &domain.EditBlogResponse_Operation{Operation: &domain.EditRemoteOperation{
+			Revision:  ev.Revision,
+			UserId:    ev.Presence.UserID,
+			ClientId:  ev.Presence.ClientID,
+			Operation: opToPb(ev.Op),
+		}}}
+	case liveedit.EventPresence:
+		return &domain.EditBlogResponse{Message: &domain.EditBlogResponse_Presence{Presence: presenceToPb(ev.Presence)}}
+	default:
+		saved := &domain.EditSaved{
+			Revision: ev.Revision,
+			SavedAt:  timestamppb.New(ev.SavedAt),
+		}
+		if ev.Err != nil {
+			saved.Error = ev.Err.Error()
+		}
+		return &domain.EditBlogResponse{Message: &domain.EditBlogResponse_Saved{Saved: saved}}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"learn-grpc/blog/domain"
+	"learn-grpc/blog/liveedit"
+	"learn-grpc/blog/repository"
+	"testing"
+)
+
+func TestSaveEdit(t *testing.T) {
+	tests := []struct {
+		name string
+		// change runs between joining the session and saving it
+		change func(t *testing.T, s *Server, id string)
+		// content is saved by the session, "hello world!" when empty
+		content string
+		err     error
+		stored  string
+		status  string
+	}{
+		{
+			name:   "saved",
+			change: func(t *testing.T, s *Server, id string) {},
+			stored: "hello world!",
+		},
+		{
+			name: "title changed",
+			change: func(t *testing.T, s *Server, id string) {
+				update := &domain.Blog{Id: id, Title: "bye", Content: "hello world"}
+				if _, err := s.UpdateBlog(as("fajar"), &domain.UpdateBlogRequest{Blog: update}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			stored: "hello world!",
+		},
+		{
+			name: "content changed by UpdateBlog",
+			change: func(t *testing.T, s *Server, id string) {
+				update := &domain.Blog{Id: id, Title: "hello", Content: "hello there"}
+				if _, err := s.UpdateBlog(as("fajar"), &domain.UpdateBlogRequest{Blog: update}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			err:    liveedit.ErrConflict,
+			stored: "hello there",
+		},
+		{
+			name: "editor removed",
+			change: func(t *testing.T, s *Server, id string) {
+				if _, err := s.RemoveContributor(as("fajar"), &domain.RemoveContributorRequest{BlogId: id, UserId: "sari"}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			err:    liveedit.ErrNotEditor,
+			stored: "hello world",
+		},
+		{
+			name:    "rejected by moderation",
+			change:  func(t *testing.T, s *Server, id string) {},
+			content: "hello spam",
+			err:     liveedit.ErrRejected,
+			stored:  "hello world",
+		},
+		{
+			name:    "held for review",
+			change:  func(t *testing.T, s *Server, id string) {},
+			content: "see https://a.example and https://b.example",
+			err:     liveedit.ErrHeld,
+			stored:  "see https://a.example and https://b.example",
+			status:  repository.StatusPendingReview,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			created := create(t, s, "fajar", &domain.Blog{Title: "hello", Content: "hello world"})
+			req := &domain.AddContributorRequest{BlogId: created.GetId(), UserId: "sari", Role: domain.ContributorRole_CONTRIBUTOR_ROLE_EDITOR}
+			if _, err := s.AddContributor(as("fajar"), req); err != nil {
+				t.Fatal(err)
+			}
+
+			tt.change(t, s, created.GetId())
+			content := tt.content
+			if content == "" {
+				content = "hello world!"
+			}
+			err := s.saveEdit(context.Background(), "acme", created.GetId(), "hello world", content, []string{"sari"})
+			if !errors.Is(err, tt.err) || (tt.err == nil && err != nil) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			data, err := s.store.Blogs().FindByID(as("fajar"), created.GetId())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data.Content != tt.stored {
+				t.Errorf("stored %q, want %q", data.Content, tt.stored)
+			}
+			if tt.status != "" && data.Status != tt.status {
+				t.Errorf("status = %s, want %s", data.Status, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"learn-grpc/blog/domain"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNewPacer(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits []int
+		want   time.Duration
+	}{
+		{name: "no limits", want: 0},
+		{name: "zero limits", limits: []int{0, 0}, want: 0},
+		{name: "server limit", limits: []int{10, 0}, want: 100 * time.Millisecond},
+		{name: "client limit", limits: []int{0, 4}, want: 250 * time.Millisecond},
+		{name: "lowest wins", limits: []int{10, 4}, want: 250 * time.Millisecond},
+		{name: "client can not go faster", limits: []int{4, 10}, want: 250 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newPacer(tt.limits...).interval; got != tt.want {
+				t.Errorf("interval = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPacerWait(t *testing.T) {
+	p := newPacer(100)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := p.wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the first send goes at once, the four others 10ms apart
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("5 sends at 100 per second took %s", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := p.wait(ctx); err != context.Canceled {
+		t.Errorf("wait with a canceled context: err = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestListBlogPacing(t *testing.T) {
+	s := newTestServer(t)
+	for i := 0; i < 4; i++ {
+		create(t, s, "fajar", &domain.Blog{Title: fmt.Sprintf("post %d", i), Content: "hello world"})
+	}
+
+	tests := []struct {
+		name    string
+		req     *domain.ListBlogRequest
+		timeout time.Duration
+		code    codes.Code
+		// min is the least time the listing takes
+		min      time.Duration
+		sent     string
+		complete string
+	}{
+		{name: "unlimited", req: &domain.ListBlogRequest{}, sent: "4", complete: "true"},
+		{name: "small batches", req: &domain.ListBlogRequest{BatchSize: 1}, sent: "4", complete: "true"},
+		{name: "max per second", req: &domain.ListBlogRequest{MaxPerSecond: 50}, min: 60 * time.Millisecond, sent: "4", complete: "true"},
+		{name: "deadline while waiting", req: &domain.ListBlogRequest{MaxPerSecond: 1}, timeout: 100 * time.Millisecond, code: codes.DeadlineExceeded, sent: "1", complete: "false"},
+		{name: "negative batch size", req: &domain.ListBlogRequest{BatchSize: -1}, code: codes.InvalidArgument},
+		{name: "batch size too large", req: &domain.ListBlogRequest{BatchSize: maxListBatch + 1}, code: codes.InvalidArgument},
+		{name: "negative max per second", req: &domain.ListBlogRequest{MaxPerSecond: -1}, code: codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := as("")
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			stream := &listStream{ctx: ctx}
+			start := time.Now()
+			err := s.ListBlog(tt.req, stream)
+			if code := status.Code(err); code != tt.code {
+				t.Fatalf("code = %s, want %s (%v)", code, tt.code, err)
+			}
+			if elapsed := time.Since(start); elapsed < tt.min {
+				t.Errorf("listed in %s, want at least %s", elapsed, tt.min)
+			}
+			if tt.sent == "" {
+				return
+			}
+			if got := stream.trailer.Get("x-list-sent"); len(got) != 1 || got[0] != tt.sent {
+				t.Errorf("x-list-sent = %v, want %s", got, tt.sent)
+			}
+			if got := stream.trailer.Get("x-list-complete"); len(got) != 1 || got[0] != tt.complete {
+				t.Errorf("x-list-complete = %v, want %s", got, tt.complete)
+			}
+			if got := fmt.Sprint(len(stream.blogs)); got != tt.sent {
+				t.Errorf("sent %s posts, want %s", got, tt.sent)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"learn-grpc/blog/audit"
+	"learn-grpc/blog/auth"
+	"learn-grpc/blog/domain"
+	"learn-grpc/blog/moderation"
+	"learn-grpc/blog/repository"
+	"learn-grpc/blog/tenant"
+	"strings"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errNotPending aborts a review of a post that is not in the queue
+var errNotPending = errors.New("blog is not pending review")
+
+const (
+	defaultQueueLimit = 50
+	maxQueueLimit     = 500
+)
+
+// moderate runs the pipeline on a post about to be written. A rejected post
+// returns an InvalidArgument error carrying every violation, a post held for
+// review gets the pending status unless it is a draft, it is checked again
+// when it is published. Only a moderator takes a post out of review, an edit
+// of a post they rejected sends it back to the queue.
+func (s *Server) moderate(ctx context.Context, data *repository.BlogItem) error {
+	decision := s.moderation.Moderate(ctx, data)
+	switch decision.Action {
+	case moderation.Reject:
+		return rejection(decision)
+	case moderation.Review:
+		if data.Status != repository.StatusDraft {
+			data.Status = repository.StatusPendingReview
+		}
+		data.ModerationNote = violationNote(decision)
+	default:
+		switch data.Status {
+		case repository.StatusRejected:
+			data.Status = repository.StatusPendingReview
+		case repository.StatusPendingReview:
+		default:
+			data.ModerationNote = ""
+		}
+	}
+	return nil
+}
+
+// rejection builds the status returned for a rejected post, clients find the
+// violations in a BadRequest detail
+func rejection(decision moderation.Decision) error {
+	badRequest := &errdetails.BadRequest{}
+	for _, v := range decision.Violations {
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       "blog." + v.Field,
+			Description: v.Message,
+		})
+	}
+	info := &errdetails.ErrorInfo{
+		Reason: "CONTENT_REJECTED",
+		Domain: "blog",
+	}
+
+	st := status.New(codes.InvalidArgument, "blog rejected by moderation: "+violationNote(decision))
+	withDetails, err := st.WithDetails(badRequest, info)
+	if err != nil {
+		return st.Err()
+	}
+	return withDetails.Err()
+}
+
+func violationNote(decision moderation.Decision) string {
+	var notes []string
+	for _, v := range decision.Violations {
+		notes = append(notes, fmt.Sprintf("%s %s", v.Field, v.Message))
+	}
+	return strings.Join(notes, "; ")
+}
+
+// adminTenant scopes an admin request to the tenant it names, the tenant
+// of the admin when it names none and the admin has one
+func (s *AdminServer) adminTenant(ctx context.Context, tenantID string) (context.Context, error) {
+	if admin, ok := auth.AdminFromContext(ctx); ok && tenantID == "" {
+		tenantID = admin.TenantID
+	}
+	if tenantID == "" {
+		tenantID = s.defaultTenant
+	}
+	if !tenant.Valid(tenantID) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid tenant id %q", tenantID)
+	}
+	if err := auth.CheckTenant(ctx, tenantID); err != nil {
+		return nil, err
+	}
+	return tenant.NewContext(ctx, tenantID), nil
+}
+
+func (s *AdminServer) ListModerationQueue(ctx context.Context, req *domain.ListModerationQueueRequest) (*domain.ListModerationQueueResponse, error) {
+	fmt.Println("ListModerationQueue\n", req)
+
+	ctx, err := s.adminTenant(ctx, req.GetTenantId())
+	if err != nil {
+		return nil, err
+	}
+	limit := int(req.GetLimit())
+	if limit <= 0 {
+		limit = defaultQueueLimit
+	}
+	if limit > maxQueueLimit {
+		limit = maxQueueLimit
+	}
+
+	res := &domain.ListModerationQueueResponse{}
+	filter := repository.ListFilter{Status: repository.StatusPendingReview, Limit: limit}
+	err = s.store.Blogs().List(ctx, filter, func(data *repository.BlogItem) error {
+		res.Blogs = append(res.Blogs, dataToBlogPb(data))
+		return nil
+	})
+	if err != nil {
+		return nil, storeError(err)
+	}
+	return res, nil
+}
+
+func (s *AdminServer) ReviewBlog(ctx context.Context, req *domain.ReviewBlogRequest) (*domain.ReviewBlogResponse, error) {
+	fmt.Println("ReviewBlog\n", req)
+
+	ctx, err := s.adminTenant(ctx, req.GetTenantId())
+	if err != nil {
+		return nil, err
+	}
+
+	var data *repository.BlogItem
+	var before string
+	err = audit.WithTransaction(ctx, s.store, func(ctx context.Context, tx repository.Tx) error {
+		found, err := tx.Blogs().FindByID(ctx, req.GetBlogId())
+		if err != nil {
+			return err
+		}
+		before = audit.HashBlog(found)
+		if found.Status != repository.StatusPendingReview {
+			return errNotPending
+		}
+
+		found.Status = repository.StatusRejected
+		if req.GetApprove() {
+			found.Status = repository.StatusPublished
+		}
+		found.ModerationNote = req.GetReason()
+		found.UpdatedAt = time.Now()
+
+		if err := tx.Blogs().Replace(ctx, found); err != nil {
+			return err
+		}
+		data = found
+		if err := addEvent(ctx, tx, repository.EventBlogUpdated, found.ID.Hex(), found); err != nil {
+			return err
+		}
+		return audit.Record(ctx, tx, found.ID.Hex(), before, audit.HashBlog(found))
+	})
+	if errors.Is(err, errNotPending) {
+		return nil, status.Errorf(codes.FailedPrecondition, "blog %s is not waiting for review", req.GetBlogId())
+	}
+	if err != nil {
+		return nil, storeError(err)
+	}
+	s.blogs.blogChanged(data)
+
+	return &domain.ReviewBlogResponse{Blog: dataToBlogPb(data)}, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"learn-grpc/blog/auth"
+	"learn-grpc/blog/domain"
+	"learn-grpc/blog/repository"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	clean = "hello world"
+	links = "see https://a.example and https://b.example"
+	spam  = "buy spam now"
+)
+
+func TestModerationTransitions(t *testing.T) {
+	type step struct {
+		// exactly one of update and review is set
+		update *domain.Blog
+		review *domain.ReviewBlogRequest
+		code   codes.Code
+		status string
+	}
+	published := domain.BlogStatus_BLOG_STATUS_PUBLISHED
+	draft := domain.BlogStatus_BLOG_STATUS_DRAFT
+
+	tests := []struct {
+		name   string
+		create *domain.Blog
+		code   codes.Code
+		status string
+		steps  []step
+	}{
+		{name: "clean post is published", create: &domain.Blog{Content: clean}, status: repository.StatusPublished},
+		{name: "rejected content is not stored", create: &domain.Blog{Content: spam}, code: codes.InvalidArgument},
+		{name: "too many links are held", create: &domain.Blog{Content: links}, status: repository.StatusPendingReview},
+		{name: "drafts are not held", create: &domain.Blog{Content: links, Status: draft}, status: repository.StatusDraft},
+		{
+			name:   "held post is not published by its author",
+			create: &domain.Blog{Content: links},
+			status: repository.StatusPendingReview,
+			steps: []step{
+				{update: &domain.Blog{Content: clean, Status: published}, status: repository.StatusPendingReview},
+				{update: &domain.Blog{Content: clean, Status: draft}, status: repository.StatusPendingReview},
+				{review: &domain.ReviewBlogRequest{Approve: true}, status: repository.StatusPublished},
+				{review: &domain.ReviewBlogRequest{Approve: true}, code: codes.FailedPrecondition, status: repository.StatusPublished},
+			},
+		},
+		{
+			name:   "rejected post goes back to review when it is changed",
+			create: &domain.Blog{Content: links},
+			status: repository.StatusPendingReview,
+			steps: []step{
+				{review: &domain.ReviewBlogRequest{Approve: false, Reason: "ads"}, status: repository.StatusRejected},
+				{update: &domain.Blog{Content: clean, Status: published}, status: repository.StatusPendingReview},
+				{review: &domain.ReviewBlogRequest{Approve: false}, status: repository.StatusRejected},
+				{update: &domain.Blog{Content: links, Status: draft}, status: repository.StatusPendingReview},
+			},
+		},
+		{
+			name:   "published post is held when it is changed",
+			create: &domain.Blog{Content: clean},
+			status: repository.StatusPublished,
+			steps: []step{
+				{update: &domain.Blog{Content: links}, status: repository.StatusPendingReview},
+			},
+		},
+		{
+			name:   "rejected change keeps the post",
+			create: &domain.Blog{Content: clean},
+			status: repository.StatusPublished,
+			steps: []step{
+				{update: &domain.Blog{Content: spam}, code: codes.InvalidArgument, status: repository.StatusPublished},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			admin := &AdminServer{store: s.store, blogs: s, defaultTenant: "default"}
+			adminCtx := auth.NewAdminContext(context.Background(), &auth.Admin{Subject: "ops"})
+
+			tt.create.Title = "hello"
+			res, err := s.CreateBlog(as("fajar"), &domain.CreateBlogRequest{Blog: tt.create})
+			if code := status.Code(err); code != tt.code {
+				t.Fatalf("create: code = %s, want %s (%v)", code, tt.code, err)
+			}
+			if err != nil {
+				return
+			}
+			id := res.GetBlog().GetId()
+			checkStatus(t, s, "create", id, tt.status)
+
+			for i, step := range tt.steps {
+				var err error
+				if step.update != nil {
+					step.update.Id, step.update.Title = id, "hello"
+					_, err = s.UpdateBlog(as("fajar"), &domain.UpdateBlogRequest{Blog: step.update})
+				} else {
+					step.review.TenantId, step.review.BlogId = "acme", id
+					_, err = admin.ReviewBlog(adminCtx, step.review)
+				}
+				if code := status.Code(err); code != step.code {
+					t.Fatalf("step %d: code = %s, want %s (%v)", i, code, step.code, err)
+				}
+				checkStatus(t, s, "step", id, step.status)
+			}
+		})
+	}
+}
+
+func checkStatus(t *testing.T, s *Server, what, id, want string) {
+	t.Helper()
+	data, err := s.store.Blogs().FindByID(as("fajar"), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Status != want {
+		t.Fatalf("%s: status = %s, want %s", what, data.Status, want)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"learn-grpc/blog/auth"
+	"learn-grpc/blog/domain"
+	"learn-grpc/blog/repository"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *Server) AddReaction(ctx context.Context, req *domain.AddReactionRequest) (*domain.AddReactionResponse, error) {
+	fmt.Println("AddReaction\n", req)
+
+	added := false
+	reactions, err := s.changeReaction(ctx, req.GetBlogId(), req.GetReaction(),
+		func(ctx context.Context, tx repository.Tx, userID string) (err error) {
+			added, err = tx.Reactions().Add(ctx, req.GetBlogId(), userID, req.GetReaction())
+			return err
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.AddReactionResponse{BlogId: req.GetBlogId(), Reactions: reactions, Added: added}, nil
+}
+
+func (s *Server) RemoveReaction(ctx context.Context, req *domain.RemoveReactionRequest) (*domain.RemoveReactionResponse, error) {
+	fmt.Println("RemoveReaction\n", req)
+
+	removed := false
+	reactions, err := s.changeReaction(ctx, req.GetBlogId(), req.GetReaction(),
+		func(ctx context.Context, tx repository.Tx, userID string) (err error) {
+			removed, err = tx.Reactions().Remove(ctx, req.GetBlogId(), userID, req.GetReaction())
+			return err
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.RemoveReactionResponse{BlogId: req.GetBlogId(), Reactions: reactions, Removed: removed}, nil
+}
+
+// changeReaction validates the request, runs change for the caller and
+// returns the new counts. Only published posts take reactions, the user_id
+// of the request is ignored so nobody reacts in the name of someone else.
+func (s *Server) changeReaction(ctx context.Context, blogID, reaction string, change func(ctx context.Context, tx repository.Tx, userID string) error) (map[string]int64, error) {
+	userID := s.caller(ctx)
+	if userID == "" {
+		return nil, status.Errorf(codes.Unauthenticated, "send a bearer token or %s metadata to react to a blog", auth.UserMetadataKey)
+	}
+	if !repository.ValidReaction(reaction) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid reaction %q", reaction)
+	}
+
+	var data *repository.BlogItem
+	err := s.store.WithTransaction(ctx, func(ctx context.Context, tx repository.Tx) error {
+		found, err := tx.Blogs().FindByID(ctx, blogID)
+		if err != nil {
+			return err
+		}
+		if !visible(found, userID) {
+			return status.Errorf(codes.NotFound, "blog %s not found", blogID)
+		}
+		if found.Status != repository.StatusPublished {
+			return status.Errorf(codes.FailedPrecondition, "blog %s is not published", blogID)
+		}
+
+		if err := change(ctx, tx, userID); err != nil {
+			return err
+		}
+		// the counters changed with the reaction
+		data, err = tx.Blogs().FindByID(ctx, blogID)
+		return err
+	})
+	if err != nil {
+		return nil, txError(err)
+	}
+	return reactionCounts(data.Reactions), nil
+}
+
+// reactionCounts drops the reactions nobody gives anymore
+func reactionCounts(reactions map[string]int64) map[string]int64 {
+	counts := map[string]int64{}
+	for reaction, n := range reactions {
+		if n > 0 {
+			counts[reaction] = n
+		}
+	}
+	return counts
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"learn-grpc/blog/domain"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAddReaction(t *testing.T) {
+	s := newTestServer(t)
+	published := create(t, s, "fajar", &domain.Blog{Title: "published", Content: "hello world"})
+	draft := create(t, s, "fajar", &domain.Blog{Title: "draft", Content: "hello world", Status: domain.BlogStatus_BLOG_STATUS_DRAFT})
+	held := create(t, s, "fajar", &domain.Blog{Title: "held", Content: "see https://a.example and https://b.example"})
+
+	tests := []struct {
+		name   string
+		user   string
+		req    *domain.AddReactionRequest
+		code   codes.Code
+		counts map[string]int64
+	}{
+		{
+			name: "anonymous",
+			req:  &domain.AddReactionRequest{BlogId: published.GetId(), Reaction: "like"},
+			code: codes.Unauthenticated,
+		},
+		{
+			name:   "reaction of the caller",
+			user:   "budi",
+			req:    &domain.AddReactionRequest{BlogId: published.GetId(), Reaction: "like"},
+			counts: map[string]int64{"like": 1},
+		},
+		{
+			name:   "same reaction again",
+			user:   "budi",
+			req:    &domain.AddReactionRequest{BlogId: published.GetId(), Reaction: "like"},
+			counts: map[string]int64{"like": 1},
+		},
+		{
+			name: "draft of someone else",
+			user: "budi",
+			req:  &domain.AddReactionRequest{BlogId: draft.GetId(), Reaction: "like"},
+			code: codes.NotFound,
+		},
+		{
+			name: "own draft",
+			user: "fajar",
+			req:  &domain.AddReactionRequest{BlogId: draft.GetId(), Reaction: "like"},
+			code: codes.FailedPrecondition,
+		},
+		{
+			name: "post held by moderation",
+			user: "budi",
+			req:  &domain.AddReactionRequest{BlogId: held.GetId(), Reaction: "like"},
+			code: codes.NotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := s.AddReaction(as(tt.user), tt.req)
+			if code := status.Code(err); code != tt.code {
+				t.Fatalf("code = %s, want %s (%v)", code, tt.code, err)
+			}
+			if err != nil {
+				return
+			}
+			if got := res.GetReactions(); len(got) != len(tt.counts) || got["like"] != tt.counts["like"] {
+				t.Errorf("reactions = %v, want %v", got, tt.counts)
+			}
+		})
+	}
+}
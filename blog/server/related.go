@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"learn-grpc/blog/domain"
+	"learn-grpc/blog/repository"
+)
+
+const (
+	defaultRelatedLimit = 5
+	maxRelatedLimit     = 50
+)
+
+func (s *Server) ListRelatedBlogs(ctx context.Context, req *domain.ListRelatedBlogsRequest) (*domain.ListRelatedBlogsResponse, error) {
+	fmt.Println("ListRelatedBlogs\n", req)
+
+	limit := int(req.GetLimit())
+	if limit <= 0 {
+		limit = defaultRelatedLimit
+	}
+	if limit > maxRelatedLimit {
+		limit = maxRelatedLimit
+	}
+
+	data, err := s.store.Blogs().FindByID(ctx, req.GetBlogId())
+	if err != nil {
+		return nil, storeError(err)
+	}
+
+	res := &domain.ListRelatedBlogsResponse{}
+	for _, match := range s.related.Related(data, limit) {
+		item, err := s.store.Blogs().FindByID(ctx, match.BlogID)
+		if errors.Is(err, repository.ErrNotFound) {
+			// deleted after the index was read
+			continue
+		}
+		if err != nil {
+			return nil, storeError(err)
+		}
+		res.Related = append(res.Related, &domain.RelatedBlog{
+			Blog:  dataToBlogPb(item),
+			Score: match.Score,
+		})
+	}
+	return res, nil
+}
@@ -5,36 +5,59 @@ package main
 
 import (
 	"context"
+	"errors"
+	"expvar"
+	"flag"
 	"fmt"
+	"learn-grpc/blog/audit"
+	"learn-grpc/blog/auth"
+	"learn-grpc/blog/backup"
 	"learn-grpc/blog/database/mongodb"
 	"learn-grpc/blog/domain"
+	"learn-grpc/blog/feed"
+	"learn-grpc/blog/liveedit"
+	"learn-grpc/blog/locale"
+	"learn-grpc/blog/moderation"
+	"learn-grpc/blog/outbox"
+	"learn-grpc/blog/related"
+	"learn-grpc/blog/repository"
+	"learn-grpc/blog/tenant"
+	"learn-grpc/blog/views"
+	"learn-grpc/internal/certreload"
 	"log"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"time"
 
-	"go.mongodb.org/mongo-driver/bson"
-
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
-
-	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"go.mongodb.org/mongo-driver/mongo"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
-var collection *mongo.Collection
-
 // Server conain server interface for Blog service
-type Server struct{}
-
-type BlogItem struct {
-	ID       primitive.ObjectID `bson:"_id,omitempty"`
-	AuthorID string             `bson:"author_id"`
-	Content  string             `bson:"content"`
-	Title    string             `bson:"title" validate:"required"`
+type Server struct {
+	store      repository.Store
+	views      *views.Counter
+	related    *related.Index
+	moderation *moderation.Pipeline
+	edits      *liveedit.Manager
+	// listRate limits the posts per second of every ListBlog stream
+	listRate int
+
+	// authSecret and requireToken decide who the caller is, see caller
+	authSecret   []byte
+	requireToken bool
 }
 
 func (s *Server) CreateBlog(ctx context.Context, req *domain.CreateBlogRequest) (*domain.CreateBlogResponse, error) {
@@ -44,30 +67,56 @@ func (s *Server) CreateBlog(ctx context.Context, req *domain.CreateBlogRequest)
 	// on here should there are some validate for incoming data
 
 	// this will be in delivery and usecase
-	data := BlogItem{
-		AuthorID: blog.GetAuthorId(),
-		Title:    blog.GetTitle(),
-		Content:  blog.GetContent(),
+	language, err := languageFromPb(blog.GetLanguage(), "", nil)
+	if err != nil {
+		return nil, err
 	}
 
-	// this will be in repository
-	res, err := collection.InsertOne(ctx, data)
-	if err != nil {
-		return nil, status.Errorf(codes.Internal, fmt.Sprintf("internal error: %v", err))
+	// the caller owns the post, a post without an owner could not be
+	// changed or deleted by anyone
+	owner := s.caller(ctx)
+	if owner == "" {
+		return nil, status.Errorf(codes.Unauthenticated, "send a bearer token or %s metadata to create a blog", auth.UserMetadataKey)
 	}
-	oid, ok := res.InsertedID.(primitive.ObjectID)
-	if !ok {
-		return nil, status.Errorf(codes.Internal, fmt.Sprintf("can not convert to oid"))
+
+	now := time.Now()
+	data := &repository.BlogItem{
+		AuthorID:  blog.GetAuthorId(),
+		Title:     blog.GetTitle(),
+		Content:   blog.GetContent(),
+		Tags:      normalizeTags(blog.GetTags()),
+		Status:    statusFromPb(blog.GetStatus(), repository.StatusPublished),
+		Language:  language,
+		CreatedAt: now,
+		UpdatedAt: now,
 	}
+	if data.AuthorID == "" {
+		data.AuthorID = owner
+	}
+	data.Contributors = []repository.Contributor{{UserID: owner, Role: repository.RoleOwner, AddedAt: now}}
+
+	err = audit.WithTransaction(ctx, s.store, func(ctx context.Context, tx repository.Tx) error {
+		// checked in the transaction like an update, so both see the same
+		// checks and a retried transaction checks again
+		if err := s.moderate(ctx, data); err != nil {
+			return err
+		}
+		if err := tx.Blogs().Create(ctx, data); err != nil {
+			return err
+		}
+		if err := addEvent(ctx, tx, repository.EventBlogCreated, data.ID.Hex(), data); err != nil {
+			return err
+		}
+		return audit.Record(ctx, tx, data.ID.Hex(), "", audit.HashBlog(data))
+	})
+	if err != nil {
+		return nil, txError(err)
+	}
+	s.blogChanged(data)
 
 	// this will be in delivery
 	return &domain.CreateBlogResponse{
-		Blog: &domain.Blog{
-			Id:       oid.Hex(),
-			AuthorId: blog.GetAuthorId(),
-			Title:    blog.GetTitle(),
-			Content:  blog.GetContent(),
-		},
+		Blog: dataToBlogPb(data),
 	}, nil
 }
 
@@ -75,27 +124,23 @@ func (s *Server) ReadBlog(ctx context.Context, req *domain.ReadBlogRequest) (*do
 	blogID := req.GetBlogId()
 	fmt.Println("ReadBlog blogID:\n", blogID)
 
-	oid, err := primitive.ObjectIDFromHex(blogID)
+	data, err := s.store.Blogs().FindByID(ctx, blogID)
 	if err != nil {
-		return nil, status.Errorf(
-			codes.InvalidArgument,
-			fmt.Sprintf("can not parse id\n%v\n", err),
-		)
+		return nil, storeError(err)
 	}
-
-	data := new(BlogItem)
-	filter := bson.M{"_id": oid}
-
-	res := collection.FindOne(ctx, filter)
-	if err := res.Decode(data); err != nil {
-		return nil, status.Errorf(
-			codes.NotFound,
-			fmt.Sprintf("data not found\n%v\n", err),
-		)
+	// anyone reads published posts, the others are for the contributors
+	if data.Status != repository.StatusPublished {
+		if err := s.authorize(ctx, data, repository.RoleViewer); err != nil {
+			return nil, err
+		}
 	}
 
+	// views are written in batches, count the ones not flushed yet
+	s.views.Add(ctx, blogID)
+	data.ViewCount += s.views.Pending(ctx, blogID)
+
 	return &domain.ReadBlogResponse{
-		Blog: dataToBlogPb(data),
+		Blog: dataToBlogPb(localize(data, locale.Preference(ctx, req.GetAcceptLanguage()))),
 	}, nil
 }
 
@@ -103,33 +148,45 @@ func (s *Server) UpdateBlog(ctx context.Context, req *domain.UpdateBlogRequest)
 	blog := req.GetBlog()
 	fmt.Println("UpdateBlog\n", blog)
 
-	oid, err := primitive.ObjectIDFromHex(blog.GetId())
-	if err != nil {
-		return nil, status.Errorf(codes.Internal, fmt.Sprintf("can not parse id\n%v\n", err))
-	}
-
-	data := new(BlogItem)
-	filter := bson.M{"_id": oid}
-
-	res := collection.FindOne(ctx, filter)
-	if err := res.Decode(data); err != nil {
-		return nil, status.Errorf(
-			codes.NotFound,
-			fmt.Sprintf("data not found", err),
-		)
-	}
-
-	data.AuthorID = blog.GetAuthorId()
-	data.Content = blog.GetContent()
-	data.Title = blog.GetTitle()
+	var data *repository.BlogItem
+	var before string
+	err := audit.WithTransaction(ctx, s.store, func(ctx context.Context, tx repository.Tx) error {
+		found, err := tx.Blogs().FindByID(ctx, blog.GetId())
+		if err != nil {
+			return err
+		}
+		if err := s.authorize(ctx, found, repository.RoleEditor); err != nil {
+			return err
+		}
+		before = audit.HashBlog(found)
+
+		found.AuthorID = blog.GetAuthorId()
+		found.Content = blog.GetContent()
+		found.Title = blog.GetTitle()
+		found.Tags = normalizeTags(blog.GetTags())
+		found.Status = statusFromPb(blog.GetStatus(), found.Status)
+		found.Language, err = languageFromPb(blog.GetLanguage(), found.Language, found.Translations)
+		if err != nil {
+			return err
+		}
+		found.UpdatedAt = time.Now()
+		if err := s.moderate(ctx, found); err != nil {
+			return err
+		}
 
-	_, updateErr := collection.ReplaceOne(ctx, filter, data)
-	if updateErr != nil {
-		return nil, status.Errorf(
-			codes.Internal,
-			fmt.Sprintf("error while update data", updateErr),
-		)
+		if err := tx.Blogs().Replace(ctx, found); err != nil {
+			return err
+		}
+		data = found
+		if err := addEvent(ctx, tx, repository.EventBlogUpdated, found.ID.Hex(), found); err != nil {
+			return err
+		}
+		return audit.Record(ctx, tx, found.ID.Hex(), before, audit.HashBlog(found))
+	})
+	if err != nil {
+		return nil, txError(err)
 	}
+	s.blogChanged(data)
 
 	return &domain.UpdateBlogResponse{
 		Blog: dataToBlogPb(data),
@@ -140,111 +197,618 @@ func (s *Server) DeleteBlog(ctx context.Context, req *domain.DeleteBlogRequest)
 	blogID := req.GetBlogId()
 	fmt.Println("DeleteBlog\n", blogID)
 
-	oid, err := primitive.ObjectIDFromHex(blogID)
-	if err != nil {
-		return nil, status.Errorf(
-			codes.Internal,
-			fmt.Sprintf("error while parse blog id\n%v\n", err),
-		)
-	}
+	var before string
+	err := audit.WithTransaction(ctx, s.store, func(ctx context.Context, tx repository.Tx) error {
+		found, err := tx.Blogs().FindByID(ctx, blogID)
+		if err != nil {
+			return err
+		}
+		if err := s.authorize(ctx, found, repository.RoleOwner); err != nil {
+			return err
+		}
+		before = audit.HashBlog(found)
 
-	filter := bson.M{"_id": oid}
-	res, err := collection.DeleteOne(ctx, filter)
+		if err := tx.Blogs().Delete(ctx, blogID); err != nil {
+			return err
+		}
+		if err := tx.Reactions().DeleteForBlog(ctx, blogID); err != nil {
+			return err
+		}
+		if err := tx.Revisions().DeleteForBlog(ctx, blogID); err != nil {
+			return err
+		}
+		if err := addEvent(ctx, tx, repository.EventBlogDeleted, blogID, nil); err != nil {
+			return err
+		}
+		return audit.Record(ctx, tx, blogID, before, "")
+	})
 	if err != nil {
-		return nil, status.Errorf(
-			codes.Internal,
-			fmt.Sprintf("error while delete blog\n%v\n", err),
-		)
-	}
-
-	if res.DeletedCount == 0 {
-		return nil, status.Errorf(
-			codes.Internal,
-			fmt.Sprintf("can not find data database\n%v\n", err),
-		)
+		return nil, txError(err)
 	}
+	s.blogDeleted(ctx, blogID)
 
 	return &domain.DeleteBlogResponse{BlogId: blogID}, nil
 }
 
+// maxListBatch bounds the batch size a ListBlog client can ask for
+const maxListBatch = 1000
+
 func (s *Server) ListBlog(req *domain.ListBlogRequest, stream domain.BlogService_ListBlogServer) error {
-	fmt.Println("ListBlog")
-	ctxBg := context.Background()
-	cur, err := collection.Find(ctxBg, primitive.D{{}})
-	if err != nil {
-		return status.Errorf(
-			codes.Internal,
-			fmt.Sprintf("unknown error occured when find data\n%v\n", err),
-		)
+	fmt.Println("ListBlog\n", req)
+	// the stream context carries the tenant and is canceled when the client
+	// goes away, which also closes the database cursor
+	ctx := stream.Context()
+	accept := locale.Preference(ctx, req.GetAcceptLanguage())
+
+	if req.GetBatchSize() < 0 || req.GetBatchSize() > maxListBatch {
+		return status.Errorf(codes.InvalidArgument, "batch_size must be between 0 and %d", maxListBatch)
+	}
+	if req.GetMaxPerSecond() < 0 {
+		return status.Errorf(codes.InvalidArgument, "max_per_second can not be negative")
 	}
+	pace := newPacer(s.listRate, int(req.GetMaxPerSecond()))
+
+	// the trailers tell a client that lost the stream how far it got
+	var sent int64
+	var lastID string
+	complete := false
+	defer func() {
+		stream.SetTrailer(metadata.Pairs(
+			"x-list-sent", strconv.FormatInt(sent, 10),
+			"x-list-last-id", lastID,
+			"x-list-complete", strconv.FormatBool(complete),
+		))
+	}()
 
-	defer cur.Close(ctxBg)
-	for cur.Next(ctxBg) {
-		data := new(BlogItem)
-		err := cur.Decode(data)
-		if err != nil {
-			return status.Errorf(
-				codes.Internal,
-				fmt.Sprintf("error while decoding data\n%v\n", err),
-			)
-		}
-		stream.Send(&domain.ListBlogResponse{Blog: dataToBlogPb(data)})
-		time.Sleep(500 * time.Millisecond)
+	// like ReadBlog, posts that are not published are only listed to their
+	// contributors
+	viewer := s.caller(ctx)
+
+	var sendErr error
+	filter := repository.ListFilter{
+		AuthorID:  req.GetAuthorId(),
+		Tag:       strings.ToLower(strings.TrimSpace(req.GetTag())),
+		BatchSize: int(req.GetBatchSize()),
 	}
-	if err := cur.Err(); err != nil {
+	err := s.store.Blogs().List(ctx, filter, func(data *repository.BlogItem) error {
+		if !visible(data, viewer) {
+			return nil
+		}
+		if err := pace.wait(ctx); err != nil {
+			return err
+		}
+		if err := stream.Send(&domain.ListBlogResponse{Blog: dataToBlogPb(localize(data, accept))}); err != nil {
+			sendErr = err
+			return err
+		}
+		sent++
+		lastID = data.ID.Hex()
+		return nil
+	})
+	switch {
+	case err == nil:
+		complete = true
+		return nil
+	case ctx.Err() != nil:
+		return status.FromContextError(ctx.Err()).Err()
+	case sendErr != nil:
+		return sendErr
+	default:
 		return status.Errorf(
 			codes.Internal,
 			fmt.Sprintf("unknown error occured\n%v\n", err),
 		)
 	}
+}
+
+// pacer spaces out sends to a rate limit
+type pacer struct {
+	interval time.Duration
+	next     time.Time
+}
+
+// newPacer returns a pacer for the lowest of the limits in sends per
+// second, limits of 0 do not count
+func newPacer(limits ...int) *pacer {
+	lowest := 0
+	for _, limit := range limits {
+		if limit > 0 && (lowest == 0 || limit < lowest) {
+			lowest = limit
+		}
+	}
+	if lowest == 0 {
+		return &pacer{}
+	}
+	return &pacer{interval: time.Second / time.Duration(lowest)}
+}
+
+// wait blocks until the next send is allowed or ctx is done
+func (p *pacer) wait(ctx context.Context) error {
+	if p.interval == 0 {
+		return nil
+	}
+	now := time.Now()
+	if wait := p.next.Sub(now); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+		now = p.next
+	}
+	p.next = now.Add(p.interval)
 	return nil
 }
 
-func dataToBlogPb(data *BlogItem) *domain.Blog {
+// blogChanged updates the in-memory data derived from posts after a
+// create or update was committed
+func (s *Server) blogChanged(data *repository.BlogItem) {
+	s.related.Upsert(data)
+	s.moderation.Upsert(data)
+}
+
+// blogDeleted is blogChanged for a committed delete
+func (s *Server) blogDeleted(ctx context.Context, blogID string) {
+	tenantID, _ := tenant.FromContext(ctx)
+	s.related.Remove(tenantID, blogID)
+	s.moderation.Remove(tenantID, blogID)
+}
+
+// visible reports whether a post is listed to userID, published posts are
+// listed to everyone, drafts and posts held or rejected by moderation only to
+// the contributors
+func visible(data *repository.BlogItem, userID string) bool {
+	if data.Status == repository.StatusPublished {
+		return true
+	}
+	return userID != "" && repository.RoleAtLeast(data.RoleOf(userID), repository.RoleViewer)
+}
+
+// changeBlog lets a contributor with at least the role min change a post
+// with fn, e.g. its contributors or translations
+func (s *Server) changeBlog(ctx context.Context, blogID, min string, fn func(data *repository.BlogItem) error) (*repository.BlogItem, error) {
+	var data *repository.BlogItem
+	var before string
+	err := audit.WithTransaction(ctx, s.store, func(ctx context.Context, tx repository.Tx) error {
+		found, err := tx.Blogs().FindByID(ctx, blogID)
+		if err != nil {
+			return err
+		}
+		if err := s.authorize(ctx, found, min); err != nil {
+			return err
+		}
+		before = audit.HashBlog(found)
+
+		if err := fn(found); err != nil {
+			return err
+		}
+		found.UpdatedAt = time.Now()
+		if err := tx.Blogs().Replace(ctx, found); err != nil {
+			return err
+		}
+		data = found
+		if err := addEvent(ctx, tx, repository.EventBlogUpdated, found.ID.Hex(), found); err != nil {
+			return err
+		}
+		return audit.Record(ctx, tx, blogID, before, audit.HashBlog(found))
+	})
+	if err != nil {
+		return nil, txError(err)
+	}
+	s.blogChanged(data)
+	return data, nil
+}
+
+// addEvent writes the change to the outbox, it must run in the same
+// transaction as the change so the event is never lost or sent for a
+// change that was rolled back
+func addEvent(ctx context.Context, tx repository.Tx, eventType, blogID string, data *repository.BlogItem) error {
+	return tx.Outbox().Add(ctx, &repository.OutboxEvent{
+		Type:      eventType,
+		BlogID:    blogID,
+		Blog:      data,
+		CreatedAt: time.Now(),
+	})
+}
+
+// txError is storeError for the error of a transaction, errors the handler
+// already turned into a status are returned as they are
+func txError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return storeError(err)
+}
+
+// storeError converts repository errors into grpc status errors
+func storeError(err error) error {
+	switch {
+	case errors.Is(err, repository.ErrInvalidID):
+		return status.Errorf(codes.InvalidArgument, fmt.Sprintf("can not parse id\n%v\n", err))
+	case errors.Is(err, repository.ErrNotFound):
+		return status.Errorf(codes.NotFound, fmt.Sprintf("data not found\n%v\n", err))
+	case errors.Is(err, repository.ErrInvalidReaction):
+		return status.Errorf(codes.InvalidArgument, fmt.Sprintf("invalid reaction\n%v\n", err))
+	case errors.Is(err, repository.ErrQuotaExceeded):
+		return status.Errorf(codes.ResourceExhausted, fmt.Sprintf("quota exceeded\n%v\n", err))
+	case errors.Is(err, tenant.ErrMissing):
+		return status.Errorf(codes.Unauthenticated, fmt.Sprintf("missing tenant\n%v\n", err))
+	default:
+		return status.Errorf(codes.Internal, fmt.Sprintf("internal error\n%v\n", err))
+	}
+}
+
+func dataToBlogPb(data *repository.BlogItem) *domain.Blog {
 	return &domain.Blog{
-		Id:       data.ID.Hex(),
-		AuthorId: data.AuthorID,
-		Content:  data.Content,
-		Title:    data.Title,
+		Id:        data.ID.Hex(),
+		AuthorId:  data.AuthorID,
+		Content:   data.Content,
+		Title:     data.Title,
+		Reactions: reactionCounts(data.Reactions),
+		ViewCount: data.ViewCount,
+		Tags:      data.Tags,
+		Status:    statusToPb(data.Status),
+		CreatedAt: timestamppb.New(data.CreatedAt),
+		UpdatedAt: timestamppb.New(data.UpdatedAt),
+
+		ModerationNote: data.ModerationNote,
+		Contributors:   contributorsToPb(data.Contributors),
+
+		Language:           data.Language,
+		AvailableLanguages: data.Languages(),
 	}
 }
 
+// statusFromPb returns the status a client asks for. Posts held or rejected
+// by moderation keep their status, only ReviewBlog moves them on.
+func statusFromPb(status domain.BlogStatus, current string) string {
+	if current == repository.StatusPendingReview || current == repository.StatusRejected {
+		return current
+	}
+	switch status {
+	case domain.BlogStatus_BLOG_STATUS_PUBLISHED:
+		return repository.StatusPublished
+	case domain.BlogStatus_BLOG_STATUS_DRAFT:
+		return repository.StatusDraft
+	default:
+		return current
+	}
+}
+
+func statusToPb(status string) domain.BlogStatus {
+	switch status {
+	case repository.StatusPublished:
+		return domain.BlogStatus_BLOG_STATUS_PUBLISHED
+	case repository.StatusDraft:
+		return domain.BlogStatus_BLOG_STATUS_DRAFT
+	case repository.StatusPendingReview:
+		return domain.BlogStatus_BLOG_STATUS_PENDING_REVIEW
+	case repository.StatusRejected:
+		return domain.BlogStatus_BLOG_STATUS_REJECTED
+	default:
+		return domain.BlogStatus_BLOG_STATUS_UNSPECIFIED
+	}
+}
+
+// normalizeTags lower cases and trims tags and drops empty and repeated ones
+func normalizeTags(tags []string) []string {
+	var normalized []string
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
+
 func main() {
 	// if we crash the go code, we get the file name and line number
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
+	m := mongodb.MongoDB{}
+	flag.StringVar(&m.URI, "mongo-uri", "mongodb://localhost:27017", "mongodb connection string")
+	flag.StringVar(&m.DB, "mongo-db", "mydb", "mongodb database name")
+	flag.StringVar(&m.Table, "mongo-collection", "blog", "mongodb collection for blog posts")
+	flag.StringVar(&m.Username, "mongo-username", os.Getenv("MONGO_USERNAME"), "mongodb username (env MONGO_USERNAME)")
+	flag.StringVar(&m.Password, "mongo-password", os.Getenv("MONGO_PASSWORD"), "mongodb password (env MONGO_PASSWORD)")
+	flag.StringVar(&m.AuthSource, "mongo-auth-source", "", "database used to authenticate the user")
+	flag.StringVar(&m.AuthMechanism, "mongo-auth-mechanism", "", "auth mechanism, e.g. SCRAM-SHA-256")
+	flag.BoolVar(&m.TLS, "mongo-tls", false, "connect to mongodb over TLS")
+	flag.StringVar(&m.TLSCAFile, "mongo-tls-ca", "", "CA certificate used to verify the mongodb server")
+	flag.StringVar(&m.TLSCertFile, "mongo-tls-cert", "", "client certificate presented to mongodb")
+	flag.StringVar(&m.TLSKeyFile, "mongo-tls-key", "", "private key of the client certificate")
+	flag.BoolVar(&m.TLSInsecureSkipVerify, "mongo-tls-insecure", false, "skip verification of the mongodb server certificate")
+	flag.StringVar(&m.ReplicaSet, "mongo-replica-set", "", "replica set name")
+	flag.Uint64Var(&m.MinPoolSize, "mongo-min-pool", 0, "minimum number of pooled connections")
+	flag.Uint64Var(&m.MaxPoolSize, "mongo-max-pool", 0, "maximum number of pooled connections (0 = driver default)")
+	flag.DurationVar(&m.ConnectTimeout, "mongo-connect-timeout", mongodb.DefaultConnectTimeout, "timeout for establishing a connection")
+	flag.DurationVar(&m.ServerSelectionTimeout, "mongo-server-selection-timeout", 0, "how long to wait for a suitable server")
+	flag.DurationVar(&m.SocketTimeout, "mongo-socket-timeout", 0, "timeout for socket reads and writes")
+	flag.StringVar(&m.ReadConcern, "mongo-read-concern", "", "read concern level, e.g. local or majority")
+	flag.StringVar(&m.WriteConcern, "mongo-write-concern", "", "write concern, majority or a number")
+	flag.DurationVar(&m.WriteTimeout, "mongo-write-timeout", 0, "write concern timeout")
+	flag.BoolVar(&m.Journal, "mongo-journal", false, "wait for writes to be journaled")
+	flag.IntVar(&m.PingAttempts, "mongo-ping-attempts", mongodb.DefaultPingAttempts, "number of startup ping attempts")
+	flag.DurationVar(&m.PingBackoff, "mongo-ping-backoff", mongodb.DefaultPingBackoff, "wait before the first ping retry, doubled on every retry")
+	flag.DurationVar(&m.MaxPingBackoff, "mongo-ping-max-backoff", mongodb.DefaultMaxPingBackoff, "longest wait between ping retries")
+	webhookPoll := flag.Duration("webhook-poll", outbox.DefaultPollInterval, "how often the outbox is checked for new events")
+	webhookAttempts := flag.Int("webhook-max-attempts", outbox.DefaultMaxAttempts, "delivery attempts before a webhook event is dead-lettered")
+	webhookBackoff := flag.Duration("webhook-backoff", outbox.DefaultInitialBackoff, "wait before the first webhook retry, doubled on every retry")
+	webhookRetention := flag.Duration("webhook-retention", outbox.DefaultRetention, "how long dispatched events and delivered webhook deliveries are kept")
+	viewFlush := flag.Duration("view-flush", views.DefaultFlushInterval, "how often batched view counts are written")
+	healthInterval := flag.Duration("health-interval", 10*time.Second, "how often mongodb is pinged for the health service")
+	authSecret := flag.String("auth-secret", os.Getenv("BLOG_AUTH_SECRET"), "HS256 secret used to verify bearer tokens (env BLOG_AUTH_SECRET)")
+	requireToken := flag.Bool("require-token", false, "only accept the tenant from a bearer token, not from x-tenant-id metadata or -default-tenant")
+	defaultTenant := flag.String("default-tenant", "default", "tenant for requests that name none, and for posts written before tenants existed; empty rejects such requests")
+	quotaFile := flag.String("tenant-quotas", "", "JSON file with the default and per tenant quotas")
+	maxPosts := flag.Int64("tenant-max-posts", 0, "default maximum number of posts per tenant (0 = unlimited)")
+	maxBytes := flag.Int64("tenant-max-bytes", 0, "default maximum size of titles and contents per tenant (0 = unlimited)")
+	storeKind := flag.String("store", "mongo", "where blog posts are kept: mongo or memory")
+	addr := flag.String("addr", "0.0.0.0:50051", "address the gRPC server listens on")
+	httpAddr := flag.String("http-addr", "0.0.0.0:8080", "address of the HTTP server for feeds and the sitemap, empty disables it")
+	publicURL := flag.String("public-url", "http://localhost:8080", "public url of the blog, used for links in feeds and the sitemap")
+	feedTitle := flag.String("feed-title", "Blog", "title of the feeds")
+	bannedWords := flag.String("banned-words", "", "file with one banned word per line, posts using one are rejected")
+	maxLinks := flag.Int("max-links", 5, "posts with more links are held for review (negative disables the check)")
+	backupDir := flag.String("backup-dir", "", "directory for periodic snapshots of the posts, empty disables backups")
+	backupInterval := flag.Duration("backup-interval", backup.DefaultInterval, "how often a snapshot is taken")
+	backupKeep := flag.Int("backup-keep", backup.DefaultKeep, "number of snapshots kept")
+	backupMaxAge := flag.Duration("backup-max-age", 0, "snapshots older than this are removed (0 = keep backup-keep snapshots)")
+	listRate := flag.Int("list-rate", 0, "posts per second sent on a ListBlog stream, clients may ask for less (0 = unlimited)")
+	editSave := flag.Duration("edit-save-interval", liveedit.DefaultPersistInterval, "how often live editing sessions are saved as a new revision")
+	duplicateThreshold := flag.Float64("duplicate-threshold", moderation.DefaultThreshold, "similarity from which a post is held for review as a duplicate (0 disables the check)")
+	certFile := flag.String("tls-cert", "", "server certificate, reloaded when it changes; empty serves without TLS")
+	keyFile := flag.String("tls-key", "", "key of the server certificate")
+	adminCA := flag.String("admin-client-ca", "", "CA of the client certificates that may call the admin service, needs -tls-cert; admins can also send a token with the admin role")
+	certInterval := flag.Duration("tls-reload-interval", certreload.DefaultInterval, "how often the certificate files are checked for changes")
+	flag.Parse()
+
 	fmt.Println("Blog service started")
 
-	// setup mongodb
-	// fmt.Println("connecting to mongodb")
-	// ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-	// defer cancel()
-	// client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://localhost:27017"))
-	// if err != nil {
-	// 	log.Fatalf("error while setup mongo client\n%v", err)
-	// 	return
-	// }
-	// collection = client.Database("mydb").Collection("blog")
-
-	fmt.Println("connecting to mongodb")
-	m := mongodb.MongoDB{URI: "mongodb://localhost:27017", DB: "mydb", Table: "blog"}
-	client, coll, err := m.Connect()
-	if err != nil {
-		log.Fatalf("failed to connect to mongodb\n%v\n", err)
+	if *defaultTenant != "" && !tenant.Valid(*defaultTenant) {
+		log.Fatalf("invalid default tenant %q", *defaultTenant)
 		return
 	}
-	collection = coll
+	storeOpts := repository.Options{DefaultTenant: *defaultTenant}
+	if *quotaFile != "" {
+		quotas, err := tenant.LoadQuotas(*quotaFile)
+		if err != nil {
+			log.Fatalf("failed to load tenant quotas\n%v\n", err)
+			return
+		}
+		storeOpts.Quotas = quotas
+	}
+	if *maxPosts > 0 || *maxBytes > 0 {
+		if storeOpts.Quotas == nil {
+			storeOpts.Quotas = &tenant.Quotas{}
+		}
+		if *maxPosts > 0 {
+			storeOpts.Quotas.Default.MaxPosts = *maxPosts
+		}
+		if *maxBytes > 0 {
+			storeOpts.Quotas.Default.MaxBytes = *maxBytes
+		}
+	}
+
+	var store repository.Store
+	ping := func(ctx context.Context) error { return nil }
+	var client *mongo.Client
+	switch *storeKind {
+	case "mongo":
+		fmt.Println("connecting to mongodb")
+		c, coll, err := m.Connect()
+		if err != nil {
+			log.Fatalf("failed to connect to mongodb\n%v\n", err)
+			return
+		}
+		client = c
+		ping = func(ctx context.Context) error { return m.Ping(ctx, client) }
 
-	listener, err := net.Listen("tcp", "0.0.0.0:50051")
+		mongoStore, err := repository.NewMongoStore(context.Background(), coll, storeOpts)
+		if err != nil {
+			log.Fatalf("failed to setup mongodb store\n%v\n", err)
+			return
+		}
+		if !mongoStore.SupportsTransactions() {
+			fmt.Println("mongodb is a standalone server, multi document writes are not transactional")
+		}
+		store = mongoStore
+	case "memory":
+		fmt.Println("using in-memory store")
+		store = repository.NewMemoryStore(storeOpts)
+	default:
+		log.Fatalf("unknown store %q, use mongo or memory", *storeKind)
+		return
+	}
+
+	listener, err := net.Listen("tcp", *addr)
 	if err != nil {
 		log.Fatalf("failed to setup listener \n%v\n", err)
 		return
 	}
 
-	opts := []grpc.ServerOption{}
+	// every BlogService request is scoped to a tenant, the admin and
+	// health services are not
+	resolver := &tenant.Resolver{
+		Secret:       []byte(*authSecret),
+		RequireToken: *requireToken,
+		Default:      *defaultTenant,
+		Skip: func(fullMethod string) bool {
+			return !strings.HasPrefix(fullMethod, "/blog.BlogService/")
+		},
+	}
+
+	// the admin service is only for admins, they can act on every tenant
+	// unless their token names one
+	adminGuard := &auth.AdminGuard{
+		Secret: []byte(*authSecret),
+		Match: func(fullMethod string) bool {
+			return strings.HasPrefix(fullMethod, "/blog.BlogAdminService/")
+		},
+	}
+
+	// calls that change data are audited, after the resolver so the entry
+	// gets the tenant
+	auditLogger := &audit.Logger{
+		Store:  store,
+		Secret: []byte(*authSecret),
+		Skip: func(fullMethod string) bool {
+			return !strings.HasPrefix(fullMethod, "/blog.")
+		},
+	}
+
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(resolver.UnaryServerInterceptor(), adminGuard.UnaryServerInterceptor(), auditLogger.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(resolver.StreamServerInterceptor(), adminGuard.StreamServerInterceptor(), auditLogger.StreamServerInterceptor()),
+	}
+	// the certificate is reloaded when it is rotated, the expiry is in the
+	// expvar metrics of the HTTP server
+	certsCtx, stopCerts := context.WithCancel(context.Background())
+	defer stopCerts()
+	if *adminCA != "" && *certFile == "" {
+		log.Fatalf("-admin-client-ca needs -tls-cert")
+		return
+	}
+	if *certFile != "" {
+		certs, err := certreload.New(*certFile, *keyFile, *adminCA)
+		if err != nil {
+			log.Fatalf("failed loading certificate \n%v\n", err)
+			return
+		}
+		// only admins need a client certificate
+		certs.ClientCertOptional = true
+		certs.Interval = *certInterval
+		go certs.Run(certsCtx)
+		opts = append(opts, grpc.Creds(credentials.NewTLS(certs.Config())))
+	}
 	server := grpc.NewServer(opts...)
 
-	domain.RegisterBlogServiceServer(server, &Server{})
+	viewCounter := &views.Counter{Store: store, FlushInterval: *viewFlush}
+	viewsCtx, stopViews := context.WithCancel(context.Background())
+	viewsDone := make(chan struct{})
+	go func() {
+		viewCounter.Run(viewsCtx)
+		close(viewsDone)
+	}()
+
+	// the related posts index lives in memory, fill it from the store
+	relatedIndex := &related.Index{}
+	if err := relatedIndex.Load(context.Background(), store.Scan); err != nil {
+		log.Fatalf("failed to load related posts index\n%v\n", err)
+		return
+	}
+
+	// every write goes through the moderation checks before it is stored
+	pipeline := &moderation.Pipeline{}
+	if *bannedWords != "" {
+		words, err := moderation.LoadWords(*bannedWords)
+		if err != nil {
+			log.Fatalf("failed to load banned words\n%v\n", err)
+			return
+		}
+		pipeline.Checks = append(pipeline.Checks, &moderation.BannedWords{Words: words, Action: moderation.Reject})
+	}
+	if *maxLinks >= 0 {
+		pipeline.Checks = append(pipeline.Checks, &moderation.LinkLimit{Max: *maxLinks, Action: moderation.Review})
+	}
+	if *duplicateThreshold > 0 {
+		duplicates := &moderation.DuplicateContent{Threshold: *duplicateThreshold, Action: moderation.Review}
+		err := store.Scan(context.Background(), func(item *repository.BlogItem) error {
+			duplicates.Upsert(item)
+			return nil
+		})
+		if err != nil {
+			log.Fatalf("failed to load duplicate content index\n%v\n", err)
+			return
+		}
+		pipeline.Checks = append(pipeline.Checks, duplicates)
+	}
+
+	// snapshots of every tenant, written to a local directory
+	var snapshotter *backup.Snapshotter
+	backupCtx, stopBackups := context.WithCancel(context.Background())
+	defer stopBackups()
+	if *backupDir != "" {
+		snapshotter = &backup.Snapshotter{
+			Store:    store,
+			Dir:      *backupDir,
+			Interval: *backupInterval,
+			Keep:     *backupKeep,
+			MaxAge:   *backupMaxAge,
+		}
+		go snapshotter.Run(backupCtx)
+	}
+
+	blogServer := &Server{
+		store:        store,
+		views:        viewCounter,
+		related:      relatedIndex,
+		moderation:   pipeline,
+		authSecret:   []byte(*authSecret),
+		requireToken: *requireToken,
+		listRate:     *listRate,
+	}
+	// live editing sessions are saved through the server, like an update
+	edits := &liveedit.Manager{Save: blogServer.saveEdit, PersistInterval: *editSave}
+	blogServer.edits = edits
+	editsCtx, stopEdits := context.WithCancel(context.Background())
+	editsDone := make(chan struct{})
+	go func() {
+		edits.Run(editsCtx)
+		close(editsDone)
+	}()
+	domain.RegisterBlogServiceServer(server, blogServer)
+	domain.RegisterBlogAdminServiceServer(server, &AdminServer{
+		store:         store,
+		blogs:         blogServer,
+		defaultTenant: *defaultTenant,
+		backups:       snapshotter,
+	})
+
+	// health service follows the mongodb connection
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+	healthCtx, stopHealth := context.WithCancel(context.Background())
+	defer stopHealth()
+	go watchHealth(healthCtx, healthServer, *healthInterval, ping)
+
+	// deliver outbox events to the registered webhooks
+	dispatcher := &outbox.Dispatcher{
+		Store:          store,
+		PollInterval:   *webhookPoll,
+		MaxAttempts:    *webhookAttempts,
+		InitialBackoff: *webhookBackoff,
+		Retention:      *webhookRetention,
+	}
+	dispatcherCtx, stopDispatcher := context.WithCancel(context.Background())
+	defer stopDispatcher()
+	go dispatcher.Run(dispatcherCtx)
+
+	// feeds and sitemap are served over plain HTTP next to the gRPC server
+	var httpServer *http.Server
+	if *httpAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/debug/vars", expvar.Handler())
+		mux.Handle("/", &feed.Handler{
+			Store:         store,
+			BaseURL:       *publicURL,
+			Title:         *feedTitle,
+			DefaultTenant: *defaultTenant,
+		})
+		httpServer = &http.Server{Addr: *httpAddr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			fmt.Println("starting http server on", *httpAddr)
+			if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("failed to serve http \n%v\n", err)
+			}
+		}()
+	}
 
 	go func() {
 		fmt.Println("starting server")
@@ -261,13 +825,53 @@ func main() {
 	// block until a signal is received
 	<-ch
 	fmt.Println("stopping the server")
+	healthServer.Shutdown()
 	server.Stop()
+	if httpServer != nil {
+		httpServer.Close()
+	}
+	stopDispatcher()
+	stopBackups()
+
+	// save the open editing sessions
+	stopEdits()
+	<-editsDone
+
+	// write the views counted since the last flush
+	stopViews()
+	<-viewsDone
 
 	fmt.Println("closing the listener")
 	listener.Close()
 
-	fmt.Println("closeing mongodb")
-	client.Disconnect(context.Background())
+	if client != nil {
+		fmt.Println("closeing mongodb")
+		if err := m.Disconnect(client); err != nil {
+			log.Printf("error while disconnect mongodb: %v", err)
+		}
+	}
 
 	fmt.Println("end of program")
 }
+
+// watchHealth pings the database every interval and reports the result on the health service.
+func watchHealth(ctx context.Context, healthServer *health.Server, interval time.Duration, ping func(context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		servingStatus := healthpb.HealthCheckResponse_SERVING
+		if err := ping(ctx); err != nil {
+			log.Printf("health check failed: %v", err)
+			servingStatus = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		healthServer.SetServingStatus("", servingStatus)
+		healthServer.SetServingStatus("blog.BlogService", servingStatus)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"learn-grpc/blog/auth"
+	"learn-grpc/blog/domain"
+	"learn-grpc/blog/moderation"
+	"learn-grpc/blog/related"
+	"learn-grpc/blog/repository"
+	"learn-grpc/blog/tenant"
+	"learn-grpc/blog/views"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// newTestServer returns a server on a memory store. Posts with "spam" are
+// rejected, posts with more than one link are held for review.
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	store := repository.NewMemoryStore(repository.Options{DefaultTenant: "default"})
+	return &Server{
+		store:   store,
+		views:   &views.Counter{Store: store},
+		related: &related.Index{},
+		moderation: &moderation.Pipeline{Checks: []moderation.Check{
+			&moderation.BannedWords{Words: []string{"spam"}, Action: moderation.Reject},
+			&moderation.LinkLimit{Max: 1, Action: moderation.Review},
+		}},
+	}
+}
+
+// as returns the context of a call of user in tenant acme, an empty user
+// makes an anonymous call
+func as(user string) context.Context {
+	ctx := tenant.NewContext(context.Background(), "acme")
+	if user == "" {
+		return ctx
+	}
+	return metadata.NewIncomingContext(ctx, metadata.Pairs(auth.UserMetadataKey, user))
+}
+
+// create makes a post owned by user
+func create(t *testing.T, s *Server, user string, blog *domain.Blog) *domain.Blog {
+	t.Helper()
+	res, err := s.CreateBlog(as(user), &domain.CreateBlogRequest{Blog: blog})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.GetBlog()
+}
+
+func TestUpdateBlogClearsTags(t *testing.T) {
+	s := newTestServer(t)
+	created := create(t, s, "fajar", &domain.Blog{AuthorId: "fajar", Title: "hello", Content: "hello world", Tags: []string{"go", "grpc"}})
+
+	tests := []struct {
+		name string
+		tags []string
+	}{
+		{name: "replaced", tags: []string{"rust"}},
+		{name: "cleared", tags: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			update := &domain.Blog{Id: created.GetId(), AuthorId: "fajar", Title: "hello", Content: "hello world", Tags: tt.tags}
+			if _, err := s.UpdateBlog(as("fajar"), &domain.UpdateBlogRequest{Blog: update}); err != nil {
+				t.Fatal(err)
+			}
+			read, err := s.store.Blogs().FindByID(as("fajar"), created.GetId())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(read.Tags) != len(tt.tags) || (len(tt.tags) > 0 && !reflect.DeepEqual(read.Tags, tt.tags)) {
+				t.Errorf("tags = %v, want %v", read.Tags, tt.tags)
+			}
+		})
+	}
+}
+
+// listStream collects the posts and the trailer sent by ListBlog
+type listStream struct {
+	grpc.ServerStream
+	ctx     context.Context
+	blogs   []*domain.Blog
+	trailer metadata.MD
+}
+
+func (s *listStream) Context() context.Context  { return s.ctx }
+func (s *listStream) SetTrailer(md metadata.MD) { s.trailer = md }
+func (s *listStream) Send(res *domain.ListBlogResponse) error {
+	s.blogs = append(s.blogs, res.GetBlog())
+	return nil
+}
+
+func TestListBlogHidesUnpublishedPosts(t *testing.T) {
+	s := newTestServer(t)
+	create(t, s, "fajar", &domain.Blog{Title: "published", Content: "hello world"})
+	create(t, s, "fajar", &domain.Blog{Title: "draft", Content: "hello world", Status: domain.BlogStatus_BLOG_STATUS_DRAFT})
+	create(t, s, "fajar", &domain.Blog{Title: "held", Content: "see https://a.example and https://b.example"})
+	shared := create(t, s, "fajar", &domain.Blog{Title: "shared draft", Content: "hello world", Status: domain.BlogStatus_BLOG_STATUS_DRAFT})
+	if _, err := s.AddContributor(as("fajar"), &domain.AddContributorRequest{BlogId: shared.GetId(), UserId: "sari", Role: domain.ContributorRole_CONTRIBUTOR_ROLE_VIEWER}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		user   string
+		titles []string
+	}{
+		{name: "anonymous", user: "", titles: []string{"published"}},
+		{name: "stranger", user: "budi", titles: []string{"published"}},
+		{name: "viewer", user: "sari", titles: []string{"published", "shared draft"}},
+		{name: "owner", user: "fajar", titles: []string{"published", "draft", "held", "shared draft"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := &listStream{ctx: as(tt.user)}
+			if err := s.ListBlog(&domain.ListBlogRequest{}, stream); err != nil {
+				t.Fatal(err)
+			}
+			var titles []string
+			for _, blog := range stream.blogs {
+				titles = append(titles, blog.GetTitle())
+			}
+			if !reflect.DeepEqual(titles, tt.titles) {
+				t.Errorf("listed %v, want %v", titles, tt.titles)
+			}
+		})
+	}
+}
+
+func TestCreateBlogOwner(t *testing.T) {
+	tests := []struct {
+		name   string
+		user   string
+		author string
+		code   codes.Code
+		want   string
+	}{
+		{name: "anonymous", author: "fajar", code: codes.Unauthenticated},
+		{name: "caller owns the post", user: "fajar", author: "Fajar R.", want: "Fajar R."},
+		{name: "caller is the author by default", user: "fajar", want: "fajar"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			res, err := s.CreateBlog(as(tt.user), &domain.CreateBlogRequest{Blog: &domain.Blog{AuthorId: tt.author, Title: "hello", Content: "hello world"}})
+			if code := status.Code(err); code != tt.code {
+				t.Fatalf("code = %s, want %s (%v)", code, tt.code, err)
+			}
+			if err != nil {
+				return
+			}
+			data, err := s.store.Blogs().FindByID(as(tt.user), res.GetBlog().GetId())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data.AuthorID != tt.want || data.RoleOf(tt.user) != repository.RoleOwner {
+				t.Errorf("author %q, role of %s %q, want author %q and the owner role", data.AuthorID, tt.user, data.RoleOf(tt.user), tt.want)
+			}
+		})
+	}
+}
+
+func TestUnpublishedPostsAreNotFound(t *testing.T) {
+	s := newTestServer(t)
+	published := create(t, s, "fajar", &domain.Blog{Title: "published", Content: "hello world"})
+	draft := create(t, s, "fajar", &domain.Blog{Title: "draft", Content: "hello world", Status: domain.BlogStatus_BLOG_STATUS_DRAFT})
+	if _, err := s.AddContributor(as("fajar"), &domain.AddContributorRequest{BlogId: draft.GetId(), UserId: "sari", Role: domain.ContributorRole_CONTRIBUTOR_ROLE_VIEWER}); err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(blog *domain.Blog) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			_, err := s.ReadBlog(ctx, &domain.ReadBlogRequest{BlogId: blog.GetId()})
+			return err
+		}
+	}
+	update := func(blog *domain.Blog) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			_, err := s.UpdateBlog(ctx, &domain.UpdateBlogRequest{Blog: &domain.Blog{Id: blog.GetId(), Title: "taken over", Status: domain.BlogStatus_BLOG_STATUS_DRAFT}})
+			return err
+		}
+	}
+	tests := []struct {
+		name string
+		user string
+		call func(ctx context.Context) error
+		code codes.Code
+	}{
+		{name: "anonymous reads a draft", call: read(draft), code: codes.NotFound},
+		{name: "stranger reads a draft", user: "budi", call: read(draft), code: codes.NotFound},
+		{name: "stranger updates a draft", user: "budi", call: update(draft), code: codes.NotFound},
+		{name: "viewer reads a draft", user: "sari", call: read(draft)},
+		{name: "viewer updates a draft", user: "sari", call: update(draft), code: codes.PermissionDenied},
+		{name: "stranger reads a published post", user: "budi", call: read(published)},
+		{name: "stranger updates a published post", user: "budi", call: update(published), code: codes.PermissionDenied},
+		{name: "anonymous updates a published post", call: update(published), code: codes.Unauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := status.Code(tt.call(as(tt.user))); code != tt.code {
+				t.Errorf("code = %s, want %s", code, tt.code)
+			}
+		})
+	}
+}
+
+func TestCallerWithTokenSecret(t *testing.T) {
+	secret := []byte("s3cret")
+	token, err := auth.SignToken(&auth.Claims{Subject: "fajar", TenantID: "acme"}, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		secret []byte
+		md     metadata.MD
+		want   string
+	}{
+		{name: "x-user-id without a secret", md: metadata.Pairs(auth.UserMetadataKey, "fajar"), want: "fajar"},
+		{name: "x-user-id with a secret", secret: secret, md: metadata.Pairs(auth.UserMetadataKey, "fajar")},
+		{name: "token", secret: secret, md: metadata.Pairs("authorization", "Bearer "+token), want: "fajar"},
+		{name: "token and another x-user-id", secret: secret, md: metadata.Pairs("authorization", "Bearer "+token, auth.UserMetadataKey, "budi"), want: "fajar"},
+		{name: "token signed with another secret", secret: []byte("other"), md: metadata.Pairs("authorization", "Bearer "+token)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{authSecret: tt.secret}
+			if got := s.caller(metadata.NewIncomingContext(context.Background(), tt.md)); got != tt.want {
+				t.Errorf("caller = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
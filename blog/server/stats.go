@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"learn-grpc/blog/domain"
+	"learn-grpc/blog/repository"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *Server) GetBlogStats(ctx context.Context, req *domain.GetBlogStatsRequest) (*domain.GetBlogStatsResponse, error) {
+	fmt.Println("GetBlogStats\n", req)
+
+	filter := repository.StatsFilter{
+		Status: statusFromPb(req.GetStatus(), repository.StatusPublished),
+		Limit:  int(req.GetLimit()),
+	}
+	if req.GetAllStatuses() {
+		filter.Status = ""
+	}
+	if req.GetSince() != nil {
+		filter.Since = req.GetSince().AsTime()
+	}
+	if req.GetUntil() != nil {
+		filter.Until = req.GetUntil().AsTime()
+	}
+	if !filter.Since.IsZero() && !filter.Until.IsZero() && !filter.Since.Before(filter.Until) {
+		return nil, status.Errorf(codes.InvalidArgument, "since must be before until")
+	}
+
+	stats, err := s.store.Blogs().Stats(ctx, filter)
+	if err != nil {
+		return nil, storeError(err)
+	}
+
+	return &domain.GetBlogStatsResponse{
+		Total:   bucketToPb(stats.Total),
+		Authors: bucketsToPb(stats.Authors),
+		Tags:    bucketsToPb(stats.Tags),
+		Months:  bucketsToPb(stats.Months),
+	}, nil
+}
+
+func bucketToPb(b repository.StatsBucket) *domain.BlogStatsBucket {
+	return &domain.BlogStatsBucket{
+		Key:          b.Key,
+		Posts:        b.Posts,
+		Words:        b.Words,
+		AverageWords: b.AverageWords(),
+	}
+}
+
+func bucketsToPb(buckets []repository.StatsBucket) []*domain.BlogStatsBucket {
+	res := make([]*domain.BlogStatsBucket, 0, len(buckets))
+	for _, b := range buckets {
+		res = append(res, bucketToPb(b))
+	}
+	return res
+}
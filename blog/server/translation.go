@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"learn-grpc/blog/domain"
+	"learn-grpc/blog/locale"
+	"learn-grpc/blog/repository"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *Server) PutBlogTranslation(ctx context.Context, req *domain.PutBlogTranslationRequest) (*domain.PutBlogTranslationResponse, error) {
+	fmt.Println("PutBlogTranslation\n", req)
+
+	tag, err := locale.Normalize(req.GetLanguage())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "language: %v", err)
+	}
+	if req.GetTitle() == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "title is required")
+	}
+
+	var created bool
+	data, err := s.changeBlog(ctx, req.GetBlogId(), repository.RoleEditor, func(data *repository.BlogItem) error {
+		if tag == data.Language {
+			return status.Errorf(codes.InvalidArgument, "blog %s is written in %s, update the blog instead", req.GetBlogId(), tag)
+		}
+
+		// the translation goes through the same checks as the post, a hold
+		// puts the whole post on hold
+		translated := *data
+		translated.Title, translated.Content = req.GetTitle(), req.GetContent()
+		if err := s.moderate(ctx, &translated); err != nil {
+			return err
+		}
+		data.Status, data.ModerationNote = translated.Status, translated.ModerationNote
+
+		_, exists := data.Translations[tag]
+		created = !exists
+		if data.Translations == nil {
+			data.Translations = map[string]repository.Translation{}
+		}
+		data.Translations[tag] = repository.Translation{
+			Title:     req.GetTitle(),
+			Content:   req.GetContent(),
+			UpdatedAt: time.Now(),
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &domain.PutBlogTranslationResponse{Blog: dataToBlogPb(data), Created: created}, nil
+}
+
+func (s *Server) DeleteBlogTranslation(ctx context.Context, req *domain.DeleteBlogTranslationRequest) (*domain.DeleteBlogTranslationResponse, error) {
+	fmt.Println("DeleteBlogTranslation\n", req)
+
+	tag, err := locale.Normalize(req.GetLanguage())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "language: %v", err)
+	}
+
+	data, err := s.changeBlog(ctx, req.GetBlogId(), repository.RoleEditor, func(data *repository.BlogItem) error {
+		if _, ok := data.Translations[tag]; !ok {
+			return status.Errorf(codes.NotFound, "blog %s has no %s translation", req.GetBlogId(), tag)
+		}
+		delete(data.Translations, tag)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &domain.DeleteBlogTranslationResponse{Blog: dataToBlogPb(data)}, nil
+}
+
+// languageFromPb normalizes the language of a written post, empty keeps
+// current. It may not be the language of one of the translations.
+func languageFromPb(language, current string, translations map[string]repository.Translation) (string, error) {
+	if language == "" {
+		return current, nil
+	}
+	tag, err := locale.Normalize(language)
+	if err != nil {
+		return "", status.Errorf(codes.InvalidArgument, "language: %v", err)
+	}
+	if _, ok := translations[tag]; ok {
+		return "", status.Errorf(codes.InvalidArgument, "the blog has a %s translation, remove it first", tag)
+	}
+	return tag, nil
+}
+
+// localize returns the post in the language that best matches the accept
+// language preference, the stored post is not changed
+func localize(data *repository.BlogItem, accept string) *repository.BlogItem {
+	tag := locale.Negotiate(accept, data.Language, data.Languages())
+	translation, ok := data.Translations[tag]
+	if !ok || tag == data.Language {
+		return data
+	}
+	localized := *data
+	localized.Title, localized.Content, localized.Language = translation.Title, translation.Content, tag
+	return &localized
+}
@@ -0,0 +1,61 @@
+package tenant
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Quota limits what one tenant can store, zero means unlimited
+type Quota struct {
+	MaxPosts int64 `json:"max_posts"`
+	// MaxBytes is the total size of titles and contents
+	MaxBytes int64 `json:"max_bytes"`
+}
+
+// Quotas holds the default quota and the overrides per tenant
+type Quotas struct {
+	Default Quota            `json:"default"`
+	Tenants map[string]Quota `json:"tenants"`
+}
+
+// For returns the quota of a tenant
+func (q *Quotas) For(id string) Quota {
+	if quota, ok := q.Tenants[id]; ok {
+		return quota
+	}
+	return q.Default
+}
+
+// Allows reports whether a tenant that has posts and bytes stored can store
+// one more post (newPosts = 1) or grow by the given bytes
+func (q Quota) Allows(posts, bytes, newPosts, newBytes int64) bool {
+	if q.MaxPosts > 0 && newPosts > 0 && posts+newPosts > q.MaxPosts {
+		return false
+	}
+	if q.MaxBytes > 0 && newBytes > 0 && bytes+newBytes > q.MaxBytes {
+		return false
+	}
+	return true
+}
+
+// LoadQuotas reads quotas from a JSON file such as
+//
+//	{"default": {"max_posts": 1000}, "tenants": {"team-a": {"max_posts": 10, "max_bytes": 1048576}}}
+func LoadQuotas(file string) (*Quotas, error) {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("tenant: read quotas: %w", err)
+	}
+
+	q := new(Quotas)
+	if err := json.Unmarshal(raw, q); err != nil {
+		return nil, fmt.Errorf("tenant: parse quotas %s: %w", file, err)
+	}
+	for id := range q.Tenants {
+		if !Valid(id) {
+			return nil, fmt.Errorf("tenant: invalid tenant id %q in %s", id, file)
+		}
+	}
+	return q, nil
+}
@@ -0,0 +1,140 @@
+// Package tenant carries the tenant of a request. Every blog post belongs to
+// one tenant and the repository only reads and writes the posts of the tenant
+// found in the context.
+package tenant
+
+import (
+	"context"
+	"errors"
+	"learn-grpc/blog/auth"
+	"regexp"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// MetadataKey is the grpc metadata key a client can send its tenant in
+const MetadataKey = "x-tenant-id"
+
+// ErrMissing is returned by code that needs a tenant but got a context without one
+var ErrMissing = errors.New("tenant: no tenant in context")
+
+var validID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the tenant id
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the tenant id stored by NewContext
+func FromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(contextKey{}).(string)
+	return id, ok && id != ""
+}
+
+// Valid reports whether id can be used as a tenant id
+func Valid(id string) bool {
+	return validID.MatchString(id)
+}
+
+// Resolver finds the tenant of an incoming request. The tenant_id claim of a
+// verified bearer token wins, the x-tenant-id metadata is accepted when it
+// matches the token or when no token is sent and RequireToken is false.
+type Resolver struct {
+	// Secret verifies bearer tokens, without it tokens are ignored
+	Secret []byte
+	// RequireToken rejects requests without a token naming their tenant,
+	// they do not fall back to the metadata or Default. Without Secret every
+	// request is rejected.
+	RequireToken bool
+	// Default is used when the request names no tenant and no token is
+	// required, empty rejects them
+	Default string
+	// Skip reports whether a method does not need a tenant, e.g. health checks
+	Skip func(fullMethod string) bool
+}
+
+// Resolve returns the tenant of the request in ctx
+func (r *Resolver) Resolve(ctx context.Context) (string, error) {
+	var fromToken string
+	if len(r.Secret) > 0 {
+		claims, err := auth.FromIncomingContext(ctx, r.Secret)
+		if err != nil {
+			return "", status.Errorf(codes.Unauthenticated, "invalid bearer token: %v", err)
+		}
+		if claims != nil {
+			fromToken = claims.TenantID
+		}
+	}
+
+	var fromHeader string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(MetadataKey); len(values) > 0 {
+			fromHeader = values[0]
+		}
+	}
+
+	var id string
+	switch {
+	case fromToken != "" && fromHeader != "" && fromHeader != fromToken:
+		return "", status.Error(codes.PermissionDenied, "tenant in metadata does not match the token")
+	case fromToken != "":
+		id = fromToken
+	case r.RequireToken:
+		return "", status.Error(codes.Unauthenticated, "a bearer token with a tenant_id claim is required")
+	case fromHeader != "":
+		id = fromHeader
+	default:
+		id = r.Default
+	}
+
+	if id == "" {
+		return "", status.Errorf(codes.Unauthenticated, "missing tenant, send a token or %s metadata", MetadataKey)
+	}
+	if !Valid(id) {
+		return "", status.Errorf(codes.InvalidArgument, "invalid tenant id %q", id)
+	}
+	return id, nil
+}
+
+// UnaryServerInterceptor stores the tenant of every unary request in its context
+func (r *Resolver) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if r.Skip != nil && r.Skip(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		id, err := r.Resolve(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return handler(NewContext(ctx, id), req)
+	}
+}
+
+// StreamServerInterceptor stores the tenant of every stream in its context
+func (r *Resolver) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if r.Skip != nil && r.Skip(info.FullMethod) {
+			return handler(srv, ss)
+		}
+		id, err := r.Resolve(ss.Context())
+		if err != nil {
+			return err
+		}
+		return handler(srv, &serverStream{ServerStream: ss, ctx: NewContext(ss.Context(), id)})
+	}
+}
+
+// serverStream replaces the context of a grpc.ServerStream
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
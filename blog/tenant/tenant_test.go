@@ -0,0 +1,55 @@
+package tenant
+
+import (
+	"context"
+	"learn-grpc/blog/auth"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestResolve(t *testing.T) {
+	secret := []byte("s3cret")
+	token := func(tenantID string) string {
+		signed, err := auth.SignToken(&auth.Claims{Subject: "fajar", TenantID: tenantID}, secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return "Bearer " + signed
+	}
+
+	tests := []struct {
+		name     string
+		resolver Resolver
+		md       metadata.MD
+		want     string
+		code     codes.Code
+	}{
+		{name: "metadata", resolver: Resolver{Secret: secret}, md: metadata.Pairs(MetadataKey, "acme"), want: "acme"},
+		{name: "default", resolver: Resolver{Secret: secret, Default: "default"}, want: "default"},
+		{name: "no tenant and no default", resolver: Resolver{Secret: secret}, code: codes.Unauthenticated},
+		{name: "token", resolver: Resolver{Secret: secret}, md: metadata.Pairs("authorization", token("acme")), want: "acme"},
+		{name: "token and matching metadata", resolver: Resolver{Secret: secret}, md: metadata.Pairs("authorization", token("acme"), MetadataKey, "acme"), want: "acme"},
+		{name: "token and other metadata", resolver: Resolver{Secret: secret}, md: metadata.Pairs("authorization", token("acme"), MetadataKey, "globex"), code: codes.PermissionDenied},
+		{name: "token without a secret", resolver: Resolver{Default: "default"}, md: metadata.Pairs("authorization", token("acme")), want: "default"},
+		{name: "invalid token", resolver: Resolver{Secret: []byte("other")}, md: metadata.Pairs("authorization", token("acme")), code: codes.Unauthenticated},
+		{name: "invalid tenant", resolver: Resolver{Secret: secret}, md: metadata.Pairs(MetadataKey, "acme corp"), code: codes.InvalidArgument},
+		{name: "required token", resolver: Resolver{Secret: secret, RequireToken: true}, md: metadata.Pairs("authorization", token("acme")), want: "acme"},
+		{name: "required token, metadata only", resolver: Resolver{Secret: secret, RequireToken: true, Default: "default"}, md: metadata.Pairs(MetadataKey, "acme"), code: codes.Unauthenticated},
+		{name: "required token, nothing sent", resolver: Resolver{Secret: secret, RequireToken: true, Default: "default"}, code: codes.Unauthenticated},
+		{name: "required token, token without a tenant", resolver: Resolver{Secret: secret, RequireToken: true, Default: "default"}, md: metadata.Pairs("authorization", token("")), code: codes.Unauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.resolver.Resolve(metadata.NewIncomingContext(context.Background(), tt.md))
+			if code := status.Code(err); code != tt.code {
+				t.Fatalf("code = %s, want %s (%v)", code, tt.code, err)
+			}
+			if got != tt.want {
+				t.Errorf("tenant = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
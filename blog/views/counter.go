@@ -0,0 +1,101 @@
+// Package views batches view count increments in memory and flushes them
+// with one atomic increment per post, so a hot post costs one write per
+// flush interval instead of one write per read.
+package views
+
+import (
+	"context"
+	"errors"
+	"learn-grpc/blog/repository"
+	"learn-grpc/blog/tenant"
+	"log"
+	"sync"
+	"time"
+)
+
+// DefaultFlushInterval is used when Counter.FlushInterval is zero
+const DefaultFlushInterval = 5 * time.Second
+
+type key struct {
+	tenantID string
+	blogID   string
+}
+
+// Counter collects views until the next flush. Views that were not flushed
+// yet are lost when the process dies.
+type Counter struct {
+	Store         repository.Store
+	FlushInterval time.Duration
+
+	mu      sync.Mutex
+	pending map[key]int64
+}
+
+// Add counts one view of a post of the tenant in ctx
+func (c *Counter) Add(ctx context.Context, blogID string) {
+	tenantID, ok := tenant.FromContext(ctx)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pending == nil {
+		c.pending = map[key]int64{}
+	}
+	c.pending[key{tenantID, blogID}]++
+}
+
+// Pending returns the views of a post that are not flushed yet, add it to
+// the stored count to show an up to date number
+func (c *Counter) Pending(ctx context.Context, blogID string) int64 {
+	tenantID, _ := tenant.FromContext(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pending[key{tenantID, blogID}]
+}
+
+// Run flushes every FlushInterval until ctx is canceled, then flushes one last time
+func (c *Counter) Run(ctx context.Context) {
+	interval := c.FlushInterval
+	if interval <= 0 {
+		interval = DefaultFlushInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			c.Flush(context.Background())
+			return
+		case <-ticker.C:
+			c.Flush(ctx)
+		}
+	}
+}
+
+// Flush writes the pending views, counts that fail to be written are kept
+// for the next flush unless the post is gone
+func (c *Counter) Flush(ctx context.Context) {
+	c.mu.Lock()
+	batch := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+
+	for k, n := range batch {
+		err := c.Store.Blogs().IncrementViews(tenant.NewContext(ctx, k.tenantID), k.blogID, n)
+		if err == nil || errors.Is(err, repository.ErrNotFound) {
+			continue
+		}
+
+		log.Printf("views: flush %d views of %s: %v", n, k.blogID, err)
+		c.mu.Lock()
+		if c.pending == nil {
+			c.pending = map[key]int64{}
+		}
+		c.pending[k] += n
+		c.mu.Unlock()
+	}
+}
@@ -2,14 +2,19 @@ package main
 
 import (
 	"context"
+	"expvar"
+	"flag"
 	"fmt"
 	"io"
 	"learn-grpc/calculator/pb"
+	"learn-grpc/internal/certreload"
 	"log"
 	"math"
 	"net"
+	"net/http"
 
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/reflection"
 	"google.golang.org/grpc/status"
 
@@ -20,7 +25,7 @@ type ServerCalculator struct{}
 
 // Calculate is unary
 func (s *ServerCalculator) Calculate(ctx context.Context, req *pb.CalculatorRequest) (*pb.CalculatorResponse, error) {
-	fmt.Println("receive request: %v", req)
+	fmt.Printf("receive request: %v\n", req)
 	numb1 := req.GetCalculator().GetNumber_1()
 	numb2 := req.GetCalculator().GetNumber_2()
 
@@ -44,7 +49,7 @@ func (s *ServerCalculator) PrimeNumberDecomposition(req *pb.PrimeNumberDecomposi
 			number = number / divisor
 		} else {
 			divisor++
-			fmt.Println("divisor has increased to %v \n", divisor)
+			fmt.Printf("divisor has increased to %v \n", divisor)
 		}
 	}
 
@@ -111,6 +116,12 @@ func (s *ServerCalculator) SquareRoot(ctx context.Context, req *pb.SquareRootReq
 }
 
 func main() {
+	certFile := flag.String("tls-cert", "", "server certificate, reloaded when it changes; empty serves without TLS")
+	keyFile := flag.String("tls-key", "", "key of the server certificate")
+	certInterval := flag.Duration("tls-reload-interval", certreload.DefaultInterval, "how often the certificate files are checked for changes")
+	metricsAddr := flag.String("metrics-addr", "", "address serving expvar metrics such as the certificate expiry, empty for none")
+	flag.Parse()
+
 	fmt.Println("server was started")
 
 	lis, err := net.Listen("tcp", "0.0.0.0:50051")
@@ -118,7 +129,26 @@ func main() {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
-	server := grpc.NewServer()
+	opts := []grpc.ServerOption{}
+	if *certFile != "" {
+		certs, err := certreload.New(*certFile, *keyFile, "")
+		if err != nil {
+			log.Fatalf("failed loading certificate: %v", err)
+		}
+		certs.Interval = *certInterval
+		go certs.Run(context.Background())
+		opts = append(opts, grpc.Creds(credentials.NewTLS(certs.Config())))
+	}
+
+	if *metricsAddr != "" {
+		go func() {
+			if err := http.ListenAndServe(*metricsAddr, expvar.Handler()); err != nil {
+				log.Fatalf("failed to serve metrics: %v", err)
+			}
+		}()
+	}
+
+	server := grpc.NewServer(opts...)
 	pb.RegisterCalculatorServiceServer(server, &ServerCalculator{})
 
 	// register reflection service on gRPC server
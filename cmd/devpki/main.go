@@ -0,0 +1,348 @@
+// Command devpki makes the certificates of the development setup: a CA, a
+// server certificate and a client certificate, written in the layout the
+// servers and clients read by default:
+//
+//	ssl/ca.crt      CA certificate, clients and servers trust it
+//	ssl/ca.key      CA key, only devpki needs it
+//	ssl/server.crt  server certificate
+//	ssl/server.pem  server key
+//	ssl/client.crt  client certificate the greet server asks for
+//	ssl/client.pem  client key
+//
+// An existing CA is reused so certificates made before keep working, -new-ca
+// replaces it. The CA key made by the old openssl steps is encrypted, pass
+// its password with -ca-pass.
+//
+//	go run ./cmd/devpki -hosts localhost,127.0.0.1,::1 -client-cn fajar
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// day is the unit of the validity flags
+const day = 24 * time.Hour
+
+type options struct {
+	dir       string
+	keyType   string
+	rsaBits   int
+	newCA     bool
+	caPass    string
+	caCN      string
+	caDays    int
+	days      int
+	hosts     []string
+	clientCN  string
+	clientSAN []string
+}
+
+func main() {
+	var o options
+	var hosts, clientSAN string
+	flag.StringVar(&o.dir, "dir", "ssl", "directory the files are written to")
+	flag.StringVar(&o.keyType, "key", "ecdsa", "key type of new keys: ecdsa (P-256) or rsa")
+	flag.IntVar(&o.rsaBits, "rsa-bits", 2048, "size of new rsa keys")
+	flag.BoolVar(&o.newCA, "new-ca", false, "make a new CA even when dir has one, certificates of the old CA stop working")
+	flag.StringVar(&o.caPass, "ca-pass", "", "password of an encrypted CA key")
+	flag.StringVar(&o.caCN, "ca-cn", "learn-grpc development CA", "common name of a new CA")
+	flag.IntVar(&o.caDays, "ca-days", 3650, "days a new CA is valid")
+	flag.IntVar(&o.days, "days", 365, "days the server and client certificates are valid")
+	flag.StringVar(&hosts, "hosts", "localhost,127.0.0.1,::1", "comma separated host names and IPs of the server certificate, the first one is its common name")
+	flag.StringVar(&o.clientCN, "client-cn", "fajar", "common name of the client certificate, the greet server greets the client with it; empty makes no client certificate")
+	flag.StringVar(&clientSAN, "client-san", "", "comma separated email addresses and URIs of the client certificate, the first one is used as the name of the client instead of the common name")
+	flag.Parse()
+	o.hosts = split(hosts)
+	o.clientSAN = split(clientSAN)
+
+	if err := run(o); err != nil {
+		log.Fatalf("devpki: %v", err)
+	}
+}
+
+func split(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func run(o options) error {
+	if o.keyType != "ecdsa" && o.keyType != "rsa" {
+		return fmt.Errorf("unknown key type %q, use ecdsa or rsa", o.keyType)
+	}
+	if len(o.hosts) == 0 {
+		return errors.New("the server certificate needs at least one host")
+	}
+	if err := os.MkdirAll(o.dir, 0o755); err != nil {
+		return err
+	}
+
+	var ca *x509.Certificate
+	var caKey crypto.Signer
+	err := os.ErrNotExist
+	if !o.newCA {
+		ca, caKey, err = loadCA(o)
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		ca, caKey, err = makeCA(o)
+	}
+	if err != nil {
+		return err
+	}
+
+	server := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: o.hosts[0]},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range o.hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			server.IPAddresses = append(server.IPAddresses, ip)
+		} else {
+			server.DNSNames = append(server.DNSNames, host)
+		}
+	}
+	if err := issue(o, "server", server, ca, caKey); err != nil {
+		return err
+	}
+
+	if o.clientCN == "" {
+		return nil
+	}
+	client := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: o.clientCN},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if err := addSANs(client, o.clientSAN); err != nil {
+		return err
+	}
+	return issue(o, "client", client, ca, caKey)
+}
+
+// addSANs adds email addresses and URIs to a certificate
+func addSANs(cert *x509.Certificate, sans []string) error {
+	for _, san := range sans {
+		if strings.Contains(san, "://") {
+			uri, err := url.Parse(san)
+			if err != nil {
+				return fmt.Errorf("client SAN %q: %w", san, err)
+			}
+			cert.URIs = append(cert.URIs, uri)
+			continue
+		}
+		if !strings.Contains(san, "@") {
+			return fmt.Errorf("client SAN %q is neither an email address nor a URI", san)
+		}
+		cert.EmailAddresses = append(cert.EmailAddresses, san)
+	}
+	return nil
+}
+
+// loadCA reads the CA of dir, os.ErrNotExist means there is none
+func loadCA(o options) (*x509.Certificate, crypto.Signer, error) {
+	certPath, keyPath := filepath.Join(o.dir, "ca.crt"), filepath.Join(o.dir, "ca.key")
+	certPEM, certErr := os.ReadFile(certPath)
+	keyPEM, keyErr := os.ReadFile(keyPath)
+	switch {
+	case errors.Is(certErr, os.ErrNotExist) && errors.Is(keyErr, os.ErrNotExist):
+		return nil, nil, os.ErrNotExist
+	case certErr != nil:
+		return nil, nil, certErr
+	case keyErr != nil:
+		return nil, nil, keyErr
+	}
+
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, nil, fmt.Errorf("no certificate in %s", certPath)
+	}
+	ca, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", certPath, err)
+	}
+	if !ca.IsCA {
+		return nil, nil, fmt.Errorf("%s is not a CA certificate, make a new one with -new-ca", certPath)
+	}
+	if time.Now().After(ca.NotAfter) {
+		return nil, nil, fmt.Errorf("the CA in %s expired on %s, make a new one with -new-ca", certPath, ca.NotAfter.Format("2006-01-02"))
+	}
+	key, err := parseKey(keyPEM, o.caPass)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", keyPath, err)
+	}
+	if !publicKeyEqual(key.Public(), ca.PublicKey) {
+		return nil, nil, fmt.Errorf("%s is not the key of %s", keyPath, certPath)
+	}
+	fmt.Printf("reusing the CA %q of %s, valid until %s\n", ca.Subject.CommonName, certPath, ca.NotAfter.Format("2006-01-02"))
+	return ca, key, nil
+}
+
+// parseKey reads a PKCS#8, PKCS#1 or SEC 1 private key, decrypting the
+// legacy encrypted PEM openssl makes
+func parseKey(data []byte, password string) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no private key")
+	}
+	der := block.Bytes
+	// the openssl steps made keys with the deprecated PEM encryption
+	if x509.IsEncryptedPEMBlock(block) {
+		if password == "" {
+			return nil, errors.New("the key is encrypted, pass its password with -ca-pass")
+		}
+		var err error
+		if der, err = x509.DecryptPEMBlock(block, []byte(password)); err != nil {
+			return nil, err
+		}
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("%T keys cannot sign certificates", key)
+		}
+		return signer, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("unknown private key format")
+}
+
+func publicKeyEqual(a, b crypto.PublicKey) bool {
+	k, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && k.Equal(b)
+}
+
+func makeCA(o options) (*x509.Certificate, crypto.Signer, error) {
+	key, err := newKey(o)
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: o.caCN},
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	if err := setValidity(template, o.caDays); err != nil {
+		return nil, nil, err
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, nil, err
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := write(o.dir, "ca", der, key); err != nil {
+		return nil, nil, err
+	}
+	fmt.Printf("made the CA %q, valid until %s\n", ca.Subject.CommonName, ca.NotAfter.Format("2006-01-02"))
+	return ca, key, nil
+}
+
+// issue makes a key and a certificate signed by the CA from template
+func issue(o options, name string, template, ca *x509.Certificate, caKey crypto.Signer) error {
+	key, err := newKey(o)
+	if err != nil {
+		return err
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	if err := setValidity(template, o.days); err != nil {
+		return err
+	}
+	// a certificate cannot outlive the CA that signed it
+	if template.NotAfter.After(ca.NotAfter) {
+		template.NotAfter = ca.NotAfter
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, key.Public(), caKey)
+	if err != nil {
+		return err
+	}
+	if err := write(o.dir, name, der, key); err != nil {
+		return err
+	}
+	fmt.Printf("made the %s certificate %q, valid until %s\n", name, template.Subject.CommonName, template.NotAfter.Format("2006-01-02"))
+	return nil
+}
+
+func newKey(o options) (crypto.Signer, error) {
+	if o.keyType == "rsa" {
+		return rsa.GenerateKey(rand.Reader, o.rsaBits)
+	}
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// setValidity gives a certificate a random serial number and makes it valid
+// from an hour ago, so clocks a little behind accept it, for days
+func setValidity(cert *x509.Certificate, days int) error {
+	if days <= 0 {
+		return fmt.Errorf("validity must be at least a day, got %d", days)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	cert.SerialNumber = serial
+	cert.NotBefore = time.Now().Add(-time.Hour)
+	cert.NotAfter = cert.NotBefore.Add(time.Duration(days) * day)
+	return nil
+}
+
+// write saves name.crt and the key, as name.key for the CA and name.pem for
+// the others like the servers expect
+func write(dir, name string, der []byte, key crypto.Signer) error {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	keyFile := name + ".pem"
+	if name == "ca" {
+		keyFile = name + ".key"
+	}
+	if err := writePEM(filepath.Join(dir, keyFile), "PRIVATE KEY", keyDER, 0o600); err != nil {
+		return err
+	}
+	return writePEM(filepath.Join(dir, name+".crt"), "CERTIFICATE", der, 0o644)
+}
+
+func writePEM(path, blockType string, der []byte, perm os.FileMode) error {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	// write next to the file and rename, a server reloading the certificate
+	// never sees a half written file
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readCert(t *testing.T, path string) *x509.Certificate {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		t.Fatalf("no certificate in %s", path)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func testOptions(t *testing.T, keyType string) options {
+	return options{
+		dir:       t.TempDir(),
+		keyType:   keyType,
+		rsaBits:   2048,
+		caCN:      "test CA",
+		caDays:    30,
+		days:      3650,
+		hosts:     []string{"localhost", "127.0.0.1"},
+		clientCN:  "fajar",
+		clientSAN: []string{"fajar@example.com", "spiffe://example.com/fajar"},
+	}
+}
+
+func TestRun(t *testing.T) {
+	for _, keyType := range []string{"ecdsa", "rsa"} {
+		t.Run(keyType, func(t *testing.T) {
+			o := testOptions(t, keyType)
+			if err := run(o); err != nil {
+				t.Fatal(err)
+			}
+			ca := readCert(t, filepath.Join(o.dir, "ca.crt"))
+			roots := x509.NewCertPool()
+			roots.AddCert(ca)
+
+			tests := []struct {
+				name  string
+				host  string
+				usage x509.ExtKeyUsage
+			}{
+				{name: "server", host: "localhost", usage: x509.ExtKeyUsageServerAuth},
+				{name: "server", host: "127.0.0.1", usage: x509.ExtKeyUsageServerAuth},
+				{name: "client", usage: x509.ExtKeyUsageClientAuth},
+			}
+			for _, tt := range tests {
+				pair, err := tls.LoadX509KeyPair(filepath.Join(o.dir, tt.name+".crt"), filepath.Join(o.dir, tt.name+".pem"))
+				if err != nil {
+					t.Fatalf("%s: %v", tt.name, err)
+				}
+				cert, err := x509.ParseCertificate(pair.Certificate[0])
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, err = cert.Verify(x509.VerifyOptions{Roots: roots, DNSName: tt.host, KeyUsages: []x509.ExtKeyUsage{tt.usage}})
+				if err != nil {
+					t.Errorf("%s %s: %v", tt.name, tt.host, err)
+				}
+				switch cert.PublicKey.(type) {
+				case *ecdsa.PublicKey:
+					if keyType != "ecdsa" {
+						t.Errorf("%s has an ecdsa key, want %s", tt.name, keyType)
+					}
+				case *rsa.PublicKey:
+					if keyType != "rsa" {
+						t.Errorf("%s has an rsa key, want %s", tt.name, keyType)
+					}
+				}
+				// the CA is valid for 30 days, the certificates for longer
+				if cert.NotAfter.After(ca.NotAfter) {
+					t.Errorf("%s outlives the CA", tt.name)
+				}
+			}
+
+			client := readCert(t, filepath.Join(o.dir, "client.crt"))
+			if client.Subject.CommonName != "fajar" || len(client.EmailAddresses) != 1 || len(client.URIs) != 1 {
+				t.Errorf("client is %q with %v and %v", client.Subject.CommonName, client.EmailAddresses, client.URIs)
+			}
+		})
+	}
+}
+
+func TestRunReusesTheCA(t *testing.T) {
+	o := testOptions(t, "ecdsa")
+	if err := run(o); err != nil {
+		t.Fatal(err)
+	}
+	ca := readCert(t, filepath.Join(o.dir, "ca.crt"))
+
+	tests := []struct {
+		name  string
+		newCA bool
+		same  bool
+	}{
+		{name: "reused", same: true},
+		{name: "new", newCA: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o.newCA = tt.newCA
+			o.keyType = "rsa"
+			if err := run(o); err != nil {
+				t.Fatal(err)
+			}
+			now := readCert(t, filepath.Join(o.dir, "ca.crt"))
+			if same := bytes.Equal(now.Raw, ca.Raw); same != tt.same {
+				t.Errorf("the CA is the same %v, want %v", same, tt.same)
+			}
+			roots := x509.NewCertPool()
+			roots.AddCert(now)
+			server := readCert(t, filepath.Join(o.dir, "server.crt"))
+			if _, err := server.Verify(x509.VerifyOptions{Roots: roots, DNSName: "localhost"}); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(o *options)
+	}{
+		{name: "unknown key type", change: func(o *options) { o.keyType = "dsa" }},
+		{name: "no hosts", change: func(o *options) { o.hosts = nil }},
+		{name: "client SAN", change: func(o *options) { o.clientSAN = []string{"fajar"} }},
+		{name: "validity", change: func(o *options) { o.days = 0 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := testOptions(t, "ecdsa")
+			tt.change(&o)
+			if err := run(o); err == nil {
+				t.Error("run succeeded")
+			}
+		})
+	}
+}
+
+func TestRunEncryptedCAKey(t *testing.T) {
+	o := testOptions(t, "ecdsa")
+	if err := run(o); err != nil {
+		t.Fatal(err)
+	}
+	// encrypt the key the way the openssl steps did
+	keyPath := filepath.Join(o.dir, "ca.key")
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, _ := pem.Decode(data)
+	block, err := x509.EncryptPEMBlock(rand.Reader, plain.Type, plain.Bytes, []byte("secret"), x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		pass string
+		err  bool
+	}{
+		{name: "no password", err: true},
+		{name: "wrong password", pass: "wrong", err: true},
+		{name: "password", pass: "secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o.caPass = tt.pass
+			if err := run(o); (err != nil) != tt.err {
+				t.Errorf("err = %v, want an error %v", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"learn-grpc/blog/domain"
+	"learn-grpc/calculator/pb"
+	"learn-grpc/greet/greetpb"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// command calls one RPC
+type command struct {
+	name   string
+	method protoreflect.MethodDescriptor
+	// aliases are short flags for fields of the request, e.g. first for
+	// greeting.first_name, fields of the request itself have short flags
+	// already
+	aliases map[string]string
+	// args are the fields positional arguments set in order. A client
+	// stream sends one message per argument, set in args[0].
+	args []string
+}
+
+// fullMethod is the name grpc calls the method by
+func (c *command) fullMethod() string {
+	return fmt.Sprintf("/%s/%s", c.method.Parent().FullName(), c.method.Name())
+}
+
+// shortcut renames the command of a method and gives it aliases and
+// positional arguments
+type shortcut struct {
+	name    string
+	aliases map[string]string
+	args    []string
+}
+
+// group is a service on the command line, the commands of its methods are
+// named after them, e.g. add-reaction for AddReaction, unless they have a
+// shortcut
+type group struct {
+	name      string
+	usage     string
+	services  []protoreflect.ServiceDescriptor
+	shortcuts map[string]shortcut
+}
+
+var greeting = map[string]string{
+	"first":  "greeting.first_name",
+	"last":   "greeting.last_name",
+	"lang":   "greeting.language",
+	"formal": "greeting.formal",
+}
+
+// with returns the aliases of base and more
+func with(base map[string]string, more map[string]string) map[string]string {
+	aliases := map[string]string{}
+	for name, path := range base {
+		aliases[name] = path
+	}
+	for name, path := range more {
+		aliases[name] = path
+	}
+	return aliases
+}
+
+var blogFields = map[string]string{
+	"id":      "blog.id",
+	"author":  "blog.author_id",
+	"title":   "blog.title",
+	"content": "blog.content",
+	"tag":     "blog.tags",
+	"lang":    "blog.language",
+}
+
+var groups = []*group{
+	{
+		name:     "greet",
+		usage:    "the greet service",
+		services: []protoreflect.ServiceDescriptor{greetpb.File_greet_greetpb_greet_proto.Services().ByName("GreetService")},
+		shortcuts: map[string]shortcut{
+			"Greet":             {name: "unary", aliases: greeting},
+			"GreetManyTimes":    {name: "many", aliases: with(greeting, map[string]string{"start": "start_index"})},
+			"LongGreet":         {name: "long", aliases: greeting, args: []string{"greeting.first_name"}},
+			"GreetEveryone":     {name: "everyone", aliases: greeting, args: []string{"greeting.first_name"}},
+			"GreetWithDeadline": {name: "deadline", aliases: greeting},
+		},
+	},
+	{
+		name:     "calc",
+		usage:    "the calculator service",
+		services: []protoreflect.ServiceDescriptor{pb.File_calculator_pb_calculator_proto.Services().ByName("CalculatorService")},
+		shortcuts: map[string]shortcut{
+			"Calculate":                {name: "sum", args: []string{"calculator.number_1", "calculator.number_2"}},
+			"PrimeNumberDecomposition": {name: "prime", args: []string{"number"}},
+			"PrintAverage":             {name: "average", args: []string{"number"}},
+			"FindMaximum":              {name: "max", args: []string{"number"}},
+			"SquareRoot":               {name: "sqrt", args: []string{"number"}},
+		},
+	},
+	{
+		name:  "blog",
+		usage: "the blog and blog admin services",
+		services: []protoreflect.ServiceDescriptor{
+			domain.File_blog_domain_blog_proto.Services().ByName("BlogService"),
+			domain.File_blog_domain_blog_proto.Services().ByName("BlogAdminService"),
+		},
+		shortcuts: map[string]shortcut{
+			"CreateBlog": {name: "create", aliases: blogFields},
+			"ReadBlog":   {name: "read", aliases: map[string]string{"lang": "accept_language"}, args: []string{"blog_id"}},
+			"UpdateBlog": {name: "update", aliases: blogFields},
+			"DeleteBlog": {name: "delete", args: []string{"blog_id"}},
+			"ListBlog": {name: "list", aliases: map[string]string{
+				"author": "author_id",
+				"lang":   "accept_language",
+				"rate":   "max_per_second",
+			}},
+		},
+	},
+}
+
+func findGroup(name string) *group {
+	for _, g := range groups {
+		if g.name == name {
+			return g
+		}
+	}
+	return nil
+}
+
+// commands returns the commands of the group sorted by name
+func (g *group) commands() []*command {
+	var commands []*command
+	for _, service := range g.services {
+		methods := service.Methods()
+		for i := 0; i < methods.Len(); i++ {
+			method := methods.Get(i)
+			c := &command{name: kebab(string(method.Name())), method: method}
+			if s, ok := g.shortcuts[string(method.Name())]; ok {
+				c.name, c.aliases, c.args = s.name, s.aliases, s.args
+			}
+			commands = append(commands, c)
+		}
+	}
+	sort.Slice(commands, func(i, j int) bool { return commands[i].name < commands[j].name })
+	return commands
+}
+
+func (g *group) command(name string) *command {
+	for _, c := range g.commands() {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// kebab turns AddReaction into add-reaction
+func kebab(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('-')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
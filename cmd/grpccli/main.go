@@ -0,0 +1,398 @@
+// Command grpccli calls every RPC of the greet, calculator and blog services
+// from the command line:
+//
+//	grpccli [flags] greet unary -first fajar
+//	grpccli [flags] calc prime 12390392840
+//	grpccli [flags] blog list -author fajar
+//	grpccli [flags] blog create -f post.json
+//
+// Every field of a request has a flag named after its path, e.g.
+// -greeting.first-name, and common ones have a short alias. Requests can
+// also be given in their JSON form with -f file (- for stdin) or -d text,
+// a client stream sends every message of it, or one message per argument.
+// Responses are printed one per line as text or JSON.
+//
+// The exit code is 0 when the call succeeds, 64 plus the gRPC status code
+// when it fails, e.g. 69 for NotFound, 2 for bad usage and 1 for other
+// errors.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// exit codes besides the ones of a gRPC status
+const (
+	exitError = 1
+	exitUsage = 2
+	// exitStatus plus the status code is the exit code of a failed call
+	exitStatus = 64
+)
+
+// errUsage is a usage error already reported
+var errUsage = errors.New("usage")
+
+// headers collects -H flags
+type headers []string
+
+func (h *headers) String() string     { return strings.Join(*h, ", ") }
+func (h *headers) Set(v string) error { *h = append(*h, v); return nil }
+
+type options struct {
+	addr       string
+	tls        bool
+	caFile     string
+	certFile   string
+	keyFile    string
+	serverName string
+	deadline   time.Duration
+	headers    headers
+	output     string
+	verbose    bool
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	var o options
+	fs := flag.NewFlagSet("grpccli", flag.ContinueOnError)
+	fs.StringVar(&o.addr, "addr", "localhost:50051", "address of the server")
+	fs.BoolVar(&o.tls, "tls", false, "connect over TLS, implied by -cert")
+	fs.StringVar(&o.caFile, "ca", "ssl/ca.crt", "CA the server certificate is verified with")
+	fs.StringVar(&o.certFile, "cert", "", "client certificate, the greet server asks for one")
+	fs.StringVar(&o.keyFile, "key", "", "key of the client certificate")
+	fs.StringVar(&o.serverName, "server-name", "", "name the server certificate is checked for, the host of -addr by default")
+	fs.DurationVar(&o.deadline, "deadline", 0, "deadline of the call, 0 for none")
+	fs.Var(&o.headers, "H", "metadata sent with the call as key: value, repeat the flag for more")
+	fs.StringVar(&o.output, "o", "text", "output format: text or json")
+	fs.BoolVar(&o.verbose, "v", false, "print the response headers and trailers to stderr")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: grpccli [flags] <service> <command> [command flags] [args]")
+		fmt.Fprintln(fs.Output(), "\nservices:")
+		for _, g := range groups {
+			fmt.Fprintf(fs.Output(), "  %-6s %s\n", g.name, g.usage)
+		}
+		fmt.Fprintln(fs.Output(), "\nflags:")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if o.output != "text" && o.output != "json" {
+		fmt.Fprintf(os.Stderr, "unknown output format %q, use text or json\n", o.output)
+		return exitUsage
+	}
+
+	args = fs.Args()
+	if len(args) == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	g := findGroup(args[0])
+	if g == nil {
+		fmt.Fprintf(os.Stderr, "unknown service %q\n", args[0])
+		fs.Usage()
+		return exitUsage
+	}
+	if len(args) == 1 {
+		printCommands(g)
+		return exitUsage
+	}
+	c := g.command(args[1])
+	if c == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", args[1])
+		printCommands(g)
+		return exitUsage
+	}
+
+	requests, err := c.parse(g.name, args[2:])
+	if errors.Is(err, errUsage) {
+		return exitUsage
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	if err := call(o, c, requests); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		if s, ok := status.FromError(err); ok {
+			return exitStatus + int(s.Code())
+		}
+		return exitError
+	}
+	return 0
+}
+
+func printCommands(g *group) {
+	fmt.Fprintf(os.Stderr, "usage: grpccli [flags] %s <command> [command flags] [args]\n\ncommands:\n", g.name)
+	for _, c := range g.commands() {
+		args := ""
+		for _, arg := range c.args {
+			args += " <" + arg + ">"
+		}
+		if len(c.args) > 0 && c.method.IsStreamingClient() {
+			args += "..."
+		}
+		fmt.Fprintf(os.Stderr, "  %-28s %s%s\n", c.name, c.method.Name(), args)
+	}
+}
+
+// parse builds the requests of the command from its flags and arguments
+func (c *command) parse(groupName string, args []string) ([]proto.Message, error) {
+	input := c.method.Input()
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(input.FullName())
+	if err != nil {
+		return nil, err
+	}
+	newRequest := func() proto.Message { return mt.New().Interface() }
+
+	fs := flag.NewFlagSet(groupName+" "+c.name, flag.ContinueOnError)
+	file := fs.String("f", "", "file with the request in JSON, - for stdin")
+	data := fs.String("d", "", "the request in JSON")
+	var set assignments
+	addFieldFlags(fs, input, &set)
+	for alias, path := range c.aliases {
+		f, err := lookup(input, path)
+		if err != nil {
+			return nil, err
+		}
+		fs.Var(newFieldFlag(f, &set), alias, "same as -"+flagName(f))
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: grpccli [flags] %s %s [flags]", groupName, c.name)
+		for _, arg := range c.args {
+			fmt.Fprintf(fs.Output(), " <%s>", arg)
+		}
+		fmt.Fprintf(fs.Output(), "\n\ncalls %s with a %s\n\n", c.fullMethod(), input.FullName())
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		// the flag package printed it with the usage
+		return nil, errUsage
+	}
+
+	var requests []proto.Message
+	switch {
+	case *file != "" && *data != "":
+		return nil, errors.New("use either -f or -d")
+	case *file != "":
+		r := os.Stdin
+		if *file != "-" {
+			if r, err = os.Open(*file); err != nil {
+				return nil, err
+			}
+			defer r.Close()
+		}
+		if requests, err = readMessages(r, newRequest); err != nil {
+			return nil, fmt.Errorf("%s: %w", *file, err)
+		}
+	case *data != "":
+		if requests, err = readMessages(strings.NewReader(*data), newRequest); err != nil {
+			return nil, fmt.Errorf("-d: %w", err)
+		}
+	}
+	if len(requests) == 0 {
+		requests = []proto.Message{newRequest()}
+	}
+	for _, req := range requests {
+		set.apply(req)
+	}
+
+	positional := fs.Args()
+	if c.method.IsStreamingClient() {
+		// one message per argument
+		if len(positional) == 0 {
+			return requests, nil
+		}
+		if len(c.args) == 0 {
+			return nil, fmt.Errorf("%s takes no arguments", c.name)
+		}
+		f, err := lookup(input, c.args[0])
+		if err != nil {
+			return nil, err
+		}
+		var messages []proto.Message
+		for _, arg := range positional {
+			var one assignments
+			if err := one.add(f, arg); err != nil {
+				return nil, err
+			}
+			req := proto.Clone(requests[0])
+			one.apply(req)
+			messages = append(messages, req)
+		}
+		return messages, nil
+	}
+
+	if len(requests) > 1 {
+		return nil, fmt.Errorf("%s sends one request, got %d", c.name, len(requests))
+	}
+	if len(positional) > len(c.args) {
+		return nil, fmt.Errorf("%s takes at most %d arguments, got %d", c.name, len(c.args), len(positional))
+	}
+	var fromArgs assignments
+	for i, arg := range positional {
+		f, err := lookup(input, c.args[i])
+		if err != nil {
+			return nil, err
+		}
+		if err := fromArgs.add(f, arg); err != nil {
+			return nil, fmt.Errorf("%s: %w", c.args[i], err)
+		}
+	}
+	fromArgs.apply(requests[0])
+	return requests, nil
+}
+
+// dial connects to the server of the options
+func dial(o options) (*grpc.ClientConn, error) {
+	if !o.tls && o.certFile == "" {
+		return grpc.Dial(o.addr, grpc.WithInsecure())
+	}
+	config := &tls.Config{ServerName: o.serverName}
+	if o.caFile != "" {
+		pem, err := os.ReadFile(o.caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate in %s", o.caFile)
+		}
+	}
+	if o.certFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.certFile, o.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return grpc.Dial(o.addr, grpc.WithTransportCredentials(credentials.NewTLS(config)))
+}
+
+// call sends the requests and prints the responses
+func call(o options, c *command, requests []proto.Message) error {
+	ctx := context.Background()
+	if o.deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.deadline)
+		defer cancel()
+	}
+	for _, h := range o.headers {
+		key, value, ok := cut(h, ":")
+		if !ok {
+			return fmt.Errorf("header %q is not key: value", h)
+		}
+		ctx = metadata.AppendToOutgoingContext(ctx, strings.TrimSpace(key), strings.TrimSpace(value))
+	}
+
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(c.method.Output().FullName())
+	if err != nil {
+		return err
+	}
+	cc, err := dial(o)
+	if err != nil {
+		return err
+	}
+	defer cc.Close()
+
+	// every kind of RPC goes through a stream, the requests are sent while
+	// the responses are read
+	desc := &grpc.StreamDesc{
+		StreamName:    string(c.method.Name()),
+		ServerStreams: c.method.IsStreamingServer(),
+		ClientStreams: c.method.IsStreamingClient(),
+	}
+	stream, err := cc.NewStream(ctx, desc, c.fullMethod())
+	if err != nil {
+		return err
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for _, req := range requests {
+			// a failed send shows as the error of RecvMsg
+			if err := stream.SendMsg(req); err != nil {
+				return
+			}
+		}
+		stream.CloseSend()
+	}()
+
+	var recvErr error
+	for {
+		res := mt.New().Interface()
+		if recvErr = stream.RecvMsg(res); recvErr != nil {
+			break
+		}
+		if err := printMessage(o.output, res); err != nil {
+			return err
+		}
+	}
+	wg.Wait()
+
+	if o.verbose {
+		if header, err := stream.Header(); err == nil {
+			printMetadata("header", header)
+		}
+		printMetadata("trailer", stream.Trailer())
+	}
+	if recvErr == io.EOF {
+		return nil
+	}
+	return recvErr
+}
+
+func printMessage(output string, m proto.Message) error {
+	var data []byte
+	var err error
+	if output == "json" {
+		data, err = protojson.Marshal(m)
+	} else {
+		data, err = prototext.Marshal(m)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+func printMetadata(kind string, md metadata.MD) {
+	for key, values := range md {
+		for _, value := range values {
+			fmt.Fprintf(os.Stderr, "%s %s: %s\n", kind, key, value)
+		}
+	}
+}
+
+// cut is strings.Cut, go.mod is older than go 1.18
+func cut(s, sep string) (before, after string, found bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
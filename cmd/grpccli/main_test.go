@@ -0,0 +1,144 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"learn-grpc/blog/domain"
+	"learn-grpc/calculator/pb"
+	"learn-grpc/greet/greetpb"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestKebab(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "Greet", want: "greet"},
+		{name: "AddReaction", want: "add-reaction"},
+		{name: "PrimeNumberDecomposition", want: "prime-number-decomposition"},
+	}
+	for _, tt := range tests {
+		if got := kebab(tt.name); got != tt.want {
+			t.Errorf("kebab(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		group   string
+		command string
+		args    []string
+		want    []proto.Message
+		err     bool
+	}{
+		{
+			name: "aliases", group: "greet", command: "unary",
+			args: []string{"-first", "Fajar", "-formal", "-greeting.honorific", "mr"},
+			want: []proto.Message{&greetpb.GreetRequest{Greeting: &greetpb.Greeting{FirstName: "Fajar", Formal: true, Honorific: greetpb.Honorific_HONORIFIC_MR}}},
+		},
+		{
+			name: "enum number and duration", group: "greet", command: "many",
+			args: []string{"-greeting.honorific", "2", "-interval", "1.5s", "-start", "3"},
+			want: []proto.Message{&greetpb.GreetManyTimesRequest{Greeting: &greetpb.Greeting{Honorific: greetpb.Honorific_HONORIFIC_MS}, Interval: durationpb.New(1500 * time.Millisecond), StartIndex: 3}},
+		},
+		{
+			name: "flags win over -d", group: "greet", command: "unary",
+			args: []string{"-d", `{"greeting": {"firstName": "Sari", "lastName": "Dewi"}}`, "-first", "Fajar"},
+			want: []proto.Message{&greetpb.GreetRequest{Greeting: &greetpb.Greeting{FirstName: "Fajar", LastName: "Dewi"}}},
+		},
+		{
+			name: "positional arguments", group: "calc", command: "sum",
+			args: []string{"3", "10"},
+			want: []proto.Message{&pb.CalculatorRequest{Calculator: &pb.Calculator{Number_1: 3, Number_2: 10}}},
+		},
+		{
+			name: "a message per argument", group: "greet", command: "long",
+			args: []string{"-lang", "id", "Fajar", "Sari"},
+			want: []proto.Message{
+				&greetpb.LongGreetRequest{Greeting: &greetpb.Greeting{FirstName: "Fajar", Language: "id"}},
+				&greetpb.LongGreetRequest{Greeting: &greetpb.Greeting{FirstName: "Sari", Language: "id"}},
+			},
+		},
+		{
+			name: "a message per -d object", group: "calc", command: "average",
+			args: []string{"-d", `{"number": 1} {"number": 2}`},
+			want: []proto.Message{&pb.PrintAverageRequest{Number: 1}, &pb.PrintAverageRequest{Number: 2}},
+		},
+		{
+			name: "repeated field", group: "blog", command: "create",
+			args: []string{"-title", "hello", "-tag", "go", "-tag", "grpc"},
+			want: []proto.Message{&domain.CreateBlogRequest{Blog: &domain.Blog{Title: "hello", Tags: []string{"go", "grpc"}}}},
+		},
+		{
+			name: "alias and field flag", group: "blog", command: "list",
+			args: []string{"-rate", "5", "-batch-size", "2"},
+			want: []proto.Message{&domain.ListBlogRequest{MaxPerSecond: 5, BatchSize: 2}},
+		},
+		{name: "not a number", group: "calc", command: "sum", args: []string{"three"}, err: true},
+		{name: "too many arguments", group: "calc", command: "sum", args: []string{"1", "2", "3"}, err: true},
+		{name: "several requests to a unary method", group: "calc", command: "sum", args: []string{"-d", `[{}, {}]`}, err: true},
+		{name: "-f and -d", group: "calc", command: "sum", args: []string{"-f", "-", "-d", "{}"}, err: true},
+		{name: "unknown enum value", group: "greet", command: "unary", args: []string{"-greeting.honorific", "sir"}, err: true},
+		{name: "unknown JSON field", group: "greet", command: "unary", args: []string{"-d", `{"name": "Fajar"}`}, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := findGroup(tt.group).command(tt.command)
+			if c == nil {
+				t.Fatalf("no command %s %s", tt.group, tt.command)
+			}
+			got, err := c.parse(tt.group, tt.args)
+			if tt.err {
+				if err == nil {
+					t.Errorf("parsed %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("%d requests, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if !proto.Equal(got[i], tt.want[i]) {
+					t.Errorf("request %d = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCommands(t *testing.T) {
+	for _, g := range groups {
+		seen := map[string]bool{}
+		for _, c := range g.commands() {
+			if seen[c.name] {
+				t.Errorf("%s has two %s commands", g.name, c.name)
+			}
+			seen[c.name] = true
+			// the paths of the aliases and arguments are fields of the request
+			for alias, path := range c.aliases {
+				if _, err := lookup(c.method.Input(), path); err != nil {
+					t.Errorf("%s %s -%s: %v", g.name, c.name, alias, err)
+				}
+			}
+			for _, path := range c.args {
+				if _, err := lookup(c.method.Input(), path); err != nil {
+					t.Errorf("%s %s argument: %v", g.name, c.name, err)
+				}
+			}
+		}
+		for method, s := range g.shortcuts {
+			if !seen[s.name] {
+				t.Errorf("%s has no method %s", g.name, method)
+			}
+		}
+	}
+}
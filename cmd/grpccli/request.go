@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// maxFlagDepth is how deep into nested messages fields get a flag, deeper
+// ones can be set with -f or -d
+const maxFlagDepth = 3
+
+// field is the path to a field of the request from the top message
+type field []protoreflect.FieldDescriptor
+
+func (f field) String() string {
+	names := make([]string, len(f))
+	for i, fd := range f {
+		names[i] = string(fd.Name())
+	}
+	return strings.Join(names, ".")
+}
+
+// lookup finds a field by a path like greeting.first_name
+func lookup(md protoreflect.MessageDescriptor, path string) (field, error) {
+	var f field
+	for _, name := range strings.Split(path, ".") {
+		if md == nil {
+			return nil, fmt.Errorf("%s: %s is not a message", path, f)
+		}
+		fd := md.Fields().ByName(protoreflect.Name(name))
+		if fd == nil {
+			return nil, fmt.Errorf("%s: %s has no field %s", path, md.FullName(), name)
+		}
+		f = append(f, fd)
+		md = fd.Message()
+	}
+	return f, nil
+}
+
+// assignment is a value given for a field on the command line
+type assignment struct {
+	field field
+	value protoreflect.Value
+}
+
+// assignments collects the field flags in the order they were given, they
+// are applied after the messages of -f and -d are read
+type assignments struct {
+	list []assignment
+}
+
+func (a *assignments) add(f field, raw string) error {
+	v, err := parseValue(f[len(f)-1], raw)
+	if err != nil {
+		return err
+	}
+	a.list = append(a.list, assignment{field: f, value: v})
+	return nil
+}
+
+// apply sets the fields on m, repeated fields get the values appended
+func (a *assignments) apply(m proto.Message) {
+	for _, as := range a.list {
+		msg := m.ProtoReflect()
+		for _, fd := range as.field[:len(as.field)-1] {
+			msg = msg.Mutable(fd).Message()
+		}
+		fd := as.field[len(as.field)-1]
+		if fd.IsList() {
+			msg.Mutable(fd).List().Append(as.value)
+		} else {
+			msg.Set(fd, as.value)
+		}
+	}
+}
+
+// fieldFlag is the flag.Value of a field
+type fieldFlag struct {
+	field field
+	to    *assignments
+}
+
+func (f *fieldFlag) String() string       { return "" }
+func (f *fieldFlag) Set(raw string) error { return f.to.add(f.field, raw) }
+
+// boolFieldFlag lets -formal stand for -formal=true
+type boolFieldFlag struct{ fieldFlag }
+
+func (f *boolFieldFlag) IsBoolFlag() bool { return true }
+
+func newFieldFlag(f field, to *assignments) flag.Value {
+	if f[len(f)-1].Kind() == protoreflect.BoolKind {
+		return &boolFieldFlag{fieldFlag{field: f, to: to}}
+	}
+	return &fieldFlag{field: f, to: to}
+}
+
+// flagName is the flag of a field, greeting.first_name is greeting.first-name
+func flagName(f field) string {
+	return strings.ReplaceAll(f.String(), "_", "-")
+}
+
+// addFieldFlags adds a flag for every field of md that holds a value, in
+// nested messages too
+func addFieldFlags(fs *flag.FlagSet, md protoreflect.MessageDescriptor, to *assignments) {
+	var walk func(prefix field, md protoreflect.MessageDescriptor)
+	walk = func(prefix field, md protoreflect.MessageDescriptor) {
+		fields := md.Fields()
+		for i := 0; i < fields.Len(); i++ {
+			fd := fields.Get(i)
+			f := append(append(field{}, prefix...), fd)
+			switch {
+			case fd.IsMap():
+				continue
+			case fd.Kind() == protoreflect.MessageKind || fd.Kind() == protoreflect.GroupKind:
+				if wellKnown(fd.Message()) {
+					break
+				}
+				if !fd.IsList() && len(f) < maxFlagDepth && !recursive(f) {
+					walk(f, fd.Message())
+				}
+				continue
+			}
+			fs.Var(newFieldFlag(f, to), flagName(f), usage(fd))
+		}
+	}
+	walk(nil, md)
+}
+
+func usage(fd protoreflect.FieldDescriptor) string {
+	kind := fd.Kind().String()
+	switch {
+	case fd.Enum() != nil:
+		values := fd.Enum().Values()
+		names := make([]string, values.Len())
+		for i := range names {
+			names[i] = string(values.Get(i).Name())
+		}
+		kind = "one of " + strings.Join(names, ", ")
+	case fd.Message() != nil && fd.Message().FullName() == "google.protobuf.Duration":
+		kind = "duration, e.g. 1.5s"
+	case fd.Message() != nil && fd.Message().FullName() == "google.protobuf.Timestamp":
+		kind = "time, RFC 3339"
+	}
+	if fd.IsList() {
+		kind += ", repeat the flag for more"
+	}
+	return kind
+}
+
+// recursive reports whether the last message of f is one it is already in
+func recursive(f field) bool {
+	last := f[len(f)-1].Message().FullName()
+	for _, fd := range f[:len(f)-1] {
+		if fd.Message() != nil && fd.Message().FullName() == last {
+			return true
+		}
+	}
+	return false
+}
+
+// wellKnown reports whether md is a message set from a single value
+func wellKnown(md protoreflect.MessageDescriptor) bool {
+	switch md.FullName() {
+	case "google.protobuf.Duration", "google.protobuf.Timestamp":
+		return true
+	}
+	return false
+}
+
+// parseValue parses the text of a field value
+func parseValue(fd protoreflect.FieldDescriptor, raw string) (protoreflect.Value, error) {
+	var v protoreflect.Value
+	var err error
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		v = protoreflect.ValueOfString(raw)
+	case protoreflect.BytesKind:
+		v = protoreflect.ValueOfBytes([]byte(raw))
+	case protoreflect.BoolKind:
+		var b bool
+		b, err = strconv.ParseBool(raw)
+		v = protoreflect.ValueOfBool(b)
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		var n int64
+		n, err = strconv.ParseInt(raw, 10, 32)
+		v = protoreflect.ValueOfInt32(int32(n))
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		var n int64
+		n, err = strconv.ParseInt(raw, 10, 64)
+		v = protoreflect.ValueOfInt64(n)
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		var n uint64
+		n, err = strconv.ParseUint(raw, 10, 32)
+		v = protoreflect.ValueOfUint32(uint32(n))
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		var n uint64
+		n, err = strconv.ParseUint(raw, 10, 64)
+		v = protoreflect.ValueOfUint64(n)
+	case protoreflect.FloatKind:
+		var n float64
+		n, err = strconv.ParseFloat(raw, 32)
+		v = protoreflect.ValueOfFloat32(float32(n))
+	case protoreflect.DoubleKind:
+		var n float64
+		n, err = strconv.ParseFloat(raw, 64)
+		v = protoreflect.ValueOfFloat64(n)
+	case protoreflect.EnumKind:
+		return parseEnum(fd.Enum(), raw)
+	case protoreflect.MessageKind:
+		return parseWellKnown(fd.Message(), raw)
+	default:
+		return v, fmt.Errorf("%s fields cannot be set from the command line, use -f or -d", fd.Kind())
+	}
+	if err != nil {
+		return v, fmt.Errorf("%q is not a %s", raw, fd.Kind())
+	}
+	return v, nil
+}
+
+// parseEnum takes the name of a value, with or without the prefix of the
+// enum and in any case, e.g. HONORIFIC_MR or mr, or its number
+func parseEnum(ed protoreflect.EnumDescriptor, raw string) (protoreflect.Value, error) {
+	values := ed.Values()
+	if n, err := strconv.ParseInt(raw, 10, 32); err == nil {
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(n)), nil
+	}
+	for i := 0; i < values.Len(); i++ {
+		name := string(values.Get(i).Name())
+		if strings.EqualFold(name, raw) || strings.HasSuffix(strings.ToLower(name), "_"+strings.ToLower(raw)) {
+			return protoreflect.ValueOfEnum(values.Get(i).Number()), nil
+		}
+	}
+	return protoreflect.Value{}, fmt.Errorf("%q is not a value of %s", raw, ed.Name())
+}
+
+func parseWellKnown(md protoreflect.MessageDescriptor, raw string) (protoreflect.Value, error) {
+	switch md.FullName() {
+	case "google.protobuf.Duration":
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return protoreflect.Value{}, err
+		}
+		return protoreflect.ValueOfMessage(durationpb.New(d).ProtoReflect()), nil
+	case "google.protobuf.Timestamp":
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return protoreflect.Value{}, err
+		}
+		return protoreflect.ValueOfMessage(timestamppb.New(t).ProtoReflect()), nil
+	}
+	return protoreflect.Value{}, fmt.Errorf("%s fields cannot be set from the command line, use -f or -d", md.FullName())
+}
+
+// readMessages reads requests in their JSON form, either a JSON array or
+// objects one after the other
+func readMessages(r io.Reader, newMessage func() proto.Message) ([]proto.Message, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var raws []json.RawMessage
+	if trimmed := bytes.TrimSpace(data); bytes.HasPrefix(trimmed, []byte("[")) {
+		if err := json.Unmarshal(trimmed, &raws); err != nil {
+			return nil, err
+		}
+	} else {
+		dec := json.NewDecoder(bytes.NewReader(trimmed))
+		for {
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			raws = append(raws, raw)
+		}
+	}
+
+	messages := make([]proto.Message, len(raws))
+	for i, raw := range raws {
+		messages[i] = newMessage()
+		if err := protojson.Unmarshal(raw, messages[i]); err != nil {
+			return nil, fmt.Errorf("request %d: %w", i+1, err)
+		}
+	}
+	return messages, nil
+}
@@ -2,32 +2,41 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
 	"fmt"
 	"io"
 	"learn-grpc/greet/greetpb"
 	"log"
+	"os"
 	"time"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 
 	"google.golang.org/grpc"
 )
 
 func main() {
+	certFile := flag.String("cert", "ssl/client.crt", "client certificate presented to the server, empty for none")
+	keyFile := flag.String("key", "ssl/client.pem", "key of the client certificate")
+	caFile := flag.String("ca", "ssl/ca.crt", "CA the server certificate is verified with")
+	flag.Parse()
+
 	fmt.Println("Hello I'm a client")
 
 	tls := true
 	opts := grpc.WithInsecure()
 	if tls {
-		certFile := "ssl/ca.crt"
-		creds, sslErr := credentials.NewClientTLSFromFile(certFile, "")
+		config, sslErr := clientTLSConfig(*caFile, *certFile, *keyFile)
 		if sslErr != nil {
 			log.Fatalf("failed loading certificate: %v", sslErr)
 			return
 		}
-		opts = grpc.WithTransportCredentials(creds)
+		opts = grpc.WithTransportCredentials(credentials.NewTLS(config))
 	}
 
 	cc, err := grpc.Dial("localhost:50051", opts)
@@ -48,6 +57,28 @@ func main() {
 	// doUnaryWithDeadline(c, 1*time.Second)
 }
 
+// clientTLSConfig trusts the server certificates signed by caFile and
+// presents the certificate in certFile when it is set
+func clientTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate in %s", caFile)
+	}
+	config := &tls.Config{RootCAs: pool}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
 func doUnary(c greetpb.GreetServiceClient) {
 	fmt.Println("starting to do an Unary RPC...")
 	req := &greetpb.GreetRequest{
@@ -74,21 +105,33 @@ func doServerStreaming(c greetpb.GreetServiceClient) {
 			FirstName: "fajar",
 			LastName:  "fahrurozi",
 		},
+		Count:    5,
+		Interval: durationpb.New(500 * time.Millisecond),
 	}
-	resStream, err := c.GreetManyTimes(ctxBg, req) //(greetpb.GreetService_GreetManyTimesClient, error)
-	if err != nil {
-		log.Fatalf("error while calling GreetManyTimes RPC: %v", err)
-	}
-	for {
-		msg, err := resStream.Recv()
-		if err == io.EOF {
-			// we've reached the end of the stream
-			break
-		}
+	// a stream that breaks is opened again from the greeting after the last
+	// one received
+	for retries := 0; ; retries++ {
+		resStream, err := c.GreetManyTimes(ctxBg, req) //(greetpb.GreetService_GreetManyTimesClient, error)
 		if err != nil {
-			log.Fatalf("error while reading stream: %v", err)
+			log.Fatalf("error while calling GreetManyTimes RPC: %v", err)
+		}
+		for {
+			msg, err := resStream.Recv()
+			if err == io.EOF {
+				// we've reached the end of the stream
+				return
+			}
+			if status.Code(err) == codes.Unavailable && retries < 3 {
+				log.Printf("stream broke, resuming from greeting %d: %v", req.StartIndex, err)
+				break
+			}
+			if err != nil {
+				log.Fatalf("error while reading stream: %v", err)
+			}
+			log.Printf("response %d from GreetManyTimes: %v", msg.GetNumber(), msg.GetResult())
+			req.StartIndex = msg.GetNumber() + 1
 		}
-		log.Printf("response from GreetManyTImes: %", msg.GetResult())
+		time.Sleep(time.Second)
 	}
 }
 
@@ -149,7 +192,8 @@ func doClientStreaming(c greetpb.GreetServiceClient) {
 		log.Fatalf("error while receiving response: %v", err)
 	}
 
-	fmt.Printf("response: %v", res)
+	fmt.Printf("response: %v\n", res.GetResult())
+	fmt.Printf("%d greetings for %d people: %v\n", res.GetCount(), res.GetDistinctCount(), res.GetNames())
 }
 
 func doBiDiStreaming(c greetpb.GreetServiceClient) {
@@ -165,6 +209,8 @@ func doBiDiStreaming(c greetpb.GreetServiceClient) {
 			Greeting: &greetpb.Greeting{
 				FirstName: "Fajar",
 			},
+			// the first message picks the room
+			Room: "lobby",
 		},
 		&greetpb.GreetEveryoneRequest{
 			Greeting: &greetpb.Greeting{
@@ -219,7 +265,7 @@ func doBiDiStreaming(c greetpb.GreetServiceClient) {
 				log.Fatalf("error while receiveing data: %v\n", err)
 				break
 			}
-			fmt.Printf("received: %v\n", res.GetResult())
+			fmt.Printf("received %v in %s (%d here): %v\n", res.GetKind(), res.GetRoom(), res.GetMembers(), res.GetResult())
 		}
 		close(waitc)
 	}()
@@ -242,10 +288,13 @@ func doUnaryWithDeadline(c greetpb.GreetServiceClient, timeout time.Duration) {
 
 		statusErr, ok := status.FromError(err)
 		if ok {
-			if statusErr.Code() == codes.DeadlineExceeded {
+			switch statusErr.Code() {
+			case codes.DeadlineExceeded:
 				fmt.Println("timeout was hit, deadline exceeded", statusErr.Message())
-			} else {
-				fmt.Printf("unexpected error: %v", statusErr)
+			case codes.Canceled:
+				fmt.Println("the request was canceled", statusErr.Message())
+			default:
+				fmt.Printf("unexpected error: %v\n", statusErr)
 			}
 		} else {
 			log.Fatalf("error while calling GreetWithDealine RPC: %v", err)
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+type identityKey struct{}
+
+// withIdentity returns ctx carrying the name of the client
+func withIdentity(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, identityKey{}, name)
+}
+
+// identityFromContext returns the name stored by withIdentity
+func identityFromContext(ctx context.Context) (string, bool) {
+	name, ok := ctx.Value(identityKey{}).(string)
+	return name, ok && name != ""
+}
+
+// certIdentity returns the name of the client of a verified TLS connection:
+// the first email or URI SAN of its certificate, the common name without
+// one. DNS SANs name hosts, not people, and are not used.
+func certIdentity(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return nameOf(info.State.VerifiedChains[0][0])
+}
+
+func nameOf(cert *x509.Certificate) string {
+	switch {
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	default:
+		return cert.Subject.CommonName
+	}
+}
+
+// identityUnaryInterceptor stores the certificate identity of the client in
+// the context of every unary request
+func identityUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if name := certIdentity(ctx); name != "" {
+		ctx = withIdentity(ctx, name)
+	}
+	return handler(ctx, req)
+}
+
+// identityStreamInterceptor is identityUnaryInterceptor for streams
+func identityStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	name := certIdentity(ss.Context())
+	if name == "" {
+		return handler(srv, ss)
+	}
+	return handler(srv, &identityStream{ServerStream: ss, ctx: withIdentity(ss.Context(), name)})
+}
+
+// identityStream replaces the context of a grpc.ServerStream
+type identityStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *identityStream) Context() context.Context {
+	return s.ctx
+}
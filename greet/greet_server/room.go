@@ -0,0 +1,119 @@
+package main
+
+import (
+	"log"
+	"sync"
+
+	"learn-grpc/greet/greetpb"
+)
+
+// defaultRoom is joined by GreetEveryone clients that name no room
+const defaultRoom = "lobby"
+
+// roomMetadataKey names the room of a GreetEveryone stream, the room of the
+// first message is used without it
+const roomMetadataKey = "x-room"
+
+// roomEvent is what happens in a room, every member formats it in its own
+// language
+type roomEvent struct {
+	kind greetpb.GreetEveryoneResponse_Kind
+	// greeting is the greeting sent, or the first greeting of the member who
+	// joined or left
+	greeting *greetpb.Greeting
+	members  int
+}
+
+// member is one GreetEveryone stream in a room
+type member struct {
+	room string
+	// greeting is the first greeting of the member, it picks the language
+	greeting *greetpb.Greeting
+	events   chan roomEvent
+	// left and dropped are guarded by rooms.mu
+	left    bool
+	dropped bool
+}
+
+// rooms fans greetings out to the members of a room. Every member has a
+// queue of QueueSize events, a member whose queue is full is dropped so a
+// slow client never holds up the others.
+type rooms struct {
+	queueSize int
+
+	mu    sync.Mutex
+	rooms map[string]map[*member]bool
+}
+
+// join adds a member to a room and tells everyone there, the member too
+func (r *rooms) join(room string, greeting *greetpb.Greeting) *member {
+	m := &member{room: room, greeting: greeting, events: make(chan roomEvent, r.queueSize)}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.rooms == nil {
+		r.rooms = map[string]map[*member]bool{}
+	}
+	if r.rooms[room] == nil {
+		r.rooms[room] = map[*member]bool{}
+	}
+	r.rooms[room][m] = true
+	r.broadcast(room, roomEvent{kind: greetpb.GreetEveryoneResponse_JOINED, greeting: greeting, members: len(r.rooms[room])})
+	return m
+}
+
+// send fans a greeting out to the room of m
+func (r *rooms) send(m *member, greeting *greetpb.Greeting) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if m.left {
+		return
+	}
+	r.broadcast(m.room, roomEvent{kind: greetpb.GreetEveryoneResponse_GREETING, greeting: greeting, members: len(r.rooms[m.room])})
+}
+
+// leave takes m out of its room and closes its queue, the events already
+// queued can still be read
+func (r *rooms) leave(m *member) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !m.left {
+		r.remove(m, greetpb.GreetEveryoneResponse_LEFT)
+	}
+}
+
+// isDropped reports whether m was dropped for being too slow
+func (r *rooms) isDropped(m *member) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return m.dropped
+}
+
+// remove takes m out of its room and tells the others, r.mu is held
+func (r *rooms) remove(m *member, kind greetpb.GreetEveryoneResponse_Kind) {
+	m.left = true
+	close(m.events)
+	members := r.rooms[m.room]
+	delete(members, m)
+	if len(members) == 0 {
+		delete(r.rooms, m.room)
+		return
+	}
+	r.broadcast(m.room, roomEvent{kind: kind, greeting: m.greeting, members: len(members)})
+}
+
+// broadcast queues ev for every member of room without blocking, r.mu is held
+func (r *rooms) broadcast(room string, ev roomEvent) {
+	for m := range r.rooms[room] {
+		if m.left {
+			continue
+		}
+		select {
+		case m.events <- ev:
+		default:
+			log.Printf("dropping %s from room %s, it does not keep up", m.greeting.GetFirstName(), room)
+			m.dropped = true
+			r.remove(m, greetpb.GreetEveryoneResponse_DROPPED)
+		}
+	}
+}
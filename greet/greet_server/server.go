@@ -3,120 +3,354 @@ package main
 // https://github.com/simplesteph/grpc-go-course/tree/master/ssl
 import (
 	"context"
+	"expvar"
+	"flag"
 	"fmt"
 	"io"
 	"learn-grpc/greet/greetpb"
+	"learn-grpc/greet/i18n"
+	"learn-grpc/internal/certreload"
+	"learn-grpc/internal/deadline"
 	"log"
 	"net"
-	"strconv"
+	"net/http"
+	"strings"
 	"time"
 
 	"google.golang.org/grpc/credentials"
 
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 
 	"google.golang.org/grpc"
 )
 
-type server struct{}
+type server struct {
+	rooms *rooms
+	// maxGreetings and maxGreetingInterval limit GreetManyTimes requests
+	maxGreetings        int
+	maxGreetingInterval time.Duration
+	// maxLongGreet is the most greetings a LongGreet stream may send
+	maxLongGreet int
+	// minWork is the least time left before the deadline GreetWithDeadline
+	// starts working with
+	minWork time.Duration
+	// messages returns the current message catalog, it changes when the
+	// templates are reloaded
+	messages func() *i18n.Catalog
+}
+
+// greet formats the message name for a greeting in the language the client
+// asked for, it returns the text and its language
+func (s *server) greet(ctx context.Context, name string, greeting *greetpb.Greeting, data i18n.Data) (string, string, error) {
+	return s.render(ctx, name, greeting.GetLanguage(), greeting, data)
+}
+
+// render is greet in the language requested, which is not the one of the
+// greeting when it is sent to someone else
+func (s *server) render(ctx context.Context, name, requested string, greeting *greetpb.Greeting, data i18n.Data) (string, string, error) {
+	catalog := s.messages()
+	lang := catalog.Negotiate(ctx, requested)
+	data.FirstName = greeting.GetFirstName()
+	data.LastName = greeting.GetLastName()
+	data.Formal = greeting.GetFormal()
+	data.TimeOfDay = i18n.PartOfDay(time.Now())
+	switch greeting.GetHonorific() {
+	case greetpb.Honorific_HONORIFIC_MR:
+		data.Honorific = "mr"
+	case greetpb.Honorific_HONORIFIC_MS:
+		data.Honorific = "ms"
+	}
+
+	result, err := catalog.Render(lang, name, data)
+	if err != nil {
+		return "", "", status.Errorf(codes.Internal, "failed to format the greeting: %v", err)
+	}
+	return result, lang.String(), nil
+}
 
 func (s *server) Greet(ctx context.Context, r *greetpb.GreetRequest) (*greetpb.GreetResponse, error) {
-	fmt.Println("Greet function was invoked with %v", r)
-	firstName := r.GetGreeting().GetFirstName()
+	fmt.Printf("Greet function was invoked with %v\n", r)
 
-	result := "Hello " + firstName
+	// a client that gives no name is greeted with the name of its certificate
+	greeting := r.GetGreeting()
+	if name, ok := identityFromContext(ctx); ok && greeting.GetFirstName() == "" {
+		greeting = proto.Clone(greeting).(*greetpb.Greeting)
+		if greeting == nil {
+			greeting = &greetpb.Greeting{}
+		}
+		greeting.FirstName = name
+	}
+
+	result, lang, err := s.greet(ctx, "Greet", greeting, i18n.Data{})
+	if err != nil {
+		return nil, err
+	}
 	res := &greetpb.GreetResponse{
-		Result: result,
+		Result:   result,
+		Language: lang,
 	}
 
 	return res, nil
 }
 
+// defaults of a GreetManyTimes request
+const (
+	defaultGreetings        = 10
+	defaultGreetingInterval = time.Second
+)
+
 func (s *server) GreetManyTimes(req *greetpb.GreetManyTimesRequest, stream greetpb.GreetService_GreetManyTimesServer) error {
-	fmt.Println("GreetManyTimes function was invoked with %v", req)
-	firstName := req.GetGreeting().GetFirstName()
-	for i := 0; i < 10; i++ {
-		result := "Hello " + firstName + " number " + strconv.Itoa(i)
+	fmt.Printf("GreetManyTimes function was invoked with %v\n", req)
+	ctx := stream.Context()
+
+	count := int(req.GetCount())
+	switch {
+	case count == 0:
+		count = defaultGreetings
+	case count < 0 || count > s.maxGreetings:
+		return status.Errorf(codes.InvalidArgument, "count must be between 1 and %d, got %d", s.maxGreetings, count)
+	}
+	interval := defaultGreetingInterval
+	if req.GetInterval() != nil {
+		if err := req.GetInterval().CheckValid(); err != nil {
+			return status.Errorf(codes.InvalidArgument, "interval: %v", err)
+		}
+		interval = req.GetInterval().AsDuration()
+		if interval < 0 || interval > s.maxGreetingInterval {
+			return status.Errorf(codes.InvalidArgument, "interval must be between 0 and %v, got %v", s.maxGreetingInterval, interval)
+		}
+	}
+	start := int(req.GetStartIndex())
+	if start < 0 || start > count {
+		return status.Errorf(codes.OutOfRange, "start_index must be between 0 and %d, got %d", count, start)
+	}
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for i := start; i < count; i++ {
+		select {
+		case <-ctx.Done():
+			fmt.Printf("GreetManyTimes stopped before greeting %d: %v\n", i, ctx.Err())
+			return status.FromContextError(ctx.Err()).Err()
+		case <-timer.C:
+		}
+
+		result, lang, err := s.greet(ctx, "GreetManyTimes", req.GetGreeting(), i18n.Data{Number: i, Count: count - i - 1})
+		if err != nil {
+			return err
+		}
 		res := &greetpb.GreetManyTimesResponse{
-			Result: result,
+			Result:   result,
+			Language: lang,
+			Number:   int32(i),
+		}
+		if err := stream.Send(res); err != nil {
+			fmt.Printf("GreetManyTimes stopped at greeting %d: %v\n", i, err)
+			return err
 		}
-		stream.Send(res)
-		time.Sleep(1000 * time.Millisecond)
+		timer.Reset(interval)
 	}
 	return nil
 }
 
 func (s *server) LongGreet(stream greetpb.GreetService_LongGreetServer) error {
 	fmt.Println("LongGreet function was invoked")
-	result := ""
+	// the first greeting decides the language and the variant
+	var first *greetpb.Greeting
+	var names []string
+	distinct := map[string]bool{}
 	for {
 		req, err := stream.Recv()
 		if err == io.EOF {
+			result, lang, err := s.greet(stream.Context(), "LongGreet", first, i18n.Data{Names: names, Count: len(names)})
+			if err != nil {
+				return err
+			}
 			return stream.SendAndClose(&greetpb.LongGreetResponse{
-				Result: result,
+				Result:        result,
+				Language:      lang,
+				Names:         names,
+				Count:         int32(len(names)),
+				DistinctCount: int32(len(distinct)),
 			})
 		}
-		if stream.Context().Err() == context.Canceled {
-			fmt.Println("request was canceled")
-			return err
-		}
 		if err != nil {
-			log.Fatalf("error while reading client stream: %v", err)
+			fmt.Printf("LongGreet stream ended: %v\n", err)
 			return err
 		}
-		firstName := req.GetGreeting().GetFirstName()
-		result += "Hello " + firstName + "!"
+		if len(names) == s.maxLongGreet {
+			return status.Errorf(codes.ResourceExhausted, "at most %d greetings can be sent", s.maxLongGreet)
+		}
+		if first == nil {
+			first = req.GetGreeting()
+		}
+		name := req.GetGreeting().GetFirstName()
+		names = append(names, name)
+		distinct[strings.ToLower(name)] = true
 	}
 }
 
 func (s *server) GreetEveryone(stream greetpb.GreetService_GreetEveryoneServer) error {
 	fmt.Println("GreetEveryone function was invoked")
+	ctx := stream.Context()
 
-	for {
-		req, err := stream.Recv()
-		if stream.Context().Err() == context.Canceled {
-			fmt.Println("request was canceled")
-			return stream.Context().Err()
-		}
+	// the first message joins the room
+	req, err := stream.Recv()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	room := defaultRoom
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get(roomMetadataKey)) > 0 {
+		room = md.Get(roomMetadataKey)[0]
+	} else if req.GetRoom() != "" {
+		room = req.GetRoom()
+	}
+	m := s.rooms.join(room, req.GetGreeting())
+	defer s.rooms.leave(m)
+	s.rooms.send(m, req.GetGreeting())
 
-		if err == io.EOF {
-			return nil
-		}
-		if err != nil {
-			log.Fatalf("error while reading client stream: %v", err)
-			return err
+	// greetings are read in the background, the events of the room are sent
+	// from here
+	errc := make(chan error, 1)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err == io.EOF {
+				// the events already queued are still sent
+				s.rooms.leave(m)
+				errc <- nil
+				return
+			}
+			if err != nil {
+				errc <- err
+				return
+			}
+			s.rooms.send(m, req.GetGreeting())
 		}
+	}()
 
-		firstName := req.GetGreeting().GetFirstName()
-		result := "hello " + firstName + "! "
-		errSend := stream.Send(&greetpb.GreetEveryoneResponse{Result: result})
-		if errSend != nil {
-			log.Fatalf("error while send data to client: %v", errSend)
-			return err
+	for {
+		select {
+		case ev, ok := <-m.events:
+			if !ok {
+				if s.rooms.isDropped(m) {
+					return status.Errorf(codes.ResourceExhausted, "too slow to follow room %s, join again", room)
+				}
+				return nil
+			}
+			res, err := s.roomResponse(ctx, m, ev)
+			if err != nil {
+				return err
+			}
+			if err := stream.Send(res); err != nil {
+				return err
+			}
+		case err := <-errc:
+			if err != nil {
+				fmt.Println("GreetEveryone stream ended:", err)
+				return err
+			}
+			errc = nil
 		}
 	}
 }
 
+// roomMessages are the messages of the room events
+var roomMessages = map[greetpb.GreetEveryoneResponse_Kind]string{
+	greetpb.GreetEveryoneResponse_GREETING: "GreetEveryone",
+	greetpb.GreetEveryoneResponse_JOINED:   "RoomJoined",
+	greetpb.GreetEveryoneResponse_LEFT:     "RoomLeft",
+	greetpb.GreetEveryoneResponse_DROPPED:  "RoomDropped",
+}
+
+// roomResponse formats a room event in the language of the member
+func (s *server) roomResponse(ctx context.Context, m *member, ev roomEvent) (*greetpb.GreetEveryoneResponse, error) {
+	result, lang, err := s.render(ctx, roomMessages[ev.kind], m.greeting.GetLanguage(), ev.greeting, i18n.Data{Count: ev.members})
+	if err != nil {
+		return nil, err
+	}
+	return &greetpb.GreetEveryoneResponse{
+		Result:   result,
+		Language: lang,
+		Kind:     ev.kind,
+		Room:     m.room,
+		From:     ev.greeting.GetFirstName(),
+		Members:  int32(ev.members),
+	}, nil
+}
+
+// greetWithDeadlineWork is how long GreetWithDeadline works on a greeting
+const greetWithDeadlineWork = 3 * time.Second
+
+// deadlineSlack is how early a client may see its deadline go by. The
+// client resets the stream when its deadline passes, which cancels the
+// context here a little before the deadline the server got.
+const deadlineSlack = 100 * time.Millisecond
+
+// nearDeadline reports whether a canceled context was canceled because its
+// deadline went by on the client
+func nearDeadline(ctx context.Context) bool {
+	d, ok := ctx.Deadline()
+	return ok && time.Until(d) < deadlineSlack
+}
+
 func (s *server) GreetWithDeadline(ctx context.Context, r *greetpb.GreetWithDeadlineRequest) (*greetpb.GreetWithDeadlineResponse, error) {
-	fmt.Println("GreetWithDeadline function was invoked with %v", r)
+	fmt.Printf("GreetWithDeadline function was invoked with %v\n", r)
+
+	// a request that cannot be done in time is not started
+	if err := deadline.Require(ctx, s.minWork); err != nil {
+		fmt.Println("not enough time left:", err)
+		return nil, err
+	}
 
-	for i := 0; i < 3; i++ {
-		if ctx.Err() == context.Canceled {
+	work := time.NewTimer(greetWithDeadlineWork)
+	defer work.Stop()
+	select {
+	case <-ctx.Done():
+		if ctx.Err() == context.Canceled && !nearDeadline(ctx) {
 			fmt.Println("client canceled the request")
-			return nil, status.Error(codes.DeadlineExceeded, "client canceled the request")
+			return nil, status.Error(codes.Canceled, "client canceled the request")
 		}
-		time.Sleep(1 * time.Second)
+		fmt.Println("deadline exceeded")
+		return nil, status.Error(codes.DeadlineExceeded, "deadline exceeded before the greeting was ready")
+	case <-work.C:
 	}
-	firstName := r.GetGreeting().GetFirstName()
 
-	result := "Hello " + firstName
-	res := &greetpb.GreetWithDeadlineResponse{Result: result}
+	result, lang, err := s.greet(ctx, "GreetWithDeadline", r.GetGreeting(), i18n.Data{})
+	if err != nil {
+		return nil, err
+	}
+	res := &greetpb.GreetWithDeadlineResponse{Result: result, Language: lang}
 
 	return res, nil
 }
 
 func main() {
+	templates := flag.String("templates", "", "directory of greeting templates laid out as <language>/<rpc>.tmpl, they replace the builtin ones and are reloaded on change")
+	templatesInterval := flag.Duration("templates-interval", i18n.DefaultInterval, "how often the templates directory is checked for changes")
+	roomQueue := flag.Int("room-queue", 64, "greetings queued for a GreetEveryone client before it is dropped as too slow")
+	maxGreetings := flag.Int("max-greetings", 100, "most greetings a GreetManyTimes request may ask for")
+	maxGreetingInterval := flag.Duration("max-greeting-interval", time.Minute, "longest interval a GreetManyTimes request may ask for")
+	maxLongGreet := flag.Int("max-long-greet", 1000, "most greetings a LongGreet client may send")
+	minWork := flag.Duration("min-work", greetWithDeadlineWork, "GreetWithDeadline requests with less time left before their deadline are rejected at once")
+	defaultDeadline := flag.Duration("default-deadline", 30*time.Second, "deadline of unary requests sent without one, 0 for none")
+	maxDeadline := flag.Duration("max-deadline", time.Minute, "longest deadline of unary requests, 0 for no limit")
+	clientCA := flag.String("client-ca", "ssl/ca.crt", "CA that signs client certificates, every client must present one; empty accepts clients without a certificate")
+	certFile := flag.String("tls-cert", "ssl/server.crt", "server certificate, reloaded when it changes")
+	keyFile := flag.String("tls-key", "ssl/server.pem", "key of the server certificate")
+	certInterval := flag.Duration("tls-reload-interval", certreload.DefaultInterval, "how often the certificate files are checked for changes")
+	metricsAddr := flag.String("metrics-addr", "", "address serving expvar metrics such as the certificate expiry, empty for none")
+	flag.Parse()
+	if *roomQueue < 1 {
+		log.Fatalf("-room-queue must be at least 1, got %d", *roomQueue)
+	}
+
 	fmt.Println("OK")
 	lis, err := net.Listen("tcp", "0.0.0.0:50051")
 	if err != nil {
@@ -125,18 +359,63 @@ func main() {
 	tls := true
 	opts := []grpc.ServerOption{}
 	if tls {
-		certFile := "ssl/server.crt"
-		keyFile := "ssl/server.pem"
-		creds, sslErr := credentials.NewServerTLSFromFile(certFile, keyFile)
+		certs, sslErr := certreload.New(*certFile, *keyFile, *clientCA)
 		if sslErr != nil {
 			log.Fatalf("failed loading certificate: %v", sslErr)
 			return
 		}
-		opts = append(opts, grpc.Creds(creds))
+		certs.Interval = *certInterval
+		go certs.Run(context.Background())
+		opts = append(opts, grpc.Creds(credentials.NewTLS(certs.Config())))
+	}
+
+	var messages func() *i18n.Catalog
+	if *templates == "" {
+		catalog, err := i18n.Load(i18n.Fallback, i18n.Builtin)
+		if err != nil {
+			log.Fatalf("failed loading messages: %v", err)
+		}
+		messages = func() *i18n.Catalog { return catalog }
+	} else {
+		reloader := &i18n.Reloader{Dir: *templates, Fallback: i18n.Fallback, Interval: *templatesInterval}
+		if err := reloader.Load(); err != nil {
+			log.Fatalf("failed loading templates: %v", err)
+		}
+		go reloader.Run(context.Background())
+		messages = reloader.Catalog
+	}
+
+	// streams may stay open as long as the client wants
+	deadlines := &deadline.Policy{
+		Methods: map[string]deadline.Limit{
+			"/greet.GreetService/GreetManyTimes": {},
+			"/greet.GreetService/LongGreet":      {},
+			"/greet.GreetService/GreetEveryone":  {},
+		},
+		Fallback: deadline.Limit{Default: *defaultDeadline, Max: *maxDeadline},
+	}
+	opts = append(opts,
+		grpc.ChainUnaryInterceptor(identityUnaryInterceptor, deadlines.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(identityStreamInterceptor, deadlines.StreamServerInterceptor()),
+	)
+
+	if *metricsAddr != "" {
+		go func() {
+			if err := http.ListenAndServe(*metricsAddr, expvar.Handler()); err != nil {
+				log.Fatalf("failed to serve metrics: %v", err)
+			}
+		}()
 	}
 
 	s := grpc.NewServer(opts...)
-	greetpb.RegisterGreetServiceServer(s, &server{})
+	greetpb.RegisterGreetServiceServer(s, &server{
+		messages:            messages,
+		rooms:               &rooms{queueSize: *roomQueue},
+		maxGreetings:        *maxGreetings,
+		maxGreetingInterval: *maxGreetingInterval,
+		maxLongGreet:        *maxLongGreet,
+		minWork:             *minWork,
+	})
 
 	if err := s.Serve(lis); err != nil {
 		log.Fatalf("falied to serve: %v", err)
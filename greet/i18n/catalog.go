@@ -0,0 +1,290 @@
+// Package i18n formats the greetings of the greet service in the language
+// of the client.
+//
+// A catalog holds one text/template set per language. A message is looked
+// up by name, usually the name of the RPC, with suffixes for the variant and
+// the CLDR plural form of Data.Count, the most specific one that exists is
+// used: "LongGreet.formal.one", "LongGreet.formal", "LongGreet.one" and
+// "LongGreet". A language without a message falls back to the default
+// language of the catalog.
+package i18n
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	pathpkg "path"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"golang.org/x/text/feature/plural"
+	"golang.org/x/text/language"
+	"google.golang.org/grpc/metadata"
+)
+
+// MetadataKey is the request metadata read when a greeting names no language
+const MetadataKey = "accept-language"
+
+// ErrNoMessage is returned when neither the language nor the default
+// language has the message
+var ErrNoMessage = errors.New("i18n: no such message")
+
+// Data is what a message template can use
+type Data struct {
+	FirstName string
+	LastName  string
+	// Honorific is "mr", "ms" or empty, templates turn it into a title
+	Honorific string
+	Formal    bool
+	// TimeOfDay is "morning", "midday", "afternoon" or "evening"
+	TimeOfDay string
+	// Number is the sequence number of a streamed greeting
+	Number int
+	// Count selects the plural form, what it counts depends on the message
+	Count int
+	Names []string
+}
+
+// PartOfDay returns the TimeOfDay of t
+func PartOfDay(t time.Time) string {
+	switch h := t.Hour(); {
+	case h >= 4 && h < 11:
+		return "morning"
+	case h >= 11 && h < 15:
+		return "midday"
+	case h >= 15 && h < 18:
+		return "afternoon"
+	default:
+		return "evening"
+	}
+}
+
+// Catalog holds the messages of every language
+type Catalog struct {
+	tags    []language.Tag
+	sets    map[language.Tag]*template.Template
+	matcher language.Matcher
+}
+
+// funcs are available to every template
+var funcs = template.FuncMap{
+	// list joins names as "a, b and c" with the given word for and
+	"list": func(and string, names []string) string {
+		switch len(names) {
+		case 0:
+			return ""
+		case 1:
+			return names[0]
+		}
+		return strings.Join(names[:len(names)-1], ", ") + " " + and + " " + names[len(names)-1]
+	},
+}
+
+// Load builds a catalog from layers of template files laid out as
+// <language>/<message>.tmpl, e.g. id/Greet.tmpl. A file defines the message
+// it is named after and may define its variants with
+// {{define "Greet.formal"}}, a later layer replaces the templates of the
+// same name of an earlier one. The language fallback is used for a request
+// no language matches.
+func Load(fallback string, layers ...fs.FS) (*Catalog, error) {
+	sources := map[string][]source{}
+	for _, layer := range layers {
+		files, err := templateFiles(layer)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range files {
+			text, err := fs.ReadFile(layer, path)
+			if err != nil {
+				return nil, fmt.Errorf("i18n: %w", err)
+			}
+			lang := pathpkg.Dir(path)
+			name := strings.TrimSuffix(pathpkg.Base(path), templateExt)
+			sources[lang] = append(sources[lang], source{name: name, path: path, text: string(text)})
+		}
+	}
+	return build(fallback, sources)
+}
+
+// source is the text of a template file
+type source struct {
+	name string
+	path string
+	text string
+}
+
+func build(fallback string, sources map[string][]source) (*Catalog, error) {
+	c := &Catalog{sets: map[language.Tag]*template.Template{}}
+	fallbackTag, err := language.Parse(fallback)
+	if err != nil {
+		return nil, fmt.Errorf("i18n: fallback language: %w", err)
+	}
+	// the matcher defaults to the first tag
+	c.tags = append(c.tags, fallbackTag)
+
+	for lang, srcs := range sources {
+		tag, err := language.Parse(lang)
+		if err != nil {
+			return nil, fmt.Errorf("i18n: language %q: %w", lang, err)
+		}
+		set, ok := c.sets[tag]
+		if !ok {
+			set = template.New(tag.String()).Funcs(funcs).Option("missingkey=error")
+			c.sets[tag] = set
+			if tag != fallbackTag {
+				c.tags = append(c.tags, tag)
+			}
+		}
+		// later sources redefine the templates of earlier ones
+		for _, src := range srcs {
+			if _, err := set.New(src.name).Parse(src.text); err != nil {
+				return nil, fmt.Errorf("i18n: %s: %w", src.path, err)
+			}
+		}
+	}
+	if _, ok := c.sets[fallbackTag]; !ok {
+		return nil, fmt.Errorf("i18n: no messages for the fallback language %s", fallbackTag)
+	}
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+	c.matcher = language.NewMatcher(c.tags)
+	return c, nil
+}
+
+// samples are executed by check, a template has to work for all of them
+var samples = []Data{
+	{},
+	{FirstName: "Ada", LastName: "Lovelace", Honorific: "ms", Formal: true, TimeOfDay: "morning", Number: 1, Count: 1, Names: []string{"Ada"}},
+	{FirstName: "Alan", Honorific: "mr", TimeOfDay: "evening", Number: 2, Count: 3, Names: []string{"Ada", "Alan", "Grace"}},
+}
+
+// check executes every template so mistakes that only show when a template
+// runs, like a misspelled field, are found on load
+func (c *Catalog) check() error {
+	for tag, set := range c.sets {
+		for _, tmpl := range set.Templates() {
+			for _, data := range samples {
+				if err := tmpl.Execute(io.Discard, data); err != nil {
+					return fmt.Errorf("i18n: template %s of %s: %w", tmpl.Name(), tag, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// templateExt is the extension of template files
+const templateExt = ".tmpl"
+
+// templateFiles lists the template files of fsys, sorted
+func templateFiles(fsys fs.FS) ([]string, error) {
+	langs, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("i18n: read templates: %w", err)
+	}
+	var files []string
+	for _, lang := range langs {
+		if !lang.IsDir() || strings.HasPrefix(lang.Name(), ".") {
+			continue
+		}
+		entries, err := fs.ReadDir(fsys, lang.Name())
+		if err != nil {
+			return nil, fmt.Errorf("i18n: read templates: %w", err)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || pathpkg.Ext(entry.Name()) != templateExt {
+				continue
+			}
+			files = append(files, pathpkg.Join(lang.Name(), entry.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Languages returns the languages of the catalog, the fallback first
+func (c *Catalog) Languages() []language.Tag {
+	return append([]language.Tag(nil), c.tags...)
+}
+
+// Negotiate picks the language of a request. requested is a tag or an
+// Accept-Language list, when empty the accept-language metadata is used.
+// Unknown, invalid or missing preferences get the fallback language.
+func (c *Catalog) Negotiate(ctx context.Context, requested string) language.Tag {
+	if requested == "" {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			requested = strings.Join(md.Get(MetadataKey), ",")
+		}
+	}
+	if requested == "" {
+		return c.tags[0]
+	}
+	preferred, _, err := language.ParseAcceptLanguage(requested)
+	if err != nil || len(preferred) == 0 {
+		return c.tags[0]
+	}
+	_, index, confidence := c.matcher.Match(preferred...)
+	if confidence == language.No {
+		return c.tags[0]
+	}
+	return c.tags[index]
+}
+
+// Render formats the message name in lang
+func (c *Catalog) Render(lang language.Tag, name string, data Data) (string, error) {
+	for _, tag := range []language.Tag{lang, c.tags[0]} {
+		set, ok := c.sets[tag]
+		if !ok {
+			continue
+		}
+		for _, candidate := range candidates(tag, name, data) {
+			tmpl := set.Lookup(candidate)
+			if tmpl == nil {
+				continue
+			}
+			var buf bytes.Buffer
+			if err := tmpl.Execute(&buf, data); err != nil {
+				return "", fmt.Errorf("i18n: %s of %s: %w", candidate, tag, err)
+			}
+			return strings.TrimSpace(buf.String()), nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrNoMessage, name)
+}
+
+// candidates lists the template names for a message, most specific first
+func candidates(tag language.Tag, name string, data Data) []string {
+	form := pluralForm(tag, data.Count)
+	var names []string
+	if data.Formal {
+		names = append(names, name+".formal."+form, name+".formal")
+	}
+	return append(names, name+"."+form, name)
+}
+
+// pluralForm returns the CLDR plural category of n in the language
+func pluralForm(tag language.Tag, n int) string {
+	if n < 0 {
+		n = -n
+	}
+	switch plural.Cardinal.MatchPlural(tag, n, 0, 0, 0, 0) {
+	case plural.Zero:
+		return "zero"
+	case plural.One:
+		return "one"
+	case plural.Two:
+		return "two"
+	case plural.Few:
+		return "few"
+	case plural.Many:
+		return "many"
+	default:
+		return "other"
+	}
+}
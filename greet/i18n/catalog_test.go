@@ -0,0 +1,123 @@
+package i18n
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"golang.org/x/text/language"
+	"google.golang.org/grpc/metadata"
+)
+
+// messages has every variant of Msg in en, the plain one in id and only
+// Other in fr, and Other in the fallback language en
+var messages = fstest.MapFS{
+	"en/Msg.tmpl": {Data: []byte(`en {{.Count}}
+{{define "Msg.one"}}en one{{end}}
+{{define "Msg.formal"}}en formal {{.Count}}{{end}}
+{{define "Msg.formal.one"}}en formal one{{end}}`)},
+	"en/Other.tmpl": {Data: []byte(`en other`)},
+	"id/Msg.tmpl":   {Data: []byte(`id {{.Count}}{{define "Msg.formal"}}id formal{{end}}`)},
+	"fr/Other.tmpl": {Data: []byte(`fr other{{define "Other.one"}}fr one{{end}}`)},
+}
+
+func TestRender(t *testing.T) {
+	c, err := Load("en", messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		lang string
+		msg  string
+		data Data
+		want string
+		err  error
+	}{
+		{name: "other", lang: "en", msg: "Msg", data: Data{Count: 3}, want: "en 3"},
+		{name: "zero is other in english", lang: "en", msg: "Msg", data: Data{Count: 0}, want: "en 0"},
+		{name: "one", lang: "en", msg: "Msg", data: Data{Count: 1}, want: "en one"},
+		{name: "negative one", lang: "en", msg: "Msg", data: Data{Count: -1}, want: "en one"},
+		{name: "formal", lang: "en", msg: "Msg", data: Data{Formal: true, Count: 2}, want: "en formal 2"},
+		{name: "formal one", lang: "en", msg: "Msg", data: Data{Formal: true, Count: 1}, want: "en formal one"},
+		{name: "no plural forms in indonesian", lang: "id", msg: "Msg", data: Data{Count: 1}, want: "id 1"},
+		{name: "formal without a plural form", lang: "id", msg: "Msg", data: Data{Formal: true, Count: 1}, want: "id formal"},
+		{name: "plain variant when there is no formal one", lang: "fr", msg: "Other", data: Data{Formal: true, Count: 1}, want: "fr one"},
+		{name: "zero is one in french", lang: "fr", msg: "Other", data: Data{Count: 0}, want: "fr one"},
+		{name: "fallback language", lang: "fr", msg: "Msg", data: Data{Count: 1}, want: "en one"},
+		{name: "unknown language", lang: "ja", msg: "Msg", data: Data{Count: 2}, want: "en 2"},
+		{name: "unknown message", lang: "en", msg: "Missing", err: ErrNoMessage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.Render(language.MustParse(tt.lang), tt.msg, tt.data)
+			if !errors.Is(err, tt.err) || (tt.err == nil && err != nil) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("Render = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuiltin(t *testing.T) {
+	c, err := Load(Fallback, Builtin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		lang string
+		data Data
+		want string
+	}{
+		{lang: "en", data: Data{Names: []string{"Ada"}, Count: 1}, want: "Hello Ada!"},
+		{lang: "en", data: Data{Names: []string{"Ada", "Alan", "Grace"}, Count: 3}, want: "Hello Ada, Alan and Grace! That is 3 people."},
+		{lang: "id", data: Data{Names: []string{"Ada"}, Count: 1}, want: "Halo Ada!"},
+		{lang: "id", data: Data{Names: []string{"Ada", "Alan"}, Count: 2}, want: "Halo Ada dan Alan! Semuanya 2 orang."},
+		{lang: "en", want: "Nobody to greet."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			got, err := c.Render(language.MustParse(tt.lang), "LongGreet", tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Render = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	c, err := Load("en", messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		requested string
+		md        metadata.MD
+		want      string
+	}{
+		{name: "tag", requested: "id", want: "id"},
+		{name: "region", requested: "fr-CA", want: "fr"},
+		{name: "q-values", requested: "fr;q=0.3, id;q=0.7", want: "id"},
+		{name: "next preference", requested: "ja, fr;q=0.5", want: "fr"},
+		{name: "unknown", requested: "ja", want: "en"},
+		{name: "invalid", requested: "id;q=x;;", want: "en"},
+		{name: "metadata", md: metadata.Pairs(MetadataKey, "id"), want: "id"},
+		{name: "request wins over metadata", requested: "fr", md: metadata.Pairs(MetadataKey, "id"), want: "fr"},
+		{name: "nothing", want: "en"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := metadata.NewIncomingContext(context.Background(), tt.md)
+			if got := c.Negotiate(ctx, tt.requested); got != language.MustParse(tt.want) {
+				t.Errorf("Negotiate = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package i18n
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// Fallback is the language of clients no language of the catalog matches
+const Fallback = "en"
+
+//go:embed templates
+var builtin embed.FS
+
+// Builtin are the messages the server ships with, in the layout Load
+// reads. Messages are named after the RPC, LongGreet counts the names,
+// GreetManyTimes counts the greetings still to come.
+var Builtin fs.FS
+
+func init() {
+	sub, err := fs.Sub(builtin, "templates")
+	if err != nil {
+		panic(err)
+	}
+	Builtin = sub
+}
@@ -0,0 +1,108 @@
+package i18n
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultInterval is used when Reloader.Interval is zero
+const DefaultInterval = 2 * time.Second
+
+// Reloader keeps a catalog of the builtin messages and the templates of Dir
+// up to date. It checks Dir every Interval and loads it again when a file
+// changed, templates that fail to load are logged and the last good catalog
+// stays in use.
+type Reloader struct {
+	Dir      string
+	Fallback string
+	Interval time.Duration
+
+	mu      sync.RWMutex
+	catalog *Catalog
+	// seen is the fingerprint of the last load, good or bad
+	seen string
+}
+
+// Load loads Dir when it changed since the last call. The server should not
+// start when the first call fails, Catalog returns nil until one succeeds.
+func (r *Reloader) Load() error {
+	dir := os.DirFS(r.Dir)
+	fingerprint, err := fingerprint(dir)
+	if err != nil {
+		return err
+	}
+
+	r.mu.RLock()
+	unchanged := fingerprint == r.seen && r.catalog != nil
+	r.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	catalog, err := Load(r.Fallback, Builtin, dir)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seen = fingerprint
+	if err != nil {
+		return fmt.Errorf("%w (in %s)", err, r.Dir)
+	}
+	r.catalog = catalog
+	return nil
+}
+
+// Catalog returns the last catalog that loaded
+func (r *Reloader) Catalog() *Catalog {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.catalog
+}
+
+// Run checks Dir every Interval until ctx is canceled
+func (r *Reloader) Run(ctx context.Context) {
+	interval := r.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			before := r.Catalog()
+			if err := r.Load(); err != nil {
+				log.Printf("templates not reloaded, the previous ones stay in use: %v", err)
+			} else if r.Catalog() != before {
+				log.Printf("templates reloaded from %s", r.Dir)
+			}
+		}
+	}
+}
+
+// fingerprint sums up the names, sizes and modification times of the
+// template files, so a change of any of them is noticed
+func fingerprint(fsys fs.FS) (string, error) {
+	files, err := templateFiles(fsys)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, path := range files {
+		info, err := fs.Stat(fsys, path)
+		if err != nil {
+			// removed since it was listed, the next check sees it gone
+			continue
+		}
+		fmt.Fprintf(&b, "%s %d %d\n", path, info.Size(), info.ModTime().UnixNano())
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,155 @@
+package i18n
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"golang.org/x/text/language"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name   string
+		layers []fstest.MapFS
+		want   string
+		err    string
+	}{
+		{
+			name:   "later layer wins",
+			layers: []fstest.MapFS{{"en/Greet.tmpl": {Data: []byte("builtin")}}, {"en/Greet.tmpl": {Data: []byte("custom")}}},
+			want:   "custom",
+		},
+		{
+			name:   "later layer defines it in another file",
+			layers: []fstest.MapFS{{"en/Greet.tmpl": {Data: []byte("builtin")}}, {"en/Extra.tmpl": {Data: []byte(`{{define "Greet"}}custom{{end}}`)}}},
+			want:   "custom",
+		},
+		{
+			name:   "files that are not templates",
+			layers: []fstest.MapFS{{"en/Greet.tmpl": {Data: []byte("builtin")}, "en/Greet.txt": {Data: []byte("{{")}, "en/.Greet.tmpl": {Data: []byte("{{")}}},
+			want:   "builtin",
+		},
+		{
+			name:   "syntax error",
+			layers: []fstest.MapFS{{"en/Greet.tmpl": {Data: []byte("{{.FirstName")}}},
+			err:    "en/Greet.tmpl",
+		},
+		{
+			name:   "misspelled field",
+			layers: []fstest.MapFS{{"en/Greet.tmpl": {Data: []byte("{{.FristName}}")}}},
+			err:    "FristName",
+		},
+		{
+			name:   "unknown template",
+			layers: []fstest.MapFS{{"en/Greet.tmpl": {Data: []byte(`{{template "missing" .}}`)}}},
+			err:    "missing",
+		},
+		{
+			name:   "no fallback messages",
+			layers: []fstest.MapFS{{"id/Greet.tmpl": {Data: []byte("halo")}}},
+			err:    "fallback",
+		},
+		{
+			name:   "invalid language",
+			layers: []fstest.MapFS{{"en/Greet.tmpl": {Data: []byte("hello")}, "not_a_tag!/Greet.tmpl": {Data: []byte("hello")}}},
+			err:    "not_a_tag!",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var layers []fs.FS
+			for _, layer := range tt.layers {
+				layers = append(layers, layer)
+			}
+			c, err := Load("en", layers...)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want one naming %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := c.Render(language.English, "Greet", Data{}); got != tt.want {
+				t.Errorf("Greet = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	write := func(text string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Join(dir, "en"), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, "en", "Greet.tmpl")
+		if err := os.WriteFile(path, []byte(text), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		// a new modification time even on file systems with coarse times
+		modified := time.Now().Add(time.Duration(len(text)) * time.Second)
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+	greet := func(r *Reloader) string {
+		t.Helper()
+		got, err := r.Catalog().Render(language.English, "Greet", Data{FirstName: "Ada"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	r := &Reloader{Dir: dir, Fallback: Fallback}
+	if err := r.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if got := greet(r); got != "Hello Ada" {
+		t.Errorf("builtin greeting %q", got)
+	}
+
+	write("Hi {{.FirstName}}")
+	if err := r.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if got := greet(r); got != "Hi Ada" {
+		t.Errorf("after a change: %q, want the new template", got)
+	}
+
+	unchanged := r.Catalog()
+	if err := r.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if r.Catalog() != unchanged {
+		t.Error("an unchanged directory was loaded again")
+	}
+
+	write("Hey {{.FristName}}")
+	if err := r.Load(); err == nil {
+		t.Fatal("a broken template loaded")
+	}
+	if got := greet(r); got != "Hi Ada" {
+		t.Errorf("after a broken change: %q, want the last good template", got)
+	}
+	// the broken files are not loaded again until they change
+	if err := r.Load(); err != nil {
+		t.Errorf("unchanged broken files: %v", err)
+	}
+
+	write("Good day {{.FirstName}}")
+	if err := r.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if got := greet(r); got != "Good day Ada" {
+		t.Errorf("after a fix: %q, want the fixed template", got)
+	}
+}
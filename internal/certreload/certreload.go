@@ -0,0 +1,212 @@
+// Package certreload serves TLS with certificates read again from their
+// files when they change, so a rotated certificate is used without a restart.
+//
+// A Reloader hands the current certificate to every handshake through
+// tls.Config.GetCertificate, and the current client CAs through
+// GetConfigForClient. New files are only swapped in once they load as a
+// whole, until then the previous ones stay in use. The expiry of every
+// certificate is published with expvar as tls_certificates.
+package certreload
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultInterval is used when Reloader.Interval is zero
+const DefaultInterval = 30 * time.Second
+
+// certificates is the expvar map of the certificates in use, keyed by file
+var certificates = expvar.NewMap("tls_certificates")
+
+// Reloader keeps the certificate of a server up to date
+type Reloader struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile, when set, requires client certificates signed by one of
+	// its CAs
+	ClientCAFile string
+	// ClientCertOptional verifies client certificates when they are sent
+	// instead of requiring them
+	ClientCertOptional bool
+	// Interval is how often the files are checked
+	Interval time.Duration
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	notAfter  time.Time
+	// seen is the fingerprint of the last load, good or bad
+	seen string
+}
+
+// New loads the files of a server certificate, the server should not start
+// when it fails
+func New(certFile, keyFile, clientCAFile string) (*Reloader, error) {
+	r := &Reloader{CertFile: certFile, KeyFile: keyFile, ClientCAFile: clientCAFile}
+	if err := r.Load(); err != nil {
+		return nil, err
+	}
+	certificates.Set(certFile, expvar.Func(r.metric))
+	return r, nil
+}
+
+// Load reads the files again when they changed since the last call
+func (r *Reloader) Load() error {
+	fingerprint, err := r.fingerprint()
+	if err != nil {
+		return err
+	}
+	r.mu.RLock()
+	unchanged := fingerprint == r.seen && r.cert != nil
+	r.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	cert, leaf, clientCAs, err := r.read()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seen = fingerprint
+	if err != nil {
+		return err
+	}
+	r.cert, r.clientCAs, r.notAfter = cert, clientCAs, leaf.NotAfter
+	return nil
+}
+
+func (r *Reloader) read() (*tls.Certificate, *x509.Certificate, *x509.CertPool, error) {
+	cert, err := tls.LoadX509KeyPair(r.CertFile, r.KeyFile)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("certreload: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("certreload: %s: %w", r.CertFile, err)
+	}
+	if time.Now().After(leaf.NotAfter) {
+		return nil, nil, nil, fmt.Errorf("certreload: %s expired on %s", r.CertFile, leaf.NotAfter.Format(time.RFC3339))
+	}
+	cert.Leaf = leaf
+
+	if r.ClientCAFile == "" {
+		return &cert, leaf, nil, nil
+	}
+	pem, err := os.ReadFile(r.ClientCAFile)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("certreload: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, nil, nil, fmt.Errorf("certreload: no certificate in %s", r.ClientCAFile)
+	}
+	return &cert, leaf, pool, nil
+}
+
+// fingerprint sums up the sizes and modification times of the files
+func (r *Reloader) fingerprint() (string, error) {
+	var b strings.Builder
+	for _, path := range []string{r.CertFile, r.KeyFile, r.ClientCAFile} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", fmt.Errorf("certreload: %w", err)
+		}
+		fmt.Fprintf(&b, "%s %d %d\n", path, info.Size(), info.ModTime().UnixNano())
+	}
+	return b.String(), nil
+}
+
+// NotAfter returns the expiry of the certificate in use
+func (r *Reloader) NotAfter() time.Time {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.notAfter
+}
+
+func (r *Reloader) metric() interface{} {
+	notAfter := r.NotAfter()
+	return map[string]interface{}{
+		"not_after":          notAfter.Unix(),
+		"expires_in_seconds": int64(time.Until(notAfter).Seconds()),
+	}
+}
+
+func (r *Reloader) current() *tls.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert
+}
+
+// GetCertificate is a tls.Config.GetCertificate returning the certificate
+// in use
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert := r.current()
+	if cert == nil {
+		return nil, errors.New("certreload: no certificate loaded")
+	}
+	return cert, nil
+}
+
+// Config returns the server tls.Config of the reloader
+func (r *Reloader) Config() *tls.Config {
+	base := &tls.Config{GetCertificate: r.GetCertificate}
+	if r.ClientCAFile == "" {
+		return base
+	}
+	// the client CAs of a handshake are taken from the last load, the config
+	// replaces the one grpc made from base so it has to offer h2 too
+	clientAuth := tls.RequireAndVerifyClientCert
+	if r.ClientCertOptional {
+		clientAuth = tls.VerifyClientCertIfGiven
+	}
+	base.ClientAuth = clientAuth
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		r.mu.RLock()
+		clientCAs := r.clientCAs
+		r.mu.RUnlock()
+		return &tls.Config{
+			GetCertificate: r.GetCertificate,
+			ClientCAs:      clientCAs,
+			ClientAuth:     clientAuth,
+			NextProtos:     []string{"h2"},
+		}, nil
+	}
+	return base
+}
+
+// Run checks the files every Interval until ctx is canceled
+func (r *Reloader) Run(ctx context.Context) {
+	interval := r.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			before := r.current()
+			if err := r.Load(); err != nil {
+				log.Printf("certificate not reloaded, the previous one stays in use: %v", err)
+			} else if r.current() != before {
+				log.Printf("certificate reloaded from %s, it expires on %s", r.CertFile, r.NotAfter().Format(time.RFC3339))
+			}
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package certreload
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// files are the PEM blocks of a self-signed certificate and its key
+type files struct {
+	cert, key []byte
+}
+
+func newFiles(t *testing.T, name string, notAfter time.Time) files {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    notAfter.Add(-48 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files{
+		cert: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		key:  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tomorrow := time.Now().Add(24 * time.Hour)
+	first := newFiles(t, "first", tomorrow)
+	second := newFiles(t, "second", tomorrow)
+	expired := newFiles(t, "expired", time.Now().Add(-time.Hour))
+
+	tests := []struct {
+		name string
+		// cert and key replace the files of first
+		cert, key []byte
+		// want is the name of the certificate in use after the load
+		want string
+		err  bool
+	}{
+		{name: "rotated", cert: second.cert, key: second.key, want: "second"},
+		{name: "not pem", cert: []byte("not a certificate"), key: second.key, want: "first", err: true},
+		{name: "key of another certificate", cert: second.cert, key: first.key, want: "first", err: true},
+		{name: "half written", cert: second.cert[:len(second.cert)/2], key: second.key, want: "first", err: true},
+		{name: "expired", cert: expired.cert, key: expired.key, want: "first", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			r := &Reloader{CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem")}
+			write := func(cert, key []byte, modified time.Time) {
+				t.Helper()
+				for path, data := range map[string][]byte{r.CertFile: cert, r.KeyFile: key} {
+					if err := os.WriteFile(path, data, 0o600); err != nil {
+						t.Fatal(err)
+					}
+					// a new modification time even on file systems with coarse times
+					if err := os.Chtimes(path, modified, modified); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			inUse := func() string {
+				t.Helper()
+				cert, err := r.GetCertificate(nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return cert.Leaf.Subject.CommonName
+			}
+
+			write(first.cert, first.key, time.Now().Add(-time.Minute))
+			if err := r.Load(); err != nil {
+				t.Fatal(err)
+			}
+			write(tt.cert, tt.key, time.Now())
+			if err := r.Load(); (err != nil) != tt.err {
+				t.Fatalf("err = %v, want an error %v", err, tt.err)
+			}
+			if got := inUse(); got != tt.want {
+				t.Errorf("certificate %s in use, want %s", got, tt.want)
+			}
+			if got := r.NotAfter(); !got.Equal(tomorrow.Truncate(time.Second)) {
+				t.Errorf("NotAfter = %v, want %v", got, tomorrow)
+			}
+			// files that failed are not read again until they change
+			if err := r.Load(); err != nil {
+				t.Errorf("second load: %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadClientCAs(t *testing.T) {
+	tomorrow := time.Now().Add(24 * time.Hour)
+	server := newFiles(t, "server", tomorrow)
+	ca := newFiles(t, "ca", tomorrow)
+
+	tests := []struct {
+		name string
+		ca   []byte
+		err  bool
+	}{
+		{name: "ca", ca: ca.cert},
+		{name: "no certificate", ca: []byte("not a certificate"), err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			r := &Reloader{CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem"), ClientCAFile: filepath.Join(dir, "ca.pem")}
+			for path, data := range map[string][]byte{r.CertFile: server.cert, r.KeyFile: server.key, r.ClientCAFile: tt.ca} {
+				if err := os.WriteFile(path, data, 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := r.Load()
+			if (err != nil) != tt.err {
+				t.Fatalf("err = %v, want an error %v", err, tt.err)
+			}
+			if err != nil {
+				if _, err := r.GetCertificate(nil); err == nil {
+					t.Error("a certificate is in use after a failed first load")
+				}
+				return
+			}
+			config, err := r.Config().GetConfigForClient(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.ClientCAs == nil || len(config.ClientCAs.Subjects()) != 1 {
+				t.Error("the handshake does not get the client CAs")
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+// Package deadline bounds how long a server works on a request. A Policy
+// gives every method a default deadline for requests that come without one
+// and a maximum that shortens longer ones, Require turns away requests that
+// cannot finish in the time they have left.
+package deadline
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Limit is the deadline policy of a method, zero values do not limit
+type Limit struct {
+	// Default is the deadline of requests that come without one
+	Default time.Duration
+	// Max shortens longer deadlines, and is the deadline of requests without
+	// one when Default is zero
+	Max time.Duration
+}
+
+// Policy holds the limits of the methods of a server
+type Policy struct {
+	// Methods are keyed by full method name, e.g. "/greet.GreetService/Greet"
+	Methods map[string]Limit
+	// Fallback is the limit of the methods not in Methods
+	Fallback Limit
+}
+
+// Limit returns the limit of a method
+func (p *Policy) Limit(fullMethod string) Limit {
+	if l, ok := p.Methods[fullMethod]; ok {
+		return l
+	}
+	return p.Fallback
+}
+
+// WithLimit returns ctx with the deadline the limit allows, cancel must be
+// called like the one of context.WithTimeout
+func WithLimit(ctx context.Context, l Limit) (context.Context, context.CancelFunc) {
+	timeout := l.Max
+	if deadline, ok := ctx.Deadline(); ok {
+		if l.Max <= 0 || time.Until(deadline) <= l.Max {
+			return ctx, func() {}
+		}
+	} else if l.Default > 0 && (l.Max <= 0 || l.Default < l.Max) {
+		timeout = l.Default
+	}
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// UnaryServerInterceptor applies the policy to unary calls
+func (p *Policy) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, cancel := WithLimit(ctx, p.Limit(info.FullMethod))
+		defer cancel()
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor applies the policy to streams, the whole stream
+// has to end before the deadline
+func (p *Policy) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, cancel := WithLimit(ss.Context(), p.Limit(info.FullMethod))
+		defer cancel()
+		if ctx == ss.Context() {
+			return handler(srv, ss)
+		}
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// Require returns a DeadlineExceeded status when ctx has less than need
+// left, so work that cannot finish in time is not started. A context
+// without a deadline has all the time it needs.
+func Require(ctx context.Context, need time.Duration) error {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return nil
+	}
+	if left := time.Until(deadline); left < need {
+		return status.Errorf(codes.DeadlineExceeded, "the deadline leaves %v, the work takes at least %v", left.Round(time.Millisecond), need)
+	}
+	return nil
+}
+
+// serverStream replaces the context of a grpc.ServerStream
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
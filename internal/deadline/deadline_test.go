@@ -0,0 +1,123 @@
+package deadline
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestWithLimit(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit Limit
+		// incoming is the deadline of the request, zero for none
+		incoming time.Duration
+		// want is the deadline after WithLimit, zero for none
+		want time.Duration
+	}{
+		{name: "no limit, no deadline"},
+		{name: "no limit", incoming: time.Hour, want: time.Hour},
+		{name: "default", limit: Limit{Default: time.Second}, want: time.Second},
+		{name: "default keeps a deadline", limit: Limit{Default: time.Second}, incoming: time.Hour, want: time.Hour},
+		{name: "max without a deadline", limit: Limit{Max: time.Minute}, want: time.Minute},
+		{name: "max shortens a deadline", limit: Limit{Max: time.Minute}, incoming: time.Hour, want: time.Minute},
+		{name: "max keeps a shorter deadline", limit: Limit{Max: time.Minute}, incoming: time.Second, want: time.Second},
+		{name: "default below max", limit: Limit{Default: time.Second, Max: time.Minute}, want: time.Second},
+		{name: "default above max", limit: Limit{Default: time.Hour, Max: time.Minute}, want: time.Minute},
+		{name: "default and max shorten a deadline", limit: Limit{Default: time.Second, Max: time.Minute}, incoming: time.Hour, want: time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.incoming > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.incoming)
+				defer cancel()
+			}
+			limited, cancel := WithLimit(ctx, tt.limit)
+			defer cancel()
+
+			deadline, ok := limited.Deadline()
+			if tt.want == 0 {
+				if ok {
+					t.Errorf("deadline in %v, want none", time.Until(deadline))
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("no deadline, want %v", tt.want)
+			}
+			if left := time.Until(deadline); left > tt.want || left < tt.want-time.Second/2 {
+				t.Errorf("deadline in %v, want %v", left, tt.want)
+			}
+			if tt.want == tt.incoming && limited != ctx {
+				t.Error("the context of a deadline within the limit was replaced")
+			}
+		})
+	}
+}
+
+func TestRequire(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		need    time.Duration
+		code    codes.Code
+	}{
+		{name: "no deadline", need: time.Hour, code: codes.OK},
+		{name: "enough time", timeout: time.Minute, need: time.Second, code: codes.OK},
+		{name: "too little time", timeout: time.Second, need: time.Minute, code: codes.DeadlineExceeded},
+		{name: "nothing needed", timeout: time.Second, code: codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			if got := status.Code(Require(ctx, tt.need)); got != tt.code {
+				t.Errorf("code = %v, want %v", got, tt.code)
+			}
+		})
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	p := &Policy{
+		Methods:  map[string]Limit{"/greet.GreetService/Greet": {Max: time.Second}},
+		Fallback: Limit{Default: time.Minute},
+	}
+	tests := []struct {
+		method string
+		want   time.Duration
+	}{
+		{method: "/greet.GreetService/Greet", want: time.Second},
+		{method: "/greet.GreetService/LongGreet", want: time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			var left time.Duration
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				deadline, ok := ctx.Deadline()
+				if !ok {
+					t.Fatal("the handler has no deadline")
+				}
+				left = time.Until(deadline)
+				return nil, nil
+			}
+			info := &grpc.UnaryServerInfo{FullMethod: tt.method}
+			if _, err := p.UnaryServerInterceptor()(context.Background(), nil, info, handler); err != nil {
+				t.Fatal(err)
+			}
+			if left > tt.want || left < tt.want-time.Second/2 {
+				t.Errorf("deadline in %v, want %v", left, tt.want)
+			}
+		})
+	}
+}